@@ -0,0 +1,177 @@
+package config
+
+import (
+	"crypto/rand"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoanConfig holds the settings that govern how long books are lent out, how often a loan can be renewed,
+// how long a copy is kept for a user whose hold became ready and how late returns are fined.
+// Amounts are in cents.
+type LoanConfig struct {
+	LoanPeriod       time.Duration
+	MaxRenewals      int
+	HoldPickupWindow time.Duration
+	FinePerDay       int
+	MaxUnpaidBalance int
+}
+
+// DefaultLoanConfig returns the loan settings used when nothing is configured
+func DefaultLoanConfig() LoanConfig {
+	return LoanConfig{
+		LoanPeriod:       14 * 24 * time.Hour,
+		MaxRenewals:      2,
+		HoldPickupWindow: 3 * 24 * time.Hour,
+		FinePerDay:       25,
+		MaxUnpaidBalance: 1000,
+	}
+}
+
+// LoadLoanConfig reads the loan settings from the environment variables LOAN_PERIOD_DAYS, MAX_RENEWALS,
+// HOLD_PICKUP_DAYS, FINE_PER_DAY and MAX_UNPAID_BALANCE, falling back to the defaults for any value that is missing or invalid
+func LoadLoanConfig() LoanConfig {
+	cfg := DefaultLoanConfig()
+
+	if days, ok := intFromEnv("LOAN_PERIOD_DAYS"); ok && days > 0 {
+		cfg.LoanPeriod = time.Duration(days) * 24 * time.Hour
+	}
+	if renewals, ok := intFromEnv("MAX_RENEWALS"); ok && renewals >= 0 {
+		cfg.MaxRenewals = renewals
+	}
+	if days, ok := intFromEnv("HOLD_PICKUP_DAYS"); ok && days > 0 {
+		cfg.HoldPickupWindow = time.Duration(days) * 24 * time.Hour
+	}
+	if fine, ok := intFromEnv("FINE_PER_DAY"); ok && fine >= 0 {
+		cfg.FinePerDay = fine
+	}
+	if balance, ok := intFromEnv("MAX_UNPAID_BALANCE"); ok && balance >= 0 {
+		cfg.MaxUnpaidBalance = balance
+	}
+
+	return cfg
+}
+
+// minSigningKeyLength is the shortest JWT_SIGNING_KEY accepted, in bytes, as HMAC-SHA256 keys should be at least 256 bits
+const minSigningKeyLength = 32
+
+// placeholderSigningKey is the example signing key that used to be shipped in .env, which is publicly known
+const placeholderSigningKey = "change-me-to-a-long-random-secret"
+
+// AuthConfig holds the key access and refresh tokens are signed with and how long they are valid
+type AuthConfig struct {
+	SigningKey      []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// DefaultAuthConfig returns the token lifetimes used when nothing is configured, with a random signing key
+func DefaultAuthConfig() AuthConfig {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		log.Fatalf("Error generating signing key: %v", err)
+	}
+
+	return AuthConfig{
+		SigningKey:      key,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+	}
+}
+
+// LoadAuthConfig reads the token settings from the environment variables JWT_SIGNING_KEY, ACCESS_TOKEN_MINUTES
+// and REFRESH_TOKEN_DAYS, falling back to the defaults for any value that is missing or invalid.
+// Without JWT_SIGNING_KEY tokens are signed with a random key and stop being valid when the server restarts.
+// The server refuses to start with the placeholder key or a key shorter than 32 bytes, as anyone who knows
+// or guesses the key can forge tokens.
+func LoadAuthConfig() AuthConfig {
+	cfg := DefaultAuthConfig()
+
+	if key := os.Getenv("JWT_SIGNING_KEY"); key != "" {
+		if key == placeholderSigningKey {
+			log.Fatalf("JWT_SIGNING_KEY is the placeholder key, set it to a long random secret")
+		}
+		if len(key) < minSigningKeyLength {
+			log.Fatalf("JWT_SIGNING_KEY must be at least %d bytes long", minSigningKeyLength)
+		}
+		cfg.SigningKey = []byte(key)
+	} else {
+		log.Println("JWT_SIGNING_KEY is not set, signing tokens with a random key")
+	}
+	if minutes, ok := intFromEnv("ACCESS_TOKEN_MINUTES"); ok && minutes > 0 {
+		cfg.AccessTokenTTL = time.Duration(minutes) * time.Minute
+	}
+	if days, ok := intFromEnv("REFRESH_TOKEN_DAYS"); ok && days > 0 {
+		cfg.RefreshTokenTTL = time.Duration(days) * 24 * time.Hour
+	}
+
+	return cfg
+}
+
+// ApiConfig holds when the routes outside of a versioned prefix were deprecated and when they will be removed,
+// how long the responses of requests with an idempotency key are kept for retries, and the largest request body
+// in bytes, which limits the size of imports
+type ApiConfig struct {
+	LegacyDeprecatedAt time.Time
+	LegacySunsetAt     time.Time
+	IdempotencyKeyTTL  time.Duration
+	BodyLimit          int
+}
+
+// DefaultApiConfig returns the API settings used when nothing is configured: the legacy routes were deprecated
+// when the versioned prefix was introduced and are removed six months later, idempotency keys are kept for a day,
+// and request bodies can be up to 32 MB
+func DefaultApiConfig() ApiConfig {
+	deprecatedAt := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	return ApiConfig{
+		LegacyDeprecatedAt: deprecatedAt,
+		LegacySunsetAt:     deprecatedAt.AddDate(0, 6, 0),
+		IdempotencyKeyTTL:  24 * time.Hour,
+		BodyLimit:          32 * 1024 * 1024,
+	}
+}
+
+// LoadApiConfig reads the date the legacy routes are removed from the environment variable LEGACY_ROUTES_SUNSET
+// in the form 2006-01-02, how long idempotency keys are kept from IDEMPOTENCY_KEY_TTL_HOURS and the largest request
+// body from BODY_LIMIT_MB, falling back to the defaults for any value that is missing or invalid
+func LoadApiConfig() ApiConfig {
+	cfg := DefaultApiConfig()
+
+	if hours, ok := intFromEnv("IDEMPOTENCY_KEY_TTL_HOURS"); ok && hours > 0 {
+		cfg.IdempotencyKeyTTL = time.Duration(hours) * time.Hour
+	}
+
+	if megabytes, ok := intFromEnv("BODY_LIMIT_MB"); ok && megabytes > 0 {
+		cfg.BodyLimit = megabytes * 1024 * 1024
+	}
+
+	if value := os.Getenv("LEGACY_ROUTES_SUNSET"); value != "" {
+		sunset, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			log.Printf("Invalid value for LEGACY_ROUTES_SUNSET: %v, using default", err)
+		} else {
+			cfg.LegacySunsetAt = sunset
+		}
+	}
+
+	return cfg
+}
+
+// intFromEnv reads an integer environment variable, reporting whether it was set to a valid value
+func intFromEnv(key string) (int, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %v, using default", key, err)
+		return 0, false
+	}
+
+	return parsed, true
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"kokal5296/database/migrations"
 	er "kokal5296/errors"
 	"log"
 )
@@ -17,6 +18,8 @@ const database = "database - "
 // DatabaseService interface defines methods for database-related operations
 type DatabaseService interface {
 	NewDatabase(connStr string, dbName string) (*PostgreSQLConnection, error)
+	Connect(connStr string, dbName string) (*PostgreSQLConnection, error)
+	Migrate() error
 	Close()
 	GetPool() *pgxpool.Pool
 }
@@ -26,11 +29,31 @@ func NewDatabaseService() DatabaseService {
 	return &PostgreSQLConnection{}
 }
 
-// NewDatabase initializes a connection to PostgreSQL, checks if the target database exists,
-// creates it if needed, and sets up the connection pool to the specific database.
+// NewDatabase connects to the target database, creating it if needed,
+// and brings its schema up to date by applying any pending migrations.
 func (db *PostgreSQLConnection) NewDatabase(connStr string, dbName string) (*PostgreSQLConnection, error) {
 
 	funcName := database + "NewDatabase,"
+	conn, err := db.Connect(connStr, dbName)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = db.Migrate()
+	if err != nil {
+		message := fmt.Sprintf("Unable to migrate database")
+		return nil, er.New(funcName, message, err)
+	}
+
+	return conn, nil
+}
+
+// Connect initializes a connection to PostgreSQL, checks if the target database exists,
+// creates it if needed, and sets up the connection pool to the specific database.
+// Unlike NewDatabase it does not touch the schema.
+func (db *PostgreSQLConnection) Connect(connStr string, dbName string) (*PostgreSQLConnection, error) {
+
+	funcName := database + "Connect,"
 	conn, err := pgxpool.Connect(context.Background(), connStr)
 	if err != nil {
 		message := fmt.Sprintf("Unable to connect to database")
@@ -68,49 +91,24 @@ func (db *PostgreSQLConnection) NewDatabase(connStr string, dbName string) (*Pos
 
 	log.Println("Database connection established")
 
-	err = db.CreateTablesIfNotExist()
-	if err != nil {
-		message := fmt.Sprintf("Unable to create tables")
-		return nil, er.New(funcName, message, err)
-	}
-
 	return &PostgreSQLConnection{Pool: pool}, nil
 }
 
-// CreateTablesIfNotExist creates tables if they do not exist
-func (db *PostgreSQLConnection) CreateTablesIfNotExist() error {
-	funcName := database + "CreateTablesIfNotExist,"
-
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-            id SERIAL PRIMARY KEY,
-            first_name VARCHAR(100) NOT NULL,
-            last_name VARCHAR(100) NOT NULL
-        );`,
-		`CREATE TABLE IF NOT EXISTS books (
-            id SERIAL PRIMARY KEY,
-            title VARCHAR(255) NOT NULL,
-            quantity INT NOT NULL CHECK (quantity >= 0)
-        );`,
-		`CREATE TABLE IF NOT EXISTS book_borrows (
-            id SERIAL PRIMARY KEY,
-            user_id INT NOT NULL REFERENCES users(id),
-            book_id INT NOT NULL REFERENCES books(id),
-            borrow_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            return_date TIMESTAMP WITH TIME ZONE,
-            CONSTRAINT unique_borrow UNIQUE(user_id, book_id, return_date)
-        );`,
+// Migrate applies all pending schema migrations
+func (db *PostgreSQLConnection) Migrate() error {
+	funcName := database + "Migrate,"
+
+	migrator, err := migrations.NewMigrator(db.Pool)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
-	for _, query := range queries {
-		_, err := db.Pool.Exec(context.Background(), query)
-		if err != nil {
-			message := fmt.Sprintf("Unable to create tables")
-			return er.New(funcName, message, err)
-		}
+	err = migrator.Up(context.Background())
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
-	log.Println("Tables created or already exist")
+	log.Println("Database schema is up to date")
 	return nil
 }
 
@@ -0,0 +1,297 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	er "kokal5296/errors"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+const migrations = "migrations - "
+
+// lockKey is the key of the PostgreSQL advisory lock held while migrations run,
+// so that several instances starting at the same time do not migrate concurrently
+const lockKey int64 = 5296_0001
+
+// fileNamePattern matches migration files named like 0001_create_users.up.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration represents a single numbered schema change with its up and down SQL
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts the embedded migrations against a database
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// NewMigrator creates a new Migrator for the given connection pool, loading the embedded migration files
+func NewMigrator(pool *pgxpool.Pool) (*Migrator, error) {
+	funcName := migrations + "NewMigrator,"
+
+	loaded, err := Load()
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &Migrator{
+		pool:       pool,
+		migrations: loaded,
+	}, nil
+}
+
+// Load reads the embedded migration files and returns them ordered by version.
+// Every version must have both an up and a down file, and versions must be numbered without gaps.
+func Load() ([]Migration, error) {
+	funcName := migrations + "Load,"
+
+	entries, err := sqlFiles.ReadDir("sql")
+	if err != nil {
+		message := fmt.Sprintf("Unable to read migration files")
+		return nil, er.New(funcName, message, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			message := fmt.Sprintf("Invalid migration file name: %s", entry.Name())
+			return nil, er.New(funcName, message, nil)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := sqlFiles.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			message := fmt.Sprintf("Unable to read migration file %s", entry.Name())
+			return nil, er.New(funcName, message, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			message := fmt.Sprintf("Migration %d has files with different names", version)
+			return nil, er.New(funcName, message, nil)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			message := fmt.Sprintf("Migration %d must have both an up and a down file", migration.Version)
+			return nil, er.New(funcName, message, nil)
+		}
+		result = append(result, *migration)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	for i, migration := range result {
+		if migration.Version != i+1 {
+			message := fmt.Sprintf("Migration versions must be sequential, expected %d but found %d", i+1, migration.Version)
+			return nil, er.New(funcName, message, nil)
+		}
+	}
+
+	return result, nil
+}
+
+// Up applies all migrations that have not been applied yet, in order
+func (m *Migrator) Up(ctx context.Context) error {
+	funcName := migrations + "Up,"
+
+	return m.withLock(ctx, funcName, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err = apply(ctx, conn, migration.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				message := fmt.Sprintf("Unable to apply migration %04d_%s", migration.Version, migration.Name)
+				return er.New(funcName, message, err)
+			}
+			log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the given number of most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	funcName := migrations + "Down,"
+
+	return m.withLock(ctx, funcName, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			err = apply(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				message := fmt.Sprintf("Unable to revert migration %04d_%s", migration.Version, migration.Name)
+				return er.New(funcName, message, err)
+			}
+			log.Printf("Reverted migration %04d_%s", migration.Version, migration.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Status returns every known migration together with the time it was applied, if it was
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	funcName := migrations + "Status,"
+
+	var result []MigrationStatus
+	err := m.withLock(ctx, funcName, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+			}
+			result = append(result, status)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// withLock acquires a dedicated connection, takes the migration advisory lock on it,
+// makes sure the schema_migrations table exists and runs fn while holding the lock
+func (m *Migrator) withLock(ctx context.Context, funcName string, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		message := fmt.Sprintf("Unable to acquire connection for migrations")
+		return er.New(funcName, message, err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		message := fmt.Sprintf("Unable to acquire migration lock")
+		return er.New(funcName, message, err)
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+		if err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );`)
+	if err != nil {
+		message := fmt.Sprintf("Unable to create schema_migrations table")
+		return er.New(funcName, message, err)
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns the applied migration versions mapped to the time they were applied
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	funcName := migrations + "appliedVersions,"
+
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		message := fmt.Sprintf("Unable to read applied migrations")
+		return nil, er.New(funcName, message, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			message := fmt.Sprintf("Unable to scan applied migration")
+			return nil, er.New(funcName, message, err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// apply runs the migration SQL and records the change in schema_migrations within a single transaction
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, record string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestLoad tests that the embedded migrations are complete and ordered
+func TestLoad(t *testing.T) {
+
+	loaded, err := Load()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, loaded)
+
+	for i, migration := range loaded {
+		assert.Equal(t, i+1, migration.Version)
+		assert.NotEmpty(t, migration.Name)
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS books (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL CHECK (quantity >= 0)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS book_borrows (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    book_id INT NOT NULL REFERENCES books(id),
+    borrow_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    return_date TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT unique_borrow UNIQUE(user_id, book_id, return_date)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS book_borrows_open_due_date_idx;
+
+ALTER TABLE book_borrows
+    DROP COLUMN IF EXISTS renewal_count,
+    DROP COLUMN IF EXISTS due_date;
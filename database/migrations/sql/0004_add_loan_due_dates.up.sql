@@ -0,0 +1,7 @@
+ALTER TABLE book_borrows
+    ADD COLUMN due_date TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT (CURRENT_TIMESTAMP + INTERVAL '14 days'),
+    ADD COLUMN renewal_count INT NOT NULL DEFAULT 0 CHECK (renewal_count >= 0);
+
+UPDATE book_borrows SET due_date = borrow_date + INTERVAL '14 days' WHERE borrow_date IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS book_borrows_open_due_date_idx ON book_borrows (due_date) WHERE return_date IS NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS holds (
+    id SERIAL PRIMARY KEY,
+    book_id INT NOT NULL REFERENCES books(id),
+    user_id INT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ready_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_user_book_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
+
+CREATE INDEX IF NOT EXISTS holds_queue_idx ON holds (book_id, created_at, id) WHERE status IN ('waiting', 'ready');
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    book_borrow_id INT REFERENCES book_borrows(id),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('fine', 'payment', 'waiver')),
+    amount INT NOT NULL CHECK (amount > 0),
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_idx ON ledger_entries (user_id, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS blocked,
+    DROP COLUMN IF EXISTS tier;
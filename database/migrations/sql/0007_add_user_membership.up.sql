@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN tier VARCHAR(20) NOT NULL DEFAULT 'standard' CHECK (tier IN ('standard', 'premium', 'staff')),
+    ADD COLUMN blocked BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+ALTER TABLE books ADD COLUMN quantity INT NOT NULL DEFAULT 0 CHECK (quantity >= 0);
+
+UPDATE books b SET quantity = (SELECT COUNT(*) FROM copies c WHERE c.book_id = b.id AND c.status IN ('available', 'reserved'));
+
+ALTER TABLE books ALTER COLUMN quantity DROP DEFAULT;
+
+ALTER TABLE holds DROP COLUMN IF EXISTS copy_id;
+
+ALTER TABLE book_borrows DROP COLUMN IF EXISTS copy_id;
+
+DROP TABLE IF EXISTS copies;
+
+DROP FUNCTION IF EXISTS copies_default_barcode();
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS copies (
+    id SERIAL PRIMARY KEY,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    barcode VARCHAR(64) NOT NULL UNIQUE,
+    condition VARCHAR(20) NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+    status VARCHAR(20) NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'reserved', 'damaged', 'lost', 'withdrawn')),
+    shelf_location VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS copies_book_status_idx ON copies (book_id, status);
+
+CREATE INDEX IF NOT EXISTS copies_shelf_location_idx ON copies (shelf_location);
+
+-- Copies added without a barcode get one generated from their id
+CREATE OR REPLACE FUNCTION copies_default_barcode() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.barcode IS NULL OR NEW.barcode = '' THEN
+        NEW.barcode := 'BB' || LPAD(NEW.id::text, 8, '0');
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER copies_default_barcode BEFORE INSERT ON copies
+    FOR EACH ROW EXECUTE FUNCTION copies_default_barcode();
+
+ALTER TABLE book_borrows ADD COLUMN copy_id INT REFERENCES copies(id);
+
+ALTER TABLE holds ADD COLUMN copy_id INT REFERENCES copies(id);
+
+-- Every book gets one copy on the shelf for each unit of its quantity,
+-- one copy on loan for each open borrow, and one of its shelf copies reserved for each ready hold
+INSERT INTO copies (book_id, barcode)
+SELECT b.id, NULL FROM books b, generate_series(1, b.quantity);
+
+DO $$
+DECLARE
+    loan RECORD;
+    ready_hold RECORD;
+    new_copy INT;
+BEGIN
+    FOR loan IN SELECT id, book_id FROM book_borrows WHERE return_date IS NULL ORDER BY id LOOP
+        INSERT INTO copies (book_id, barcode, status) VALUES (loan.book_id, NULL, 'on_loan') RETURNING id INTO new_copy;
+        UPDATE book_borrows SET copy_id = new_copy WHERE id = loan.id;
+    END LOOP;
+
+    FOR ready_hold IN SELECT id, book_id FROM holds WHERE status = 'ready' ORDER BY id LOOP
+        UPDATE copies SET status = 'reserved'
+        WHERE id = (SELECT id FROM copies WHERE book_id = ready_hold.book_id AND status = 'available' ORDER BY id LIMIT 1)
+        RETURNING id INTO new_copy;
+        UPDATE holds SET copy_id = new_copy WHERE id = ready_hold.id;
+    END LOOP;
+END $$;
+
+ALTER TABLE books DROP COLUMN quantity;
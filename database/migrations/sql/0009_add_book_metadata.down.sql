@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS book_authors;
+
+DROP TABLE IF EXISTS authors;
+
+ALTER TABLE books
+    DROP COLUMN IF EXISTS isbn,
+    DROP COLUMN IF EXISTS publisher,
+    DROP COLUMN IF EXISTS publication_year,
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS page_count;
@@ -0,0 +1,20 @@
+ALTER TABLE books
+    ADD COLUMN isbn VARCHAR(13) UNIQUE,
+    ADD COLUMN publisher VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN publication_year INT CHECK (publication_year > 0),
+    ADD COLUMN language VARCHAR(35) NOT NULL DEFAULT '',
+    ADD COLUMN page_count INT CHECK (page_count > 0);
+
+CREATE TABLE IF NOT EXISTS authors (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    author_id INT NOT NULL REFERENCES authors(id),
+    position INT NOT NULL,
+    PRIMARY KEY (book_id, author_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_idx ON book_authors (author_id);
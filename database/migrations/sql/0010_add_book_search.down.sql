@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS book_authors_refresh_search_vector ON book_authors;
+
+DROP FUNCTION IF EXISTS book_authors_refresh_search_vector();
+
+DROP TRIGGER IF EXISTS books_refresh_search_vector ON books;
+
+DROP FUNCTION IF EXISTS books_refresh_search_vector();
+
+DROP FUNCTION IF EXISTS book_search_vector(INT, TEXT, TEXT);
+
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,35 @@
+ALTER TABLE books ADD COLUMN search_vector tsvector NOT NULL DEFAULT ''::tsvector;
+
+-- The search vector weighs matches in the title above the authors, and the authors above the publisher
+CREATE OR REPLACE FUNCTION book_search_vector(book_id INT, book_title TEXT, book_publisher TEXT) RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', COALESCE(book_title, '')), 'A')
+        || setweight(to_tsvector('simple', COALESCE((
+            SELECT string_agg(a.name, ' ') FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = book_search_vector.book_id
+        ), '')), 'B')
+        || setweight(to_tsvector('simple', COALESCE(book_publisher, '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION books_refresh_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := book_search_vector(NEW.id, NEW.title, NEW.publisher);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_refresh_search_vector BEFORE INSERT OR UPDATE OF title, publisher ON books
+    FOR EACH ROW EXECUTE FUNCTION books_refresh_search_vector();
+
+CREATE OR REPLACE FUNCTION book_authors_refresh_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE books SET search_vector = book_search_vector(id, title, publisher)
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.book_id ELSE NEW.book_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_authors_refresh_search_vector AFTER INSERT OR DELETE ON book_authors
+    FOR EACH ROW EXECUTE FUNCTION book_authors_refresh_search_vector();
+
+UPDATE books SET search_vector = book_search_vector(id, title, publisher);
+
+CREATE INDEX IF NOT EXISTS books_search_vector_idx ON books USING GIN (search_vector);
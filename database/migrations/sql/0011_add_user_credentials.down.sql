@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS password_hash,
+    DROP COLUMN IF EXISTS email;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id SERIAL PRIMARY KEY,
+    actor_type VARCHAR(20) NOT NULL CHECK (actor_type IN ('user', 'api_key', 'system')),
+    actor_id INT,
+    action VARCHAR(20) NOT NULL,
+    entity_type VARCHAR(20) NOT NULL,
+    entity_id INT NOT NULL,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON audit_events (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_type, actor_id);
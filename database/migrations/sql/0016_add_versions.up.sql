@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE books ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -2,14 +2,78 @@ package errors
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"github.com/jackc/pgconn"
 	"log"
+	"net"
+)
+
+// Kind classifies an AppError, so that callers can react to the kind of failure instead of its message.
+// The zero value is Internal.
+type Kind int
+
+const (
+	Internal Kind = iota
+	NotFound
+	Conflict
+	Validation
+	Unavailable
+	Timeout
+	Unauthorized
+	Forbidden
+	PreconditionFailed
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "NotFound"
+	case Conflict:
+		return "Conflict"
+	case Validation:
+		return "Validation"
+	case Unavailable:
+		return "Unavailable"
+	case Timeout:
+		return "Timeout"
+	case Unauthorized:
+		return "Unauthorized"
+	case Forbidden:
+		return "Forbidden"
+	case PreconditionFailed:
+		return "PreconditionFailed"
+	default:
+		return "Internal"
+	}
+}
+
+// Sentinel errors for each kind. An AppError matches the sentinel of its kind with errors.Is,
+// e.g. errors.Is(err, ErrNotFound).
+var (
+	ErrInternal           = &AppError{Kind: Internal, Message: "internal error"}
+	ErrNotFound           = &AppError{Kind: NotFound, Message: "not found"}
+	ErrConflict           = &AppError{Kind: Conflict, Message: "conflict"}
+	ErrValidation         = &AppError{Kind: Validation, Message: "validation failed"}
+	ErrUnavailable        = &AppError{Kind: Unavailable, Message: "service unavailable"}
+	ErrTimeout            = &AppError{Kind: Timeout, Message: "operation timed out"}
+	ErrUnauthorized       = &AppError{Kind: Unauthorized, Message: "unauthorized"}
+	ErrForbidden          = &AppError{Kind: Forbidden, Message: "forbidden"}
+	ErrPreconditionFailed = &AppError{Kind: PreconditionFailed, Message: "precondition failed"}
+)
+
+// PostgreSQL error codes that are classified as conflicts
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
 )
 
 // AppError defines the structure for an application-specific error.
-// It includes a stack of function names, an error message, and an optional cause.
+// It includes a stack of function names, the kind of the error, an error message, and an optional cause.
 type AppError struct {
 	FuncStack []string
+	Kind      Kind
 	Message   string
 	Cause     error
 }
@@ -17,34 +81,78 @@ type AppError struct {
 // Error implements the error interface for the AppError struct.
 // It formats the error message including the function stack and the cause if available.
 func (e *AppError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s: %v", e.FuncStack, e.Cause)
+	}
 	if e.Cause != nil {
 		return fmt.Sprintf("%s: %s: %v", e.FuncStack, e.Message, e.Cause)
 	}
 	return fmt.Sprintf("%s: %s", e.FuncStack, e.Message)
 }
 
+// Unwrap returns the cause of the AppError, so that errors.Is and errors.As can inspect it.
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is the sentinel error of the kind of the AppError
+func (e *AppError) Is(target error) bool {
+	for _, sentinel := range []*AppError{ErrInternal, ErrNotFound, ErrConflict, ErrValidation, ErrUnavailable, ErrTimeout, ErrUnauthorized, ErrForbidden, ErrPreconditionFailed} {
+		if target == sentinel {
+			return e.Kind == sentinel.Kind
+		}
+	}
+	return false
+}
+
+// KindOf returns the kind of the first AppError in the chain of err that is not Internal.
+// Errors without a classified AppError are Internal.
+func KindOf(err error) Kind {
+	for err != nil {
+		if appErr, ok := err.(*AppError); ok && appErr.Kind != Internal {
+			return appErr.Kind
+		}
+		err = stderrors.Unwrap(err)
+	}
+	return Internal
+}
+
 // UnwrapError recursively unwraps errors that implement the Unwrap method.
-// It returns the innermost error in the chain.
+// It returns the innermost non-nil error in the chain.
 func UnwrapError(err error) error {
 	for {
-		if unwrappedErr, ok := err.(interface{ Unwrap() error }); ok {
-			err = unwrappedErr.Unwrap()
-		} else {
+		unwrappedErr, ok := err.(interface{ Unwrap() error })
+		if !ok || unwrappedErr.Unwrap() == nil {
 			return err
 		}
+		err = unwrappedErr.Unwrap()
 	}
 }
 
 // New creates a new AppError with the provided function name, message, and underlying cause.
+// The kind of the error is taken from the cause, so it is Internal unless the cause is classified.
 func New(funcName, message string, cause error) error {
 	return &AppError{
 		FuncStack: []string{funcName},
+		Kind:      classify(cause),
+		Message:   message,
+		Cause:     cause,
+	}
+}
+
+// NewKind creates a new AppError of the given kind with the provided function name, message, and underlying cause.
+func NewKind(funcName string, kind Kind, message string, cause error) error {
+	return &AppError{
+		FuncStack: []string{funcName},
+		Kind:      kind,
 		Message:   message,
 		Cause:     cause,
 	}
 }
 
 // Wrap takes an existing error and adds the current function name to its stack trace.
+// Errors that are not AppErrors are classified by their cause, e.g. a unique violation is a Conflict,
+// and get no message of their own, so their messages are not mistaken for messages of the application.
 func Wrap(funcName string, err error) error {
 	if appErr, ok := err.(*AppError); ok {
 		appErr.FuncStack = append(appErr.FuncStack, funcName)
@@ -52,17 +160,60 @@ func Wrap(funcName string, err error) error {
 	}
 	return &AppError{
 		FuncStack: []string{funcName},
-		Message:   err.Error(),
+		Kind:      classify(err),
 		Cause:     err,
 	}
 }
 
+// MessageOf returns the message of the innermost AppError in the chain of err that has one,
+// without the function stack. It returns an empty string if no AppError in the chain has a message.
+func MessageOf(err error) string {
+	message := ""
+	for err != nil {
+		if appErr, ok := err.(*AppError); ok && appErr.Message != "" {
+			message = appErr.Message
+		}
+		err = stderrors.Unwrap(err)
+	}
+	return message
+}
+
+// classify returns the kind of an error returned by the database or the context of a request
+func classify(err error) Kind {
+	if err == nil {
+		return Internal
+	}
+	if kind := KindOf(err); kind != Internal {
+		return kind
+	}
+
+	if stderrors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		return Timeout
+	}
+
+	var pgErr *pgconn.PgError
+	if stderrors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation, foreignKeyViolation:
+			return Conflict
+		}
+		return Internal
+	}
+
+	var netErr net.Error
+	if stderrors.As(err, &netErr) {
+		return Unavailable
+	}
+
+	return Internal
+}
+
 // HandleDeadlineExceededError checks if the given error is a context deadline exceeded error.
 func HandleDeadlineExceededError(packageName string, err error) error {
 	funcName := packageName + "HandleDeadlineExceededError"
-	if err == context.DeadlineExceeded {
+	if stderrors.Is(err, context.DeadlineExceeded) {
 		log.Printf("Operation timed out: %v", err)
-		return New(funcName, "Operation timed out: ", err)
+		return NewKind(funcName, Timeout, "Operation timed out: ", err)
 	}
 	return nil
 }
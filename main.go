@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/joho/godotenv"
+	"kokal5296/database"
+	"kokal5296/database/migrations"
 	"kokal5296/web/server"
 	"log"
 	"os"
+	"strconv"
 )
 
 func main() {
@@ -19,6 +24,15 @@ func main() {
 	connStr := os.Getenv("POSTGRESQL_URI")
 	dbName := os.Getenv("POSTGRESQL_DB_NAME")
 
+	// Run the migrate subcommand instead of the server when requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = migrate(connStr, dbName, os.Args[2:])
+		if err != nil {
+			log.Fatalf("Error running migrations: %v", err)
+		}
+		return
+	}
+
 	createServer := server.CreateServer(connStr, dbName)
 	log.Println("Server started")
 
@@ -28,3 +42,51 @@ func main() {
 	}
 
 }
+
+// migrate handles the "migrate up|down [steps]|status" subcommand
+func migrate(connStr, dbName string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	db, err := database.NewDatabaseService().Connect(connStr, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db.GetPool())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+}
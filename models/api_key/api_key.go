@@ -0,0 +1,37 @@
+package api_key
+
+import "time"
+
+// Scopes an API key can be given, each allowing a group of requests
+const (
+	ScopeCatalogRead  = "catalog:read"
+	ScopeCatalogWrite = "catalog:write"
+	ScopeLoansRead    = "loans:read"
+	ScopeLoansWrite   = "loans:write"
+	ScopeUsersRead    = "users:read"
+)
+
+// ApiKey represents a key machine clients authenticate with instead of a user.
+// Only a hash of the key is stored, so Key is only set in the response to creating the key.
+// Prefix is the start of the key, shown so that keys can be told apart.
+type ApiKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name" validate:"required,max=255"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes" validate:"required,min=1,unique,dive,oneof=catalog:read catalog:write loans:read loans:write users:read"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// HasScope reports whether the key was given the scope
+func (k ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
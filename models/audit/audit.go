@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Types of actors that make changes
+const (
+	ActorUser   = "user"
+	ActorApiKey = "api_key"
+	ActorSystem = "system"
+)
+
+// Actions recorded for changes
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionDelete     = "delete"
+	ActionRestore    = "restore"
+	ActionMembership = "membership"
+	ActionRole       = "role"
+	ActionBorrow     = "borrow"
+	ActionReturn     = "return"
+	ActionRenew      = "renew"
+)
+
+// Types of entities whose changes are recorded
+const (
+	EntityUser       = "user"
+	EntityBook       = "book"
+	EntityBookBorrow = "book_borrow"
+)
+
+// Event represents a recorded change of an entity, with the entity as it was before and after the change.
+// Before is null for created entities.
+type Event struct {
+	ID         int             `json:"id"`
+	ActorType  string          `json:"actor_type"`
+	ActorID    *int            `json:"actor_id,omitempty"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Actor represents who makes a change: a user or an API key with its id, or the system without one
+type Actor struct {
+	Type string
+	ID   *int
+}
+
+// actorKey is the type of ActorKey, so that it cannot collide with other context keys
+type actorKey struct{}
+
+// ActorKey is the key of the Actor in the context of a request. The authentication middleware stores
+// the actor in the locals of the request under it, where the services read it from the context.
+var ActorKey = actorKey{}
+
+// WithActor returns a copy of ctx that carries the actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, ActorKey, actor)
+}
+
+// ActorFrom returns the actor carried by ctx, or the system if there is none
+func ActorFrom(ctx context.Context) Actor {
+	actor, ok := ctx.Value(ActorKey).(Actor)
+	if !ok {
+		return Actor{Type: ActorSystem}
+	}
+	return actor
+}
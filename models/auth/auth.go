@@ -0,0 +1,36 @@
+package auth
+
+// Token types, so that a refresh token cannot be used as an access token and the other way around
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// Credentials represents the email and password a user logs in with
+type Credentials struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// RefreshRequest represents a request to exchange a refresh token for a new pair of tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenPair represents the tokens returned when a user logs in or refreshes their tokens.
+// ExpiresIn is the number of seconds the access token is valid for.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Claims represents the claims of a signed token: the id of the user as the subject,
+// the token type, and when the token was issued and expires as Unix times
+type Claims struct {
+	Subject   string `json:"sub"`
+	Type      string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
@@ -1,8 +1,25 @@
 package book
 
+import "time"
+
 // Book represents a book available in the library.
+// Quantity is the number of copies available for borrowing. When a book is created or updated,
+// copies are added or withdrawn from the shelf to match it. It must be at least 1: negative quantities are refused,
+// and so is 0, which cannot be told apart from a missing quantity, so the last copies are withdrawn by changing their status.
+// Books are unique by their ISBN, which is stored in its 13 digit form, so different editions
+// of the same title can be kept as separate books.
+// Deleted books are kept with the time they were deleted, so their loans remain, and can be restored.
+// Version counts the changes of the book and is returned as the ETag of the book instead of in the body.
 type Book struct {
-	ID       int    `json:"id"`
-	Title    string `json:"title" validate:"required"`
-	Quantity int    `json:"quantity" validate:"required"`
+	ID              int        `json:"id"`
+	Title           string     `json:"title" validate:"required,max=255"`
+	Quantity        int        `json:"quantity" validate:"required,min=0"`
+	ISBN            string     `json:"isbn,omitempty" validate:"omitempty,isbn_checksum"`
+	Authors         []string   `json:"authors,omitempty" validate:"unique,dive,required,max=255"`
+	Publisher       string     `json:"publisher,omitempty" validate:"max=255"`
+	PublicationYear int        `json:"publication_year,omitempty" validate:"omitempty,min=1,max=9999"`
+	Language        string     `json:"language,omitempty" validate:"max=35"`
+	PageCount       int        `json:"page_count,omitempty" validate:"omitempty,min=1"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	Version         int        `json:"-"`
 }
@@ -4,9 +4,27 @@ import "time"
 
 // BookBorrow represents the borrowing record of a book by a user.
 type BookBorrow struct {
-	ID          int        `json:"id"`
-	BookID      int        `json:"book_id" validate:"required"`
-	UserID      int        `json:"user_id" validate:"required"`
-	Borrow_date time.Time  `json:"borrow_date, omitempty"`
-	Return_date *time.Time `json:"return_date, omitempty"`
+	ID           int        `json:"id"`
+	BookID       int        `json:"book_id" validate:"required"`
+	UserID       int        `json:"user_id" validate:"required"`
+	CopyID       *int       `json:"copy_id,omitempty"`
+	Borrow_date  time.Time  `json:"borrow_date,omitempty"`
+	Return_date  *time.Time `json:"return_date,omitempty"`
+	Due_date     time.Time  `json:"due_date,omitempty"`
+	RenewalCount int        `json:"renewal_count"`
+}
+
+// Statuses of loans in the borrowing history
+const (
+	StatusOpen     = "open"
+	StatusReturned = "returned"
+	StatusAll      = "all"
+)
+
+// Loan represents a borrowing record in the borrowing history of a user or a book,
+// with the title of the book and the name of the user.
+type Loan struct {
+	BookBorrow
+	BookTitle string `json:"book_title"`
+	UserName  string `json:"user_name"`
 }
@@ -0,0 +1,52 @@
+package book_copy
+
+import "time"
+
+// Copy statuses, only available copies can be borrowed or reserved for a hold
+const (
+	StatusAvailable = "available"
+	StatusOnLoan    = "on_loan"
+	StatusReserved  = "reserved"
+	StatusDamaged   = "damaged"
+	StatusLost      = "lost"
+	StatusWithdrawn = "withdrawn"
+)
+
+// Copy conditions
+const (
+	ConditionNew  = "new"
+	ConditionGood = "good"
+	ConditionFair = "fair"
+	ConditionPoor = "poor"
+)
+
+// Copy represents a single physical copy of a book, identified by its barcode.
+// A barcode is generated when a copy is added without one.
+type Copy struct {
+	ID            int       `json:"id"`
+	BookID        int       `json:"book_id"`
+	Barcode       string    `json:"barcode" validate:"max=64"`
+	Condition     string    `json:"condition" validate:"omitempty,oneof=new good fair poor"`
+	Status        string    `json:"status" validate:"omitempty,oneof=available damaged lost withdrawn"`
+	ShelfLocation string    `json:"shelf_location" validate:"max=100"`
+	Created_at    time.Time `json:"created_at"`
+}
+
+// ShelfAudit represents the barcodes scanned on a shelf during an audit.
+type ShelfAudit struct {
+	ShelfLocation string   `json:"shelf_location" validate:"required"`
+	Barcodes      []string `json:"barcodes"`
+}
+
+// AuditReport represents the differences between a shelf audit and the recorded copies.
+// Missing copies should be on the shelf but were not scanned, misplaced copies were scanned but belong
+// to another shelf, unexpected copies were scanned but are not recorded as available, and unknown
+// barcodes do not belong to any copy.
+type AuditReport struct {
+	ShelfLocation string   `json:"shelf_location"`
+	Found         int      `json:"found"`
+	Missing       []Copy   `json:"missing"`
+	Misplaced     []Copy   `json:"misplaced"`
+	Unexpected    []Copy   `json:"unexpected"`
+	Unknown       []string `json:"unknown"`
+}
@@ -0,0 +1,49 @@
+package bulk
+
+import "kokal5296/models/problem"
+
+// Modes of an import. Insert only adds new items and reports rows matching an existing item as errors,
+// while upsert updates the items the rows match.
+const (
+	ModeInsert = "insert"
+	ModeUpsert = "upsert"
+)
+
+// Options represents how the rows of an import are applied.
+// With DryRun set, the rows are checked and reported as if they were imported, but nothing is changed.
+type Options struct {
+	Mode   string
+	DryRun bool
+}
+
+// Row represents an item read from an import, with the line of the upload it starts on
+type Row[T any] struct {
+	Line  int
+	Value T
+}
+
+// RowError represents a row of an import that was not imported, with the reason and, if the row failed
+// validation, its failed fields
+type RowError struct {
+	Line    int                  `json:"line"`
+	Message string               `json:"message"`
+	Errors  []problem.FieldError `json:"errors,omitempty"`
+}
+
+// Report represents the outcome of an import: the number of rows read, the number of items created
+// and updated, and the rows that were not imported, in the order of the upload.
+type Report struct {
+	Mode    string     `json:"mode"`
+	DryRun  bool       `json:"dry_run"`
+	Rows    int        `json:"rows"`
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Failed  int        `json:"failed"`
+	Errors  []RowError `json:"errors"`
+}
+
+// Fail records a row that was not imported
+func (r *Report) Fail(line int, message string, fieldErrors []problem.FieldError) {
+	r.Failed++
+	r.Errors = append(r.Errors, RowError{Line: line, Message: message, Errors: fieldErrors})
+}
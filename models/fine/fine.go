@@ -0,0 +1,28 @@
+package fine
+
+import "time"
+
+// Ledger entry kinds, fines increase a user's balance while payments and waivers reduce it
+const (
+	KindFine    = "fine"
+	KindPayment = "payment"
+	KindWaiver  = "waiver"
+)
+
+// LedgerEntry represents a single charge or credit on a user's account, amounts are in cents.
+type LedgerEntry struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	BookBorrowID *int      `json:"book_borrow_id,omitempty"`
+	Kind         string    `json:"kind"`
+	Amount       int       `json:"amount" validate:"required,gt=0"`
+	Note         string    `json:"note" validate:"max=255"`
+	Created_at   time.Time `json:"created_at"`
+}
+
+// Account represents a user's outstanding balance together with the entries it is made of.
+type Account struct {
+	UserID  int           `json:"user_id"`
+	Balance int           `json:"balance"`
+	Entries []LedgerEntry `json:"entries"`
+}
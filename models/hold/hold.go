@@ -0,0 +1,24 @@
+package hold
+
+import "time"
+
+// Hold statuses, a hold is active while it is waiting in the queue or ready for pickup
+const (
+	StatusWaiting   = "waiting"
+	StatusReady     = "ready"
+	StatusFulfilled = "fulfilled"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// Hold represents a user's place in the queue for a book that has no copies available.
+type Hold struct {
+	ID         int        `json:"id"`
+	BookID     int        `json:"book_id" validate:"required"`
+	UserID     int        `json:"user_id" validate:"required"`
+	CopyID     *int       `json:"copy_id,omitempty"`
+	Status     string     `json:"status"`
+	Created_at time.Time  `json:"created_at"`
+	Ready_at   *time.Time `json:"ready_at,omitempty"`
+	Expires_at *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,18 @@
+package idempotency
+
+// Headers of requests that are safe to retry and of their replayed responses
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+// MaxKeyLength is the longest idempotency key that is accepted
+const MaxKeyLength = 255
+
+// Response represents the stored response of a request with an idempotency key, which is replayed to its retries.
+// Headers holds the headers of the response that describe its body, such as its Content-Type and Location.
+type Response struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
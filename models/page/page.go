@@ -0,0 +1,78 @@
+package page
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Page represents one page of a list, with the cursor of the next page if there is one.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Params represents the requested page size, position, sort order and filters of a list.
+// Sort is the name of a sort column of the list, prefixed with '-' for descending order,
+// and Filters holds the filter values converted to the types of their filters.
+// IncludeDeleted requests soft deleted items as well, in lists of items that can be soft deleted.
+type Params struct {
+	Limit          int
+	Cursor         *Cursor
+	Sort           string
+	Filters        map[string]interface{}
+	IncludeDeleted bool
+}
+
+// Cursor represents the position after the last item of a page: the value of its sort column, which is nil
+// if the item has none, and its id.
+// The sort is kept so that a cursor cannot be used with a different sort order.
+type Cursor struct {
+	Sort  string  `json:"s"`
+	Value *string `json:"v"`
+	ID    int     `json:"id"`
+}
+
+// Column represents a column a list can be sorted by, with the PostgreSQL type of its values
+type Column struct {
+	Expr string
+	Type string
+}
+
+// Filter represents a condition a list can be filtered by. The condition refers to the filter value
+// with %[1]s, and the value is parsed as Type, which is one of "text", "int", "bool" or "time".
+type Filter struct {
+	Condition string
+	Type      string
+}
+
+// Spec represents the sort columns and filters that are allowed for a list.
+// SoftDelete is set for lists of items that can be soft deleted, which leave out deleted items unless requested.
+type Spec struct {
+	DefaultSort string
+	Sorts       map[string]Column
+	Filters     map[string]Filter
+	SoftDelete  bool
+}
+
+// Encode returns the opaque string form of the cursor, as returned in next_cursor
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned in next_cursor
+func DecodeCursor(encoded string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
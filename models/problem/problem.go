@@ -0,0 +1,27 @@
+package problem
+
+// ContentType is the media type of problem details responses
+const ContentType = "application/problem+json"
+
+// Problem represents the details of a failed request, as described by RFC 7807.
+// Rule and Errors are extension members: Rule names the loan policy rule that refused a borrow,
+// and Errors lists the fields of the request that failed validation.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Rule      string       `json:"rule,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError represents a field of a request that failed validation.
+// Field is the JSON path of the field, Rule is the validation that failed and Param its parameter, if any.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
@@ -1,8 +1,81 @@
 package user
 
+import "time"
+
+// Membership tiers, each with its own borrowing limits
+const (
+	TierStandard = "standard"
+	TierPremium  = "premium"
+	TierStaff    = "staff"
+)
+
+// Roles, which decide what a user is allowed to do. Patrons borrow for themselves,
+// librarians manage the catalog and process loans for anyone, and admins can also manage users and roles.
+const (
+	RolePatron    = "patron"
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+)
+
 // User represents a user with essential details for identification.
+// Users with an email and password can log in. The password is only accepted when a user is created
+// and is stored as a bcrypt hash, which is never returned.
+// Deleted users are kept with the time they were deleted, so their loans and history remain, and can be restored.
+// Version counts the changes of the user and is returned as the ETag of the user instead of in the body.
 type User struct {
-	ID        int    `json:"id"`
-	FirstName string `json:"first_name" validate:"required"`
-	LastName  string `json:"last_name" validate:"required"`
+	ID           int        `json:"id"`
+	FirstName    string     `json:"first_name" validate:"required"`
+	LastName     string     `json:"last_name" validate:"required"`
+	Tier         string     `json:"tier" validate:"omitempty,oneof=standard premium staff"`
+	Blocked      bool       `json:"blocked"`
+	Role         string     `json:"role" validate:"omitempty,oneof=patron librarian admin"`
+	Email        string     `json:"email,omitempty" validate:"required_with=Password,omitempty,email,max=255"`
+	Password     string     `json:"password,omitempty" validate:"required_with=Email,omitempty,min=8,max=72"`
+	PasswordHash string     `json:"-"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	Version      int        `json:"-"`
+}
+
+// IsStaff reports whether the user is a librarian or an admin, who can act on behalf of any user
+func (u User) IsStaff() bool {
+	return u.Role == RoleLibrarian || u.Role == RoleAdmin
+}
+
+// Import represents a user read from an import. Blocked is nil when the import does not say whether the user is
+// blocked, so that an upsert keeps the blocked state of an existing user and a new user is not blocked.
+type Import struct {
+	User
+	Blocked *bool `json:"blocked,omitempty"`
+}
+
+// RoleChange represents a request to change the role of a user
+type RoleChange struct {
+	Role string `json:"role" validate:"required,oneof=patron librarian admin"`
+}
+
+// Membership represents the membership tier of a user and whether their account is blocked from borrowing.
+type Membership struct {
+	Tier    string `json:"tier" validate:"required,oneof=standard premium staff"`
+	Blocked bool   `json:"blocked"`
+}
+
+// LoanPolicy represents the borrowing limits that apply to a membership tier.
+type LoanPolicy struct {
+	MaxConcurrentLoans int `json:"max_concurrent_loans"`
+	MaxLoansPerTitle   int `json:"max_loans_per_title"`
+}
+
+// TierPolicies holds the borrowing limits of every membership tier
+var TierPolicies = map[string]LoanPolicy{
+	TierStandard: {MaxConcurrentLoans: 5, MaxLoansPerTitle: 1},
+	TierPremium:  {MaxConcurrentLoans: 10, MaxLoansPerTitle: 1},
+	TierStaff:    {MaxConcurrentLoans: 20, MaxLoansPerTitle: 2},
+}
+
+// Policy returns the borrowing limits of the membership tier, falling back to the standard tier
+func (m Membership) Policy() LoanPolicy {
+	if policy, ok := TierPolicies[m.Tier]; ok {
+		return policy
+	}
+	return TierPolicies[TierStandard]
 }
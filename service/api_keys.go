@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/api_key"
+	"log"
+	"time"
+)
+
+type ApiKeyServiceStruct struct {
+	dbService database.DatabaseService
+}
+
+const apiKeyService = "apiKeyService - "
+
+// apiKeyPrefix starts every key, so that leaked keys are easy to recognize
+const apiKeyPrefix = "bbk_"
+
+// apiKeyColumns lists the api_keys columns in the order expected by scanApiKey
+const apiKeyColumns = `id, name, prefix, scopes, created_by, created_at, last_used_at, revoked_at`
+
+// ApiKeyService interface defines methods for issuing, revoking and authenticating API keys
+type ApiKeyService interface {
+	CreateApiKey(ctx context.Context, newKey api_key.ApiKey, createdBy *int) (*api_key.ApiKey, error)
+	GetApiKeys(ctx context.Context) ([]api_key.ApiKey, error)
+	RevokeApiKey(ctx context.Context, keyId int) error
+	AuthenticateApiKey(ctx context.Context, key string) (*api_key.ApiKey, error)
+}
+
+// NewApiKeyService creates a new instance of ApiKeyServiceStruct, implementing ApiKeyService
+func NewApiKeyService(dbService database.DatabaseService) ApiKeyService {
+	return &ApiKeyServiceStruct{
+		dbService: dbService,
+	}
+}
+
+// CreateApiKey issues a new key with the given name and scopes. The key is only returned here,
+// the database keeps its SHA-256 hash.
+func (s *ApiKeyServiceStruct) CreateApiKey(ctx context.Context, newKey api_key.ApiKey, createdBy *int) (*api_key.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := apiKeyService + "CreateApiKey"
+
+	prefix, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	key := apiKeyPrefix + prefix + "_" + secret
+
+	var created api_key.ApiKey
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by) VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + apiKeyColumns
+	row := s.dbService.GetPool().QueryRow(ctx, query, newKey.Name, apiKeyPrefix+prefix, hashApiKey(key), newKey.Scopes, createdBy)
+	err = scanApiKey(row, &created)
+	if err != nil {
+		if er.HandleDeadlineExceededError(apiKeyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error creating API key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	created.Key = key
+	log.Printf("API key %s created", created.Prefix)
+	return &created, nil
+}
+
+// GetApiKeys returns all keys, including revoked ones, newest first
+func (s *ApiKeyServiceStruct) GetApiKeys(ctx context.Context) ([]api_key.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := apiKeyService + "GetApiKeys"
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id DESC`
+	rows, err := s.dbService.GetPool().Query(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(apiKeyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting API keys: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	keys := []api_key.ApiKey{}
+	for rows.Next() {
+		var key api_key.ApiKey
+		err := scanApiKey(rows, &key)
+		if err != nil {
+			log.Printf("Error scanning API keys: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// RevokeApiKey revokes a key, after which it can no longer authenticate
+func (s *ApiKeyServiceStruct) RevokeApiKey(ctx context.Context, keyId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := apiKeyService + "RevokeApiKey"
+
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+	tag, err := s.dbService.GetPool().Exec(ctx, query, keyId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(apiKeyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error revoking API key: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		message := fmt.Sprintf("Active API key with id %d does not exist", keyId)
+		return er.NewKind(funcName, er.NotFound, message, nil)
+	}
+
+	return nil
+}
+
+// AuthenticateApiKey returns the key matching the given key if it has not been revoked, and records when it was used
+func (s *ApiKeyServiceStruct) AuthenticateApiKey(ctx context.Context, key string) (*api_key.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := apiKeyService + "AuthenticateApiKey"
+
+	var authenticated api_key.ApiKey
+	query := `UPDATE api_keys SET last_used_at = NOW() WHERE key_hash = $1 AND revoked_at IS NULL RETURNING ` + apiKeyColumns
+	err := scanApiKey(s.dbService.GetPool().QueryRow(ctx, query, hashApiKey(key)), &authenticated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, er.NewKind(funcName, er.Unauthorized, "Invalid API key", nil)
+		}
+		if er.HandleDeadlineExceededError(apiKeyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error authenticating API key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &authenticated, nil
+}
+
+// hashApiKey returns the hex encoded SHA-256 hash a key is stored as. Keys are long and random,
+// so unlike passwords they do not need a slow hash.
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomString returns n random bytes in the given encoding
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// scanApiKey scans a row selected with apiKeyColumns into the given ApiKey
+func scanApiKey(row pgx.Row, key *api_key.ApiKey) error {
+	return row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedBy, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+}
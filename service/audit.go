@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/audit"
+	"kokal5296/models/page"
+	"log"
+	"time"
+)
+
+type AuditServiceStruct struct {
+	dbService database.DatabaseService
+}
+
+const auditService = "auditService - "
+
+// auditEventColumns lists the audit_events columns in the order expected by scanAuditEvent
+const auditEventColumns = `id, actor_type, actor_id, action, entity_type, entity_id, before, after, created_at`
+
+// auditSnapshots holds for each entity type the query selecting an entity as JSON, as it is recorded in audit events.
+// Books are recorded with their available quantity and authors, and users without their password hash.
+var auditSnapshots = map[string]string{
+	audit.EntityUser: `SELECT to_jsonb(users) - 'password_hash' FROM users WHERE id = $1`,
+	audit.EntityBook: `SELECT to_jsonb(books) - 'search_vector' || jsonb_build_object(
+			'quantity', (SELECT COUNT(*) FROM copies c WHERE c.book_id = books.id AND c.status = 'available'),
+			'authors', (SELECT array_agg(a.name ORDER BY ba.position) FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id))
+		FROM books WHERE id = $1`,
+	audit.EntityBookBorrow: `SELECT to_jsonb(book_borrows) FROM book_borrows WHERE id = $1`,
+}
+
+// AuditListSpec lists the sort columns and filters allowed when listing audit events
+var AuditListSpec = page.Spec{
+	DefaultSort: "-id",
+	Sorts: map[string]page.Column{
+		"id":         {Expr: "id", Type: "int"},
+		"created_at": {Expr: "created_at", Type: "timestamptz"},
+	},
+	Filters: map[string]page.Filter{
+		"actor_type":  {Condition: `actor_type = %[1]s`, Type: "text"},
+		"actor_id":    {Condition: `actor_id = %[1]s`, Type: "int"},
+		"action":      {Condition: `action = %[1]s`, Type: "text"},
+		"entity_type": {Condition: `entity_type = %[1]s`, Type: "text"},
+		"entity_id":   {Condition: `entity_id = %[1]s`, Type: "int"},
+		"from":        {Condition: `created_at >= %[1]s`, Type: "time"},
+		"to":          {Condition: `created_at < %[1]s`, Type: "time"},
+	},
+}
+
+// AuditService interface defines methods for reading the recorded changes
+type AuditService interface {
+	GetAuditEvents(ctx context.Context, params page.Params) (*page.Page[audit.Event], error)
+}
+
+// NewAuditService creates a new instance of AuditServiceStruct, implementing AuditService
+func NewAuditService(dbService database.DatabaseService) AuditService {
+	return &AuditServiceStruct{
+		dbService: dbService,
+	}
+}
+
+// GetAuditEvents retrieves one page of the audit events from the database, the newest first by default
+func (s *AuditServiceStruct) GetAuditEvents(ctx context.Context, params page.Params) (*page.Page[audit.Event], error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := auditService + "GetAuditEvents"
+
+	events, err := listPage(ctx, s.dbService.GetPool(), AuditListSpec, params, auditEventColumns, "audit_events", nil, scanAuditEvent)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return events, nil
+}
+
+// auditSnapshot returns the entity as JSON as it is in the transaction, or nil if it does not exist
+func auditSnapshot(ctx context.Context, tx pgx.Tx, entityType string, entityId int) (json.RawMessage, error) {
+	funcName := auditService + "auditSnapshot"
+
+	var snapshot json.RawMessage
+	err := tx.QueryRow(ctx, auditSnapshots[entityType], entityId).Scan(&snapshot)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error taking snapshot of %s: %v", entityType, err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return snapshot, nil
+}
+
+// recordAuditEvent records a change of an entity made in the transaction by the actor of ctx.
+// The entity is recorded as before, taken with auditSnapshot ahead of the change, and as it is now.
+func recordAuditEvent(ctx context.Context, tx pgx.Tx, action string, entityType string, entityId int, before json.RawMessage) error {
+	funcName := auditService + "recordAuditEvent"
+
+	after, err := auditSnapshot(ctx, tx, entityType, entityId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	actor := audit.ActorFrom(ctx)
+	query := `INSERT INTO audit_events (actor_type, actor_id, action, entity_type, entity_id, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.Exec(ctx, query, actor.Type, actor.ID, action, entityType, entityId, nullJSON(before), nullJSON(after))
+	if err != nil {
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error recording audit event: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// recordAuditCreations records the creation of many entities made in the transaction by the actor of ctx,
+// sending the events in a single batch
+func recordAuditCreations(ctx context.Context, tx pgx.Tx, entityType string, entityIds []int) error {
+	funcName := auditService + "recordAuditCreations"
+
+	if len(entityIds) == 0 {
+		return nil
+	}
+
+	actor := audit.ActorFrom(ctx)
+	query := `INSERT INTO audit_events (actor_type, actor_id, action, entity_type, entity_id, after)
+		VALUES ($2, $3, $4, $5, $1, (` + auditSnapshots[entityType] + `))`
+
+	batch := &pgx.Batch{}
+	for _, entityId := range entityIds {
+		batch.Queue(query, entityId, actor.Type, actor.ID, audit.ActionCreate, entityType)
+	}
+
+	err := tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error recording audit events: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// execAudited runs a statement changing an entity in a new transaction, which also records the change as an audit event
+func execAudited(ctx context.Context, pool *pgxpool.Pool, action string, entityType string, entityId int, query string, args ...interface{}) error {
+	funcName := auditService + "execAudited"
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := auditSnapshot(ctx, tx, entityType, entityId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error changing %s: %v", entityType, err)
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, action, entityType, entityId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(auditService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing change of %s: %v", entityType, err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// nullJSON returns nil for a missing snapshot, so that it is stored as NULL rather than JSON null
+func nullJSON(snapshot json.RawMessage) interface{} {
+	if snapshot == nil {
+		return nil
+	}
+	return []byte(snapshot)
+}
+
+// scanAuditEvent scans a row selected with auditEventColumns into the given Event
+func scanAuditEvent(row pgx.Row, event *audit.Event) error {
+	return row.Scan(&event.ID, &event.ActorType, &event.ActorID, &event.Action, &event.EntityType, &event.EntityID,
+		&event.Before, &event.After, &event.CreatedAt)
+}
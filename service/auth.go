@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+	"kokal5296/config"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/auth"
+	"kokal5296/models/user"
+	"log"
+	"strconv"
+	"time"
+)
+
+const authService = "authService - "
+
+// invalidCredentials is the message of every failed login, so that it does not reveal which emails are registered
+const invalidCredentials = "Invalid email or password"
+
+// dummyPasswordHash is compared against when no user has the email, so that failed logins take as long either way
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+type AuthServiceStruct struct {
+	dbService   database.DatabaseService
+	userService UserService
+	authConfig  config.AuthConfig
+}
+
+// AuthService interface defines methods for logging in and authenticating users with tokens
+type AuthService interface {
+	Login(ctx context.Context, credentials auth.Credentials) (*auth.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error)
+	Authenticate(ctx context.Context, accessToken string) (*user.User, error)
+}
+
+// NewAuthService creates a new instance of AuthServiceStruct, implementing AuthService
+func NewAuthService(dbService database.DatabaseService, userService UserService, authConfig config.AuthConfig) AuthService {
+	return &AuthServiceStruct{
+		dbService:   dbService,
+		userService: userService,
+		authConfig:  authConfig,
+	}
+}
+
+// Login checks the email and password of a user and returns a new access and refresh token for them
+func (s *AuthServiceStruct) Login(ctx context.Context, credentials auth.Credentials) (*auth.TokenPair, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := authService + "Login"
+
+	var userId int
+	var passwordHash *string
+	query := `SELECT id, password_hash FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL`
+	err := s.dbService.GetPool().QueryRow(ctx, query, credentials.Email).Scan(&userId, &passwordHash)
+	if err != nil && err != pgx.ErrNoRows {
+		if er.HandleDeadlineExceededError(authService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting user credentials: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	hash := dummyPasswordHash
+	if err == nil && passwordHash != nil {
+		hash = []byte(*passwordHash)
+	}
+
+	mismatch := bcrypt.CompareHashAndPassword(hash, []byte(credentials.Password))
+	if err != nil || passwordHash == nil || mismatch != nil {
+		return nil, er.NewKind(funcName, er.Unauthorized, invalidCredentials, nil)
+	}
+
+	tokens, err := s.issueTokens(userId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	log.Printf("User %d logged in", userId)
+	return tokens, nil
+}
+
+// Refresh exchanges a valid refresh token for a new access and refresh token, as long as its user still exists
+func (s *AuthServiceStruct) Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error) {
+	funcName := authService + "Refresh"
+
+	authenticated, err := s.userForToken(ctx, refreshToken, auth.TokenTypeRefresh)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	tokens, err := s.issueTokens(authenticated.ID)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return tokens, nil
+}
+
+// Authenticate returns the user an access token was issued to
+func (s *AuthServiceStruct) Authenticate(ctx context.Context, accessToken string) (*user.User, error) {
+	funcName := authService + "Authenticate"
+
+	authenticated, err := s.userForToken(ctx, accessToken, auth.TokenTypeAccess)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return authenticated, nil
+}
+
+// userForToken verifies a token of the given type and returns its user.
+// Tokens of users that have been deleted are refused.
+func (s *AuthServiceStruct) userForToken(ctx context.Context, token string, tokenType string) (*user.User, error) {
+	funcName := authService + "userForToken"
+
+	claims, err := parseToken(token, s.authConfig.SigningKey, time.Now())
+	if err != nil {
+		return nil, er.NewKind(funcName, er.Unauthorized, "Invalid token", err)
+	}
+	if claims.Type != tokenType {
+		message := fmt.Sprintf("Token of type %s cannot be used, expected type %s", claims.Type, tokenType)
+		return nil, er.NewKind(funcName, er.Unauthorized, message, nil)
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, er.NewKind(funcName, er.Unauthorized, "Invalid token", err)
+	}
+
+	authenticated, err := s.userService.GetUser(ctx, userId, false)
+	if err != nil {
+		if er.KindOf(err) == er.NotFound {
+			return nil, er.NewKind(funcName, er.Unauthorized, "User of the token no longer exists", err)
+		}
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return authenticated, nil
+}
+
+// issueTokens signs a new access and refresh token for the user
+func (s *AuthServiceStruct) issueTokens(userId int) (*auth.TokenPair, error) {
+	funcName := authService + "issueTokens"
+
+	now := time.Now()
+	subject := strconv.Itoa(userId)
+
+	accessToken, err := signToken(auth.Claims{
+		Subject:   subject,
+		Type:      auth.TokenTypeAccess,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.authConfig.AccessTokenTTL).Unix(),
+	}, s.authConfig.SigningKey)
+	if err != nil {
+		log.Printf("Error signing access token: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	refreshToken, err := signToken(auth.Claims{
+		Subject:   subject,
+		Type:      auth.TokenTypeRefresh,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.authConfig.RefreshTokenTTL).Unix(),
+	}, s.authConfig.SigningKey)
+	if err != nil {
+		log.Printf("Error signing refresh token: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &auth.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.authConfig.AccessTokenTTL.Seconds()),
+	}, nil
+}
@@ -3,11 +3,18 @@ package service
 import (
 	"context"
 	"fmt"
+	"github.com/jackc/pgx/v4"
 	"kokal5296/database"
 	er "kokal5296/errors"
+	"kokal5296/models/audit"
 	"kokal5296/models/book"
+	"kokal5296/models/bulk"
+	"kokal5296/models/page"
 	"log"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type BookServiceStruct struct {
@@ -16,13 +23,44 @@ type BookServiceStruct struct {
 
 const bookService = "bookService - "
 
+// availableQuantity selects the number of available copies of the book in the current row of books
+const availableQuantity = `(SELECT COUNT(*) FROM copies c WHERE c.book_id = books.id AND c.status = 'available') AS quantity`
+
+// bookIsAvailable is the condition on the current row of books that holds when the book is not deleted and has
+// more copies on the shelf than users waiting for them, so the next user can borrow it
+const bookIsAvailable = `books.deleted_at IS NULL AND (SELECT COUNT(*) FROM copies c WHERE c.book_id = books.id AND c.status = 'available') >
+	(SELECT COUNT(*) FROM holds h WHERE h.book_id = books.id AND h.status = 'waiting')`
+
+// bookColumns lists the books columns, with the available quantity and authors, in the order expected by scanBook
+const bookColumns = `id, title, ` + availableQuantity + `, COALESCE(isbn, ''), publisher, COALESCE(publication_year, 0), language,
+	COALESCE(page_count, 0),
+	(SELECT array_agg(a.name ORDER BY ba.position) FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id) AS authors,
+	deleted_at, version`
+
+// BookPatchFields lists the fields of a book that can be changed with a merge patch
+var BookPatchFields = []string{"title", "quantity", "isbn", "authors", "publisher", "publication_year", "language", "page_count"}
+
+// bookPatchColumns holds the columns of the fields of a book merge patch that are stored in the books row
+var bookPatchColumns = map[string]patchColumn[book.Book]{
+	"title":            {`title = %s`, func(b book.Book) interface{} { return b.Title }},
+	"isbn":             {`isbn = NULLIF(%s, '')`, func(b book.Book) interface{} { return b.ISBN }},
+	"publisher":        {`publisher = %s`, func(b book.Book) interface{} { return b.Publisher }},
+	"publication_year": {`publication_year = NULLIF(%s, 0)`, func(b book.Book) interface{} { return b.PublicationYear }},
+	"language":         {`language = %s`, func(b book.Book) interface{} { return b.Language }},
+	"page_count":       {`page_count = NULLIF(%s, 0)`, func(b book.Book) interface{} { return b.PageCount }},
+}
+
 // BookService interface defines methods for book-related operations
 type BookService interface {
 	CreateBook(ctx context.Context, newBook book.Book) error
-	GetBook(ctx context.Context, bookId int) (*book.Book, error)
-	GetAllBooks(ctx context.Context) ([]book.Book, error)
-	UpdateBook(ctx context.Context, bookId int, updatedBook book.Book) error
+	GetBook(ctx context.Context, bookId int, includeDeleted bool) (*book.Book, error)
+	GetAllBooks(ctx context.Context, params page.Params) (*page.Page[book.Book], error)
+	SearchBooks(ctx context.Context, search string, onlyAvailable bool, limit int) ([]book.Book, error)
+	UpdateBook(ctx context.Context, bookId int, version int, updatedBook book.Book) error
+	PatchBook(ctx context.Context, bookId int, version int, patch book.Book, fields []string) error
 	DeleteBook(ctx context.Context, bookId int) error
+	RestoreBook(ctx context.Context, bookId int) error
+	ImportBooks(ctx context.Context, rows []bulk.Row[book.Book], options bulk.Options) (*bulk.Report, error)
 }
 
 // NewBookService creates a new instance of BookServiceStruct, implementing BookService
@@ -32,21 +70,34 @@ func NewBookService(dbService database.DatabaseService) BookService {
 	}
 }
 
-// CreateBook creates a new book in the database
+// CreateBook creates a new book in the database, together with its authors and as many available copies as its quantity,
+// and records it in the audit log. A book with the same ISBN must not exist yet.
 func (s *BookServiceStruct) CreateBook(ctx context.Context, newBook book.Book) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := bookService + "CreateBook"
 
-	log.Printf("book: %v", newBook)
-	err := s.titleExists(ctx, newBook)
+	err := s.isbnExists(ctx, newBook.ISBN, 0)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
 	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
 		return er.Wrap(funcName, err)
 	}
+	defer tx.Rollback(ctx)
 
-	query := `INSERT INTO books (title, quantity) VALUES ($1, $2)`
-	_, err = s.dbService.GetPool().Exec(ctx, query, newBook.Title, newBook.Quantity)
+	var bookId int
+	query := `INSERT INTO books (title, isbn, publisher, publication_year, language, page_count)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, 0), $5, NULLIF($6, 0)) RETURNING id`
+	err = tx.QueryRow(ctx, query, newBook.Title, newBook.ISBN, newBook.Publisher, newBook.PublicationYear,
+		newBook.Language, newBook.PageCount).Scan(&bookId)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return er.Wrap(funcName, err)
@@ -55,23 +106,51 @@ func (s *BookServiceStruct) CreateBook(ctx context.Context, newBook book.Book) e
 		return er.Wrap(funcName, err)
 	}
 
+	err = setAuthors(ctx, tx, bookId, newBook.Authors)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = adjustAvailableCopies(ctx, tx, bookId, newBook.Quantity)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionCreate, audit.EntityBook, bookId, nil)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
 	return nil
 }
 
-// GetBook retrieves a book from the database by its ID
-func (s *BookServiceStruct) GetBook(ctx context.Context, bookId int) (*book.Book, error) {
+// GetBook retrieves a book from the database by its ID. Deleted books are only found with includeDeleted set.
+func (s *BookServiceStruct) GetBook(ctx context.Context, bookId int, includeDeleted bool) (*book.Book, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := bookService + "GetBook"
 
 	var book book.Book
-	query := `SELECT id, title, quantity FROM books WHERE id = $1`
-	err := s.dbService.GetPool().QueryRow(ctx, query, bookId).Scan(&book.ID, &book.Title, &book.Quantity)
+	query := `SELECT ` + bookColumns + ` FROM books WHERE id = $1 AND ($2 OR deleted_at IS NULL)`
+	err := scanBook(s.dbService.GetPool().QueryRow(ctx, query, bookId, includeDeleted), &book)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Book with id %d does not exist", bookId)
+			return nil, er.NewKind(funcName, er.NotFound, message, err)
+		}
 		log.Printf("Error getting book: %v", err)
 		return nil, er.Wrap(funcName, err)
 	}
@@ -79,28 +158,53 @@ func (s *BookServiceStruct) GetBook(ctx context.Context, bookId int) (*book.Book
 	return &book, nil
 }
 
-// GetAllBooks retrieves all books from the database
-func (s *BookServiceStruct) GetAllBooks(ctx context.Context) ([]book.Book, error) {
+// GetAllBooks retrieves one page of the books from the database
+func (s *BookServiceStruct) GetAllBooks(ctx context.Context, params page.Params) (*page.Page[book.Book], error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := bookService + "GetAllBooks"
 
-	var books []book.Book
-	query := `SELECT id, title, quantity FROM books`
-	rows, err := s.dbService.GetPool().Query(ctx, query)
+	books, err := listPage(ctx, s.dbService.GetPool(), BookListSpec, params, bookColumns, "books", nil, scanBook)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return books, nil
+}
+
+// SearchBooks returns up to limit books whose title, authors or publisher contain every word of the search,
+// or words starting with it, the best matches first. Deleted books are left out, and with onlyAvailable set,
+// so are books that cannot be borrowed.
+func (s *BookServiceStruct) SearchBooks(ctx context.Context, search string, onlyAvailable bool, limit int) ([]book.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookService + "SearchBooks"
+
+	books := []book.Book{}
+	tsQuery := prefixTsQuery(search)
+	if tsQuery == "" {
+		return books, nil
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM books
+		WHERE search_vector @@ to_tsquery('simple', $1) AND deleted_at IS NULL AND ($2 = false OR ` + bookIsAvailable + `)
+		ORDER BY ts_rank(search_vector, to_tsquery('simple', $1)) DESC, id
+		LIMIT $3`
+	rows, err := s.dbService.GetPool().Query(ctx, query, tsQuery, onlyAvailable, limit)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
-		log.Printf("Error getting books: %v", err)
+		log.Printf("Error searching books: %v", err)
 		return nil, er.Wrap(funcName, err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var book book.Book
-		err := rows.Scan(&book.ID, &book.Title, &book.Quantity)
+		err := scanBook(rows, &book)
 		if err != nil {
 			log.Printf("Error scanning books: %v", err)
 			return nil, er.Wrap(funcName, err)
@@ -111,12 +215,12 @@ func (s *BookServiceStruct) GetAllBooks(ctx context.Context) ([]book.Book, error
 	return books, nil
 }
 
-// UpdateBook updates a book in the database by its ID.
-// It checks if the book exists and if the title and id of the book match, and if the do match,
-// it doesn't check if the title already exists in the database, because that means we are updating same book.
-// Which allows to change only quantity of the book.
-// If the title is different, it checks if the title already exists.
-func (s *BookServiceStruct) UpdateBook(ctx context.Context, bookId int, updatedBook book.Book) error {
+// UpdateBook updates a book in the database by its ID, replacing its metadata and authors.
+// It checks if the book exists and that no other book has the same ISBN.
+// Available copies are added or withdrawn so that their number matches the quantity of the updated book.
+// The book before and after the update is recorded in the audit log. The book is only updated if it is still
+// at the given version, or at any version if it is 0.
+func (s *BookServiceStruct) UpdateBook(ctx context.Context, bookId int, version int, updatedBook book.Book) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
@@ -127,44 +231,173 @@ func (s *BookServiceStruct) UpdateBook(ctx context.Context, bookId int, updatedB
 		return er.Wrap(funcName, err)
 	}
 
-	ok, err := s.bookAndTitleMatch(ctx, bookId, updatedBook)
+	err = s.isbnExists(ctx, updatedBook.ISBN, bookId)
 	if err != nil {
 		return er.Wrap(funcName, err)
 	}
-	if !ok {
-		err = s.titleExists(ctx, updatedBook)
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockVersion(ctx, tx, audit.EntityBook, bookId, version)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = replaceBook(ctx, tx, bookId, updatedBook)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing book update: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// PatchBook changes only the given fields of a book to their values in the patch, in a single update of the
+// books row. Authors are replaced and copies are added or withdrawn only if they are among the fields.
+// The book is only changed if it is still at the given version, or at any version if it is 0.
+func (s *BookServiceStruct) PatchBook(ctx context.Context, bookId int, version int, patch book.Book, fields []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookService + "PatchBook"
+
+	if slices.Contains(fields, "isbn") {
+		err := s.isbnExists(ctx, patch.ISBN, bookId)
 		if err != nil {
 			return er.Wrap(funcName, err)
 		}
 	}
 
-	query := `UPDATE books SET title = $1, quantity = $2 WHERE id = $3`
-	_, err = s.dbService.GetPool().Exec(ctx, query, updatedBook.Title, updatedBook.Quantity, bookId)
+	tx, err := s.dbService.GetPool().Begin(ctx)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
-		log.Printf("Error updating book: %v", err)
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockVersion(ctx, tx, audit.EntityBook, bookId, version)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityBook, bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	assignments, args := patchAssignments(bookPatchColumns, patch, fields)
+	query := fmt.Sprintf(`UPDATE books SET %s WHERE id = $%d`, strings.Join(assignments, ", "), len(args)+1)
+	_, err = tx.Exec(ctx, query, append(args, bookId)...)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error patching book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if slices.Contains(fields, "authors") {
+		err = setAuthors(ctx, tx, bookId, patch.Authors)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	if slices.Contains(fields, "quantity") {
+		err = adjustAvailableCopies(ctx, tx, bookId, patch.Quantity)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionUpdate, audit.EntityBook, bookId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing book patch: %v", err)
 		return er.Wrap(funcName, err)
 	}
 
 	return nil
 }
 
-// DeleteBook deletes a book from the database by its ID, if it exists
+// DeleteBook soft deletes a book by its ID, if it exists. The book is kept, with its copies and loans, until restored.
+// Books with copies that are not returned yet or with active holds cannot be deleted.
+// The book row is locked, so the book cannot be borrowed or held while being deleted.
 func (s *BookServiceStruct) DeleteBook(ctx context.Context, bookId int) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := bookService + "DeleteBook"
 
-	err := s.bookExists(ctx, bookId)
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	var openLoans, activeHolds bool
+	query := `SELECT EXISTS (SELECT 1 FROM book_borrows WHERE book_id = $1 AND return_date IS NULL),
+		EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND status IN ('waiting', 'ready'))`
+	err = tx.QueryRow(ctx, query, bookId).Scan(&openLoans, &activeHolds)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error checking loans and holds of book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if openLoans {
+		message := fmt.Sprintf("Book with id %d has copies that are not returned yet", bookId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+	if activeHolds {
+		message := fmt.Sprintf("Book with id %d has active holds, which must be cancelled first", bookId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityBook, bookId)
 	if err != nil {
 		return er.Wrap(funcName, err)
 	}
 
-	query := `DELETE FROM books WHERE id = $1`
-	_, err = s.dbService.GetPool().Exec(ctx, query, bookId)
+	query = `UPDATE books SET deleted_at = NOW(), version = version + 1 WHERE id = $1`
+	_, err = tx.Exec(ctx, query, bookId)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return er.Wrap(funcName, err)
@@ -173,15 +406,169 @@ func (s *BookServiceStruct) DeleteBook(ctx context.Context, bookId int) error {
 		return er.Wrap(funcName, err)
 	}
 
+	err = recordAuditEvent(ctx, tx, audit.ActionDelete, audit.EntityBook, bookId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing book deletion: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// RestoreBook restores a soft deleted book
+func (s *BookServiceStruct) RestoreBook(ctx context.Context, bookId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookService + "RestoreBook"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var lockedId int
+	query := `SELECT id FROM books WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, bookId).Scan(&lockedId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Deleted book with id %d does not exist", bookId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting deleted book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityBook, bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query = `UPDATE books SET deleted_at = NULL, version = version + 1 WHERE id = $1`
+	_, err = tx.Exec(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error restoring book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionRestore, audit.EntityBook, bookId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing book restore: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
 	return nil
 }
 
-// bookExists checks if a book with the given ID exists in the database
+// ImportBooks adds the books of an import in a single transaction, copying new books with their authors
+// and copies in batches. Books are matched to existing books by their ISBN: in insert mode a match is
+// reported as a failed row, and in upsert mode the existing book is replaced like with UpdateBook.
+// Books without an ISBN are always added. Rows repeating an ISBN of an earlier row fail, as do rows matching
+// a deleted book, which must be restored first. With a dry run, the transaction is rolled back.
+func (s *BookServiceStruct) ImportBooks(ctx context.Context, rows []bulk.Row[book.Book], options bulk.Options) (*bulk.Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, importTimeout)
+	defer cancel()
+
+	funcName := bookService + "ImportBooks"
+
+	report := &bulk.Report{Mode: options.Mode, DryRun: options.DryRun, Errors: []bulk.RowError{}}
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	existing, err := lockBooksByISBN(ctx, tx, rows)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	var inserts []bulk.Row[book.Book]
+	seen := map[string]int{}
+	for _, row := range rows {
+		isbn := row.Value.ISBN
+		if isbn == "" {
+			inserts = append(inserts, row)
+			continue
+		}
+
+		if line, ok := seen[isbn]; ok {
+			report.Fail(row.Line, fmt.Sprintf("Book with ISBN %s is already on line %d", isbn, line), nil)
+			continue
+		}
+		seen[isbn] = row.Line
+
+		match, ok := existing[isbn]
+		switch {
+		case !ok:
+			inserts = append(inserts, row)
+		case options.Mode != bulk.ModeUpsert:
+			report.Fail(row.Line, fmt.Sprintf("Book with ISBN %s, already exists", isbn), nil)
+		case match.DeletedAt != nil:
+			report.Fail(row.Line, fmt.Sprintf("Book with ISBN %s is deleted, restore it first", isbn), nil)
+		default:
+			err = replaceBook(ctx, tx, match.ID, row.Value)
+			if err != nil {
+				return nil, er.Wrap(funcName, err)
+			}
+			report.Updated++
+		}
+	}
+
+	for _, batch := range batches(inserts) {
+		err = insertBooks(ctx, tx, batch)
+		if err != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		report.Created += len(batch)
+	}
+
+	err = finishImport(ctx, tx, options.DryRun)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return report, nil
+}
+
+// bookExists checks if a book with the given ID exists in the database and is not deleted
 func (s *BookServiceStruct) bookExists(ctx context.Context, bookId int) error {
 	funcName := bookService + "bookExists"
 
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM books WHERE id = $1)`
+	query := `SELECT EXISTS(SELECT 1 FROM books WHERE id = $1 AND deleted_at IS NULL)`
 	err := s.dbService.GetPool().QueryRow(ctx, query, bookId).Scan(&exists)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
@@ -193,54 +580,298 @@ func (s *BookServiceStruct) bookExists(ctx context.Context, bookId int) error {
 
 	if !exists {
 		message := fmt.Sprintf("Book with id %d does not exist", bookId)
-		return er.New(funcName, message, nil)
+		return er.NewKind(funcName, er.NotFound, message, nil)
 	}
 
 	return nil
 }
 
-// titleExists checks if a book with the given title exists in the database
-func (s *BookServiceStruct) titleExists(ctx context.Context, book book.Book) error {
-	funcName := bookService + "titleExists"
+// isbnExists checks if a book other than the one with the given ID already has the ISBN
+func (s *BookServiceStruct) isbnExists(ctx context.Context, isbn string, bookId int) error {
+	funcName := bookService + "isbnExists"
+
+	if isbn == "" {
+		return nil
+	}
 
 	var exists bool
-	query := `SELECT EXISTS (SELECT 1 FROM books WHERE title = $1)`
-	err := s.dbService.GetPool().QueryRow(ctx, query, book.Title).Scan(&exists)
+	query := `SELECT EXISTS (SELECT 1 FROM books WHERE isbn = $1 AND id <> $2)`
+	err := s.dbService.GetPool().QueryRow(ctx, query, isbn, bookId).Scan(&exists)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
-		log.Printf("Error checking if title of the book exists: %v", err)
+		log.Printf("Error checking if ISBN of the book exists: %v", err)
 		return er.Wrap(funcName, err)
 	}
 
-	log.Printf("exists: %v", exists)
 	if exists {
-		message := fmt.Sprintf("Book with title %s, already exists", book.Title)
-		return er.New(funcName, message, nil)
+		message := fmt.Sprintf("Book with ISBN %s, already exists", isbn)
+		return er.NewKind(funcName, er.Conflict, message, nil)
 	}
 
 	return nil
 }
 
-// bookAndTitleMatch checks if the book with the given ID and title match in the database
-func (s *BookServiceStruct) bookAndTitleMatch(ctx context.Context, bookId int, book book.Book) (bool, error) {
-	funcName := bookService + "bookAndTitleExists"
+// replaceBook replaces the metadata and authors of a book in the transaction and adjusts its available copies
+// to its quantity, recording the book before and after in the audit log
+func replaceBook(ctx context.Context, tx pgx.Tx, bookId int, updatedBook book.Book) error {
+	funcName := bookService + "replaceBook"
 
-	var exists bool
-	query := `SELECT EXISTS (SELECT 1 FROM books WHERE id = $1 AND title = $2)`
-	err := s.dbService.GetPool().QueryRow(ctx, query, bookId, book.Title).Scan(&exists)
+	before, err := auditSnapshot(ctx, tx, audit.EntityBook, bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query := `UPDATE books SET title = $1, isbn = NULLIF($2, ''), publisher = $3, publication_year = NULLIF($4, 0),
+		language = $5, page_count = NULLIF($6, 0), version = version + 1
+		WHERE id = $7`
+	_, err = tx.Exec(ctx, query, updatedBook.Title, updatedBook.ISBN, updatedBook.Publisher, updatedBook.PublicationYear,
+		updatedBook.Language, updatedBook.PageCount, bookId)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookService, err) != nil {
-			return false, er.Wrap(funcName, err)
+			return er.Wrap(funcName, err)
 		}
-		log.Printf("Error checking if book and title match: %v", err)
-		return false, er.Wrap(funcName, err)
+		log.Printf("Error updating book: %v", err)
+		return er.Wrap(funcName, err)
 	}
 
-	if !exists {
-		return false, nil
+	err = setAuthors(ctx, tx, bookId, updatedBook.Authors)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = adjustAvailableCopies(ctx, tx, bookId, updatedBook.Quantity)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionUpdate, audit.EntityBook, bookId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// setAuthors replaces the authors of a book with the given names, in the given order.
+// Authors that are not yet known are added.
+func setAuthors(ctx context.Context, tx pgx.Tx, bookId int, authors []string) error {
+	funcName := bookService + "setAuthors"
+
+	query := `DELETE FROM book_authors WHERE book_id = $1`
+	_, err := tx.Exec(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error removing authors: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if len(authors) == 0 {
+		return nil
+	}
+
+	query = `INSERT INTO authors (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`
+	_, err = tx.Exec(ctx, query, authors)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error adding authors: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	query = `INSERT INTO book_authors (book_id, author_id, position)
+		SELECT $1, a.id, t.position FROM unnest($2::text[]) WITH ORDINALITY AS t(name, position)
+		JOIN authors a ON a.name = t.name`
+	_, err = tx.Exec(ctx, query, bookId, authors)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error setting authors: %v", err)
+		return er.Wrap(funcName, err)
 	}
 
-	return true, nil
+	return nil
+}
+
+// adjustAvailableCopies adds new copies, or withdraws available ones, until the book has the given number of available copies.
+// The book row is locked, so the count cannot change while copies are adjusted.
+func adjustAvailableCopies(ctx context.Context, tx pgx.Tx, bookId int, quantity int) error {
+	funcName := bookService + "adjustAvailableCopies"
+
+	var available int
+	query := `SELECT (SELECT COUNT(*) FROM copies WHERE book_id = books.id AND status = 'available') FROM books WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, query, bookId).Scan(&available)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error counting available copies: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if quantity > available {
+		query = `INSERT INTO copies (book_id, barcode) SELECT $1, NULL FROM generate_series(1, $2)`
+	} else if quantity < available {
+		query = `UPDATE copies SET status = 'withdrawn'
+			WHERE id IN (SELECT id FROM copies WHERE book_id = $1 AND status = 'available' ORDER BY id DESC LIMIT $2)`
+	} else {
+		return nil
+	}
+
+	difference := quantity - available
+	if difference < 0 {
+		difference = -difference
+	}
+
+	_, err = tx.Exec(ctx, query, bookId, difference)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error adjusting available copies: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// lockBooksByISBN locks the books, deleted or not, that have an ISBN of the rows, and returns them by their ISBN
+func lockBooksByISBN(ctx context.Context, tx pgx.Tx, rows []bulk.Row[book.Book]) (map[string]book.Book, error) {
+	funcName := bookService + "lockBooksByISBN"
+
+	var isbns []string
+	for _, row := range rows {
+		if row.Value.ISBN != "" {
+			isbns = append(isbns, row.Value.ISBN)
+		}
+	}
+
+	books := map[string]book.Book{}
+	if len(isbns) == 0 {
+		return books, nil
+	}
+
+	query := `SELECT id, isbn, deleted_at FROM books WHERE isbn = ANY($1) FOR UPDATE`
+	result, err := tx.Query(ctx, query, isbns)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting books by ISBN: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var book book.Book
+		err := result.Scan(&book.ID, &book.ISBN, &book.DeletedAt)
+		if err != nil {
+			log.Printf("Error scanning books: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		books[book.ISBN] = book
+	}
+
+	return books, nil
+}
+
+// insertBooks adds a batch of new books: the books are copied into the database with reserved ids,
+// and their authors and available copies are added with one statement each
+func insertBooks(ctx context.Context, tx pgx.Tx, rows []bulk.Row[book.Book]) error {
+	funcName := bookService + "insertBooks"
+
+	ids, err := reserveIds(ctx, tx, "books", len(rows))
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	bookRows := make([][]interface{}, len(rows))
+	var authorBookIds, authorPositions, quantities []int
+	var authorNames []string
+	for i, row := range rows {
+		b := row.Value
+		bookRows[i] = []interface{}{ids[i], b.Title, nullIfZero(b.ISBN), b.Publisher, nullIfZero(b.PublicationYear),
+			b.Language, nullIfZero(b.PageCount)}
+		quantities = append(quantities, b.Quantity)
+
+		for position, author := range b.Authors {
+			authorBookIds = append(authorBookIds, ids[i])
+			authorNames = append(authorNames, author)
+			authorPositions = append(authorPositions, position+1)
+		}
+	}
+
+	columns := []string{"id", "title", "isbn", "publisher", "publication_year", "language", "page_count"}
+	err = copyRows(ctx, tx, "books", columns, bookRows)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	if len(authorNames) > 0 {
+		query := `INSERT INTO authors (name) SELECT DISTINCT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`
+		_, err = tx.Exec(ctx, query, authorNames)
+		if err != nil {
+			if er.HandleDeadlineExceededError(bookService, err) != nil {
+				return er.Wrap(funcName, err)
+			}
+			log.Printf("Error adding authors: %v", err)
+			return er.Wrap(funcName, err)
+		}
+
+		query = `INSERT INTO book_authors (book_id, author_id, position)
+			SELECT t.book_id, a.id, t.position FROM unnest($1::int[], $2::text[], $3::int[]) AS t(book_id, name, position)
+			JOIN authors a ON a.name = t.name`
+		_, err = tx.Exec(ctx, query, authorBookIds, authorNames, authorPositions)
+		if err != nil {
+			if er.HandleDeadlineExceededError(bookService, err) != nil {
+				return er.Wrap(funcName, err)
+			}
+			log.Printf("Error setting authors: %v", err)
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	query := `INSERT INTO copies (book_id, barcode)
+		SELECT t.book_id, NULL FROM unnest($1::int[], $2::int[]) AS t(book_id, quantity), generate_series(1, t.quantity)`
+	_, err = tx.Exec(ctx, query, ids, quantities)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error adding copies: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditCreations(ctx, tx, audit.EntityBook, ids)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// prefixTsQuery turns the words of a search into a tsquery that matches all of them as prefixes.
+// Anything other than letters and digits separates words, so the search cannot inject tsquery operators.
+func prefixTsQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = strings.ToLower(word) + ":*"
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// scanBook scans a row selected with bookColumns into the given Book
+func scanBook(row pgx.Row, book *book.Book) error {
+	return row.Scan(&book.ID, &book.Title, &book.Quantity, &book.ISBN, &book.Publisher, &book.PublicationYear,
+		&book.Language, &book.PageCount, &book.Authors, &book.DeletedAt, &book.Version)
 }
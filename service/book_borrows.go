@@ -2,223 +2,664 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/jackc/pgx/v4"
+	"kokal5296/config"
 	"kokal5296/database"
 	er "kokal5296/errors"
+	"kokal5296/models/audit"
 	"kokal5296/models/book"
 	"kokal5296/models/book_borrow"
+	"kokal5296/models/book_copy"
+	"kokal5296/models/page"
 	"log"
 	"time"
 )
 
 type BookBorrowStruct struct {
-	dbService   database.DatabaseService
-	BookService BookService
-	userService UserService
+	dbService    database.DatabaseService
+	BookService  BookService
+	userService  UserService
+	loanConfig   config.LoanConfig
+	policyEngine PolicyEngine
 }
 
 const bookBorrowService = "bookBorrowService - "
 
+// bookBorrowColumns lists the book_borrows columns in the order expected by scanBookBorrow
+const bookBorrowColumns = `id, book_id, user_id, copy_id, borrow_date, return_date, due_date, renewal_count`
+
+// loanHistoryTable selects the borrow records with the title of their book and the name of their user,
+// as listed in the borrowing history
+const loanHistoryTable = `(SELECT bb.*, b.title AS book_title, u.first_name || ' ' || u.last_name AS user_name
+	FROM book_borrows bb JOIN books b ON b.id = bb.book_id JOIN users u ON u.id = bb.user_id) AS loans`
+
+// loanColumns lists the columns of loanHistoryTable in the order expected by scanLoan
+const loanColumns = bookBorrowColumns + `, book_title, user_name`
+
 // BookBorrowService interface defgines methods for book borrow-related operations
 type BookBorrowService interface {
-	GetAvailableBooks(ctx context.Context) ([]book.Book, error)
-	AllBorrowedBooks(ctx context.Context) ([]book_borrow.BookBorrow, error)
-	BorrowBook(ctx context.Context, bookId int, userId int) error
-	ReturnBook(ctx context.Context, bookId int, userId int) error
+	GetAvailableBooks(ctx context.Context, params page.Params) (*page.Page[book.Book], error)
+	AllBorrowedBooks(ctx context.Context, params page.Params) (*page.Page[book_borrow.BookBorrow], error)
+	UserLoans(ctx context.Context, userId int, params page.Params) (*page.Page[book_borrow.Loan], error)
+	BookLoans(ctx context.Context, bookId int, params page.Params) (*page.Page[book_borrow.Loan], error)
+	GetBookBorrow(ctx context.Context, borrowId int) (*book_borrow.BookBorrow, error)
+	BorrowBook(ctx context.Context, bookId int, userId int, copyId int) error
+	CreateLoan(ctx context.Context, bookId int, userId int, copyId int) (*book_borrow.BookBorrow, error)
+	ReturnBook(ctx context.Context, bookId int, userId int, copyId int) error
+	ReturnLoan(ctx context.Context, loanId int) (*book_borrow.BookBorrow, error)
+	RenewBook(ctx context.Context, borrowId int) (*book_borrow.BookBorrow, error)
+	OverdueBooks(ctx context.Context) ([]book_borrow.BookBorrow, error)
 }
 
 // NewBookBorrowService creates a new instance of BookBorrowService, implementing the BookBorrowStruct
-func NewBookBorrowService(dbService database.DatabaseService, bookService BookService, userService UserService) BookBorrowService {
+func NewBookBorrowService(dbService database.DatabaseService, bookService BookService, userService UserService, loanConfig config.LoanConfig) BookBorrowService {
 	return &BookBorrowStruct{
-		dbService:   dbService,
-		BookService: bookService,
-		userService: userService,
+		dbService:    dbService,
+		BookService:  bookService,
+		userService:  userService,
+		loanConfig:   loanConfig,
+		policyEngine: NewPolicyEngine(loanConfig),
 	}
 }
 
-// GetAvailableBooks returns all books that are available for borrowing
-func (s *BookBorrowStruct) GetAvailableBooks(ctx context.Context) ([]book.Book, error) {
+// GetAvailableBooks returns one page of the books that have more copies on the shelf than users waiting for them,
+// with the number of copies on the shelf as their quantity. Copies reserved for ready holds are not on the shelf.
+func (s *BookBorrowStruct) GetAvailableBooks(ctx context.Context, params page.Params) (*page.Page[book.Book], error) {
 	ctx, cancle := context.WithTimeout(ctx, 5*time.Second)
 	defer cancle()
 
 	funcName := bookBorrowService + "GetAvailableBooks"
 
-	var books []book.Book
-	query := `SELECT * FROM books WHERE quantity > 0`
-	rows, err := s.dbService.GetPool().Query(ctx, query)
+	books, err := listPage(ctx, s.dbService.GetPool(), AvailableBookListSpec, params, bookColumns, "books", []string{bookIsAvailable}, scanBook)
 	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return books, nil
+}
+
+// AllBorrowedBooks returns one page of the books that are currently borrowed and not yet returned
+func (s *BookBorrowStruct) AllBorrowedBooks(ctx context.Context, params page.Params) (*page.Page[book_borrow.BookBorrow], error) {
+	ctx, cancle := context.WithTimeout(ctx, 5*time.Second)
+	defer cancle()
+
+	funcName := bookBorrowService + "AllBorrowedBooks"
+
+	borrowed, err := listPage(ctx, s.dbService.GetPool(), BookBorrowListSpec, params, bookBorrowColumns, "book_borrows",
+		[]string{"return_date IS NULL"}, scanBookBorrow)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return borrowed, nil
+}
+
+// UserLoans returns one page of the borrowing history of a user, the latest loans first by default.
+// The history of deleted users is kept, so it can be listed as well.
+func (s *BookBorrowStruct) UserLoans(ctx context.Context, userId int, params page.Params) (*page.Page[book_borrow.Loan], error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "UserLoans"
+
+	_, err := s.userService.GetUser(ctx, userId, true)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	loans, err := listPage(ctx, s.dbService.GetPool(), LoanHistoryListSpec, params, loanColumns, loanHistoryTable,
+		[]string{fmt.Sprintf("user_id = %d", userId)}, scanLoan)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return loans, nil
+}
+
+// BookLoans returns one page of the borrowing history of a book, the latest loans first by default.
+// The history of deleted books is kept, so it can be listed as well.
+func (s *BookBorrowStruct) BookLoans(ctx context.Context, bookId int, params page.Params) (*page.Page[book_borrow.Loan], error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "BookLoans"
+
+	_, err := s.BookService.GetBook(ctx, bookId, true)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	loans, err := listPage(ctx, s.dbService.GetPool(), LoanHistoryListSpec, params, loanColumns, loanHistoryTable,
+		[]string{fmt.Sprintf("book_id = %d", bookId)}, scanLoan)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return loans, nil
+}
+
+// GetBookBorrow retrieves a borrow record by its id
+func (s *BookBorrowStruct) GetBookBorrow(ctx context.Context, borrowId int) (*book_borrow.BookBorrow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "GetBookBorrow"
+
+	var bookBorrowed book_borrow.BookBorrow
+	query := `SELECT ` + bookBorrowColumns + ` FROM book_borrows WHERE id = $1`
+	err := scanBookBorrow(s.dbService.GetPool().QueryRow(ctx, query, borrowId), &bookBorrowed)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Borrow with id %d does not exist", borrowId)
+			return nil, er.NewKind(funcName, er.NotFound, message, err)
+		}
 		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
-		log.Printf("Error getting available books: %v", err)
+		log.Printf("Error getting borrowed book: %v", err)
 		return nil, er.Wrap(funcName, err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var book book.Book
-		err := rows.Scan(&book.ID, &book.Title, &book.Quantity)
-		if err != nil {
-			log.Printf("Error scanning books: %v", err)
+	return &bookBorrowed, nil
+}
+
+// BorrowBook allows a user to borrow a copy of a book like CreateLoan, for clients that do not need the created loan
+func (s *BookBorrowStruct) BorrowBook(ctx context.Context, bookId int, userId int, copyId int) error {
+	funcName := bookBorrowService + "BorrowBook"
+
+	_, err := s.CreateLoan(ctx, bookId, userId, copyId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// CreateLoan allows a user to borrow a copy of a book if one is available and the loan policy of the user's membership tier allows it,
+// and returns the created loan. The copy can be chosen by its id, or with a copyId of 0 any available copy is picked.
+// Copies reserved for ready holds can only be borrowed by the users holding them, which fulfills the hold.
+// The loan is due after the configured loan period.
+// The availability check, the new borrow record and the copy status change run in a single transaction,
+// with the book row locked, so concurrent borrows of the last copy cannot both succeed.
+func (s *BookBorrowStruct) CreateLoan(ctx context.Context, bookId int, userId int, copyId int) (*book_borrow.BookBorrow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "CreateLoan"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
-		books = append(books, book)
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
 	}
+	defer tx.Rollback(ctx)
 
-	return books, nil
-}
+	err = s.policyEngine.CheckBorrow(ctx, tx, userId, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
 
-// AllBorrowedBooks returns all books that are currently borrowed and not yet returned
-func (s *BookBorrowStruct) AllBorrowedBooks(ctx context.Context) ([]book_borrow.BookBorrow, error) {
-	ctx, cancle := context.WithTimeout(ctx, 5*time.Second)
-	defer cancle()
+	available, err := lockAvailableQuantity(ctx, tx, bookId, s.loanConfig)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
 
-	funcName := bookBorrowService + "AllBorrowedBooks"
+	var readyHoldId int
+	var reservedCopyId *int
+	query := `SELECT id, copy_id FROM holds WHERE book_id = $1 AND user_id = $2 AND status = 'ready'`
+	err = tx.QueryRow(ctx, query, bookId, userId).Scan(&readyHoldId, &reservedCopyId)
+	if err != nil && err != pgx.ErrNoRows {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting hold: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	hasReadyHold := err == nil
 
-	var result []book_borrow.BookBorrow
-	query := `SELECT id, book_id, user_id, borrow_date, return_date FROM book_borrows WHERE return_date IS NULL`
-	rows, err := s.dbService.GetPool().Query(ctx, query)
+	if hasReadyHold && reservedCopyId != nil {
+		if copyId != 0 && copyId != *reservedCopyId {
+			message := fmt.Sprintf("Copy with id %d is reserved for the user, not copy with id %d", *reservedCopyId, copyId)
+			return nil, er.NewKind(funcName, er.Conflict, message, nil)
+		}
+		copyId = *reservedCopyId
+	} else {
+		if available <= 0 {
+			message := "Book is not available"
+			return nil, er.NewKind(funcName, er.Conflict, message, nil)
+		}
+		copyId, err = availableCopy(ctx, tx, bookId, copyId)
+		if err != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+	}
+
+	var loan book_borrow.BookBorrow
+	query = `INSERT INTO book_borrows (book_id, user_id, copy_id, due_date) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		RETURNING ` + bookBorrowColumns
+	err = scanBookBorrow(tx.QueryRow(ctx, query, bookId, userId, copyId, s.loanConfig.LoanPeriod.Seconds()), &loan)
 	if err != nil {
 		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
-		log.Printf("Error getting borrowed books: %v", err)
+		log.Printf("Error borrowing book: %v", err)
 		return nil, er.Wrap(funcName, err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var bookBorrowed book_borrow.BookBorrow
-		err := rows.Scan(&bookBorrowed.ID, &bookBorrowed.BookID, &bookBorrowed.UserID, &bookBorrowed.Borrow_date, &bookBorrowed.Return_date)
+	err = recordAuditEvent(ctx, tx, audit.ActionBorrow, audit.EntityBookBorrow, loan.ID, nil)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	query = `UPDATE copies SET status = 'on_loan' WHERE id = $1`
+	_, err = tx.Exec(ctx, query, copyId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error updating copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = touchBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if hasReadyHold {
+		query = `UPDATE holds SET status = 'fulfilled' WHERE id = $1`
+		_, err = tx.Exec(ctx, query, readyHoldId)
 		if err != nil {
-			log.Printf("Error scanning books: %v", err)
+			if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+				return nil, er.Wrap(funcName, err)
+			}
+			log.Printf("Error fulfilling hold: %v", err)
 			return nil, er.Wrap(funcName, err)
 		}
-		result = append(result, bookBorrowed)
 	}
 
-	log.Printf("All borrowed books: %v", result)
-	return result, nil
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing borrow: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &loan, nil
 }
 
-// BorrowBook allows a user to borrow a book if it's available and the user has not already borrowed it
-func (s *BookBorrowStruct) BorrowBook(ctx context.Context, bookId int, userId int) error {
+// ReturnBook allows a user to return a book if they have borrowed it. With a copyId other than 0 only the loan
+// of that copy is returned. Like CreateLoan, it locks the book row and closes the borrow record and puts the copy
+// back on the shelf in one transaction, charging a fine if the book is returned late and reserving the copy
+// for the next hold in the queue.
+func (s *BookBorrowStruct) ReturnBook(ctx context.Context, bookId int, userId int, copyId int) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	funcName := bookService + "BorrowBook"
+	funcName := bookBorrowService + "ReturnBook"
 
-	var quantity int
-	query := `SELECT quantity FROM books WHERE id = $1`
-	err := s.dbService.GetPool().QueryRow(ctx, query, bookId).Scan(&quantity)
+	tx, err := s.dbService.GetPool().Begin(ctx)
 	if err != nil {
-		if er.HandleDeadlineExceededError(bookService, err) != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
-		log.Printf("Error getting book: %v", err)
+		log.Printf("Error starting transaction: %v", err)
 		return er.Wrap(funcName, err)
 	}
+	defer tx.Rollback(ctx)
 
-	if quantity <= 0 {
-		message := "Book is not available"
-		return er.New(funcName, message, nil)
-	}
-
-	err = s.userService.UserExist(ctx, userId)
+	err = lockBook(ctx, tx, bookId)
 	if err != nil {
 		return er.Wrap(funcName, err)
 	}
 
-	var bookBorrowed bool
-	query = `SELECT * FROM book_borrows WHERE book_id = $1 AND user_id = $2 AND borrow_date IS NOT NULL AND return_date IS NULL`
-	err = s.dbService.GetPool().QueryRow(ctx, query, bookId, userId).Scan(&bookBorrowed)
+	var borrowId int
+	query := `SELECT id FROM book_borrows WHERE book_id = $1 AND user_id = $2 AND return_date IS NULL AND ($3 = 0 OR copy_id = $3)
+		ORDER BY id LIMIT 1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, bookId, userId, copyId).Scan(&borrowId)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			bookBorrowed = false
-		} else {
-			if er.HandleDeadlineExceededError(bookService, err) != nil {
-				return er.Wrap(funcName, err)
-			}
-			log.Printf("Error getting borrowed book: %v", err)
+			message := "Book is not currently borrowed by the user"
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
+		log.Printf("Error getting borrowed book: %v", err)
+		return er.Wrap(funcName, err)
 	}
 
-	if bookBorrowed {
-		message := "Book is already borrowed"
-		return er.New(funcName, message, nil)
-	}
-
-	query = `INSERT INTO book_borrows (book_id, user_id) VALUES ($1, $2)`
-	_, err = s.dbService.GetPool().Exec(ctx, query, bookId, userId)
+	_, err = closeLoan(ctx, tx, borrowId, s.loanConfig)
 	if err != nil {
-		if er.HandleDeadlineExceededError(bookService, err) != nil {
-			return er.Wrap(funcName, err)
-		}
-		log.Printf("Error borrowing book: %v", err)
 		return er.Wrap(funcName, err)
 	}
 
-	query = `UPDATE books SET quantity = quantity - 1 WHERE id = $1`
-	_, err = s.dbService.GetPool().Exec(ctx, query, bookId)
+	err = tx.Commit(ctx)
 	if err != nil {
-		if er.HandleDeadlineExceededError(bookService, err) != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
-		log.Printf("Error updating book: %v", err)
+		log.Printf("Error committing return: %v", err)
 		return er.Wrap(funcName, err)
 	}
 
 	return nil
 }
 
-// ReturnBook allows a user to return a book if they have borrowed it
-func (s *BookBorrowStruct) ReturnBook(ctx context.Context, bookId int, userId int) error {
-	ctx, cancle := context.WithTimeout(ctx, 5*time.Second)
-	defer cancle()
+// ReturnLoan returns the book of an open loan and returns the closed loan. Like ReturnBook, it locks the book row
+// and closes the loan in one transaction, charging a fine if the book is returned late and reserving the copy
+// for the next hold in the queue.
+func (s *BookBorrowStruct) ReturnLoan(ctx context.Context, loanId int) (*book_borrow.BookBorrow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	funcName := bookService + "ReturnBook"
+	funcName := bookBorrowService + "ReturnLoan"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
 
-	var activeBorrowCount int
-	query := `SELECT 1 FROM book_borrows WHERE book_id = $1 AND user_id = $2 AND borrow_date IS NOT NULL AND return_date IS NULL`
-	err := s.dbService.GetPool().QueryRow(ctx, query, bookId, userId).Scan(&activeBorrowCount)
+	// The book row is locked before the loan, in the same order as borrows and returns by book
+	var bookId int
+	query := `SELECT book_id FROM book_borrows WHERE id = $1`
+	err = tx.QueryRow(ctx, query, loanId).Scan(&bookId)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			message := "Book is not borrowed"
-			return er.New(funcName, message, nil)
-		} else {
-			if er.HandleDeadlineExceededError(bookService, err) != nil {
-				return er.Wrap(funcName, err)
-			}
-			log.Printf("Error getting borrowed book: %v", err)
-			return er.Wrap(funcName, err)
+			message := fmt.Sprintf("Loan with id %d does not exist", loanId)
+			return nil, er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
 		}
+		log.Printf("Error getting loan: %v", err)
+		return nil, er.Wrap(funcName, err)
 	}
 
-	if activeBorrowCount == 0 {
-		message := "Book is not currently borrowed by the user"
-		return er.New(funcName, message, nil)
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
 	}
 
-	query = `UPDATE book_borrows SET return_date = NOW() WHERE book_id = $1 AND user_id = $2 AND return_date IS NULL`
-	_, err = s.dbService.GetPool().Exec(ctx, query, bookId, userId)
+	var returned bool
+	query = `SELECT return_date IS NOT NULL FROM book_borrows WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, loanId).Scan(&returned)
 	if err != nil {
-		if er.HandleDeadlineExceededError(bookService, err) != nil {
-			return er.Wrap(funcName, err)
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting loan: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if returned {
+		message := "Book has already been returned"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	loan, err := closeLoan(ctx, tx, loanId, s.loanConfig)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing return: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return loan, nil
+}
+
+// closeLoan records the return of an open loan: it sets the return date, charges a fine if the book is returned late,
+// puts the copy back on the shelf and reserves it for the next hold in the queue. It returns the closed loan.
+// The book row and the loan must be locked by the caller.
+func closeLoan(ctx context.Context, tx pgx.Tx, loanId int, loanConfig config.LoanConfig) (*book_borrow.BookBorrow, error) {
+	funcName := bookBorrowService + "closeLoan"
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityBookBorrow, loanId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	var loan book_borrow.BookBorrow
+	query := `UPDATE book_borrows SET return_date = NOW() WHERE id = $1 RETURNING ` + bookBorrowColumns
+	err = scanBookBorrow(tx.QueryRow(ctx, query, loanId), &loan)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
 		}
 		log.Printf("Error updating returning book: %v", err)
-		return er.Wrap(funcName, err)
+		return nil, er.Wrap(funcName, err)
 	}
 
-	query = `UPDATE books SET quantity = quantity + 1 WHERE id = $1`
-	_, err = s.dbService.GetPool().Exec(ctx, query, bookId)
+	err = recordAuditEvent(ctx, tx, audit.ActionReturn, audit.EntityBookBorrow, loanId, before)
 	if err != nil {
-		if er.HandleDeadlineExceededError(bookService, err) != nil {
-			return er.Wrap(funcName, err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = chargeLateFine(ctx, tx, loanId, loanConfig)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if loan.CopyID != nil {
+		query = `UPDATE copies SET status = 'available' WHERE id = $1 AND status = 'on_loan'`
+		_, err = tx.Exec(ctx, query, *loan.CopyID)
+		if err != nil {
+			if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+				return nil, er.Wrap(funcName, err)
+			}
+			log.Printf("Error updating copy: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+
+		err = touchBook(ctx, tx, loan.BookID)
+		if err != nil {
+			return nil, er.Wrap(funcName, err)
 		}
-		log.Printf("Error updating book: %v, quantity", err)
-		return er.Wrap(funcName, err)
 	}
 
-	return nil
+	err = promoteHolds(ctx, tx, loan.BookID, loanConfig)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &loan, nil
+}
+
+// RenewBook extends the due date of an open loan by another loan period, counted from the current due date
+// or from now if the loan is already overdue. A loan can be renewed at most the configured number of times,
+// and not at all while other users hold the book.
+func (s *BookBorrowStruct) RenewBook(ctx context.Context, borrowId int) (*book_borrow.BookBorrow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "RenewBook"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var bookBorrowed book_borrow.BookBorrow
+	query := `SELECT ` + bookBorrowColumns + ` FROM book_borrows WHERE id = $1 FOR UPDATE`
+	err = scanBookBorrow(tx.QueryRow(ctx, query, borrowId), &bookBorrowed)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Borrow with id %d does not exist", borrowId)
+			return nil, er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting borrowed book: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityBookBorrow, borrowId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if bookBorrowed.Return_date != nil {
+		message := "Book has already been returned"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	if bookBorrowed.RenewalCount >= s.loanConfig.MaxRenewals {
+		message := fmt.Sprintf("Book has already been renewed the maximum of %d times", s.loanConfig.MaxRenewals)
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	var hasHolds bool
+	query = `SELECT EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND status IN ('waiting', 'ready'))`
+	err = tx.QueryRow(ctx, query, bookBorrowed.BookID).Scan(&hasHolds)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error checking holds: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if hasHolds {
+		message := "Book cannot be renewed because other users are waiting for it"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	query = `UPDATE book_borrows SET due_date = GREATEST(due_date, NOW()) + make_interval(secs => $2), renewal_count = renewal_count + 1
+		WHERE id = $1 RETURNING ` + bookBorrowColumns
+	err = scanBookBorrow(tx.QueryRow(ctx, query, borrowId, s.loanConfig.LoanPeriod.Seconds()), &bookBorrowed)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error renewing book: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionRenew, audit.EntityBookBorrow, borrowId, before)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing renewal: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &bookBorrowed, nil
+}
+
+// OverdueBooks returns all loans that are not yet returned and are past their due date, the most overdue first
+func (s *BookBorrowStruct) OverdueBooks(ctx context.Context) ([]book_borrow.BookBorrow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := bookBorrowService + "OverdueBooks"
+
+	var result []book_borrow.BookBorrow
+	query := `SELECT ` + bookBorrowColumns + ` FROM book_borrows WHERE return_date IS NULL AND due_date < NOW() ORDER BY due_date`
+	rows, err := s.dbService.GetPool().Query(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting overdue books: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookBorrowed book_borrow.BookBorrow
+		err := scanBookBorrow(rows, &bookBorrowed)
+		if err != nil {
+			log.Printf("Error scanning books: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		result = append(result, bookBorrowed)
+	}
+
+	return result, nil
+}
+
+// availableCopy returns the id of the given copy of the book, or with a copyId of 0 the first copy on the shelf.
+// The book row must be locked by the caller.
+func availableCopy(ctx context.Context, tx pgx.Tx, bookId int, copyId int) (int, error) {
+	funcName := bookBorrowService + "availableCopy"
+
+	if copyId == 0 {
+		query := `SELECT id FROM copies WHERE book_id = $1 AND status = 'available' ORDER BY id LIMIT 1`
+		err := tx.QueryRow(ctx, query, bookId).Scan(&copyId)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				message := "Book is not available"
+				return 0, er.NewKind(funcName, er.Conflict, message, nil)
+			}
+			if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+				return 0, er.Wrap(funcName, err)
+			}
+			log.Printf("Error getting available copy: %v", err)
+			return 0, er.Wrap(funcName, err)
+		}
+		return copyId, nil
+	}
+
+	var status string
+	query := `SELECT status FROM copies WHERE id = $1 AND book_id = $2`
+	err := tx.QueryRow(ctx, query, copyId, bookId).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Copy with id %d of book with id %d does not exist", copyId, bookId)
+			return 0, er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(bookBorrowService, err) != nil {
+			return 0, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting copy: %v", err)
+		return 0, er.Wrap(funcName, err)
+	}
+
+	if status != book_copy.StatusAvailable {
+		message := fmt.Sprintf("Copy with id %d is not available, its status is %s", copyId, status)
+		return 0, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	return copyId, nil
+}
+
+// scanBookBorrow scans a row selected with bookBorrowColumns into the given BookBorrow
+func scanBookBorrow(row pgx.Row, bookBorrowed *book_borrow.BookBorrow) error {
+	return row.Scan(&bookBorrowed.ID, &bookBorrowed.BookID, &bookBorrowed.UserID, &bookBorrowed.CopyID, &bookBorrowed.Borrow_date,
+		&bookBorrowed.Return_date, &bookBorrowed.Due_date, &bookBorrowed.RenewalCount)
+}
+
+// scanLoan scans a row selected with loanColumns into the given Loan
+func scanLoan(row pgx.Row, loan *book_borrow.Loan) error {
+	return row.Scan(&loan.ID, &loan.BookID, &loan.UserID, &loan.CopyID, &loan.Borrow_date, &loan.Return_date, &loan.Due_date,
+		&loan.RenewalCount, &loan.BookTitle, &loan.UserName)
 }
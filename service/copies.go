@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/book_copy"
+	"log"
+	"time"
+)
+
+type CopyServiceStruct struct {
+	dbService database.DatabaseService
+}
+
+const copyService = "copyService - "
+
+// copyColumns lists the copies columns in the order expected by scanCopy
+const copyColumns = `id, book_id, barcode, condition, status, shelf_location, created_at`
+
+// CopyService interface defines methods for operations on the individual copies of books
+type CopyService interface {
+	AddCopy(ctx context.Context, bookId int, newCopy book_copy.Copy) (*book_copy.Copy, error)
+	GetBookCopies(ctx context.Context, bookId int) ([]book_copy.Copy, error)
+	UpdateCopy(ctx context.Context, copyId int, updatedCopy book_copy.Copy) (*book_copy.Copy, error)
+	AuditShelf(ctx context.Context, audit book_copy.ShelfAudit) (*book_copy.AuditReport, error)
+}
+
+// NewCopyService creates a new instance of CopyServiceStruct, implementing CopyService
+func NewCopyService(dbService database.DatabaseService) CopyService {
+	return &CopyServiceStruct{
+		dbService: dbService,
+	}
+}
+
+// AddCopy adds a copy to a book. A barcode is generated if none is given, and the copy is put on the shelf
+// in good condition unless told otherwise.
+func (s *CopyServiceStruct) AddCopy(ctx context.Context, bookId int, newCopy book_copy.Copy) (*book_copy.Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := copyService + "AddCopy"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = barcodeExists(ctx, tx, newCopy.Barcode, 0)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	var added book_copy.Copy
+	query := `INSERT INTO copies (book_id, barcode, condition, status, shelf_location)
+		VALUES ($1, NULLIF($2, ''), COALESCE(NULLIF($3, ''), 'good'), COALESCE(NULLIF($4, ''), 'available'), $5)
+		RETURNING ` + copyColumns
+	row := tx.QueryRow(ctx, query, bookId, newCopy.Barcode, newCopy.Condition, newCopy.Status, newCopy.ShelfLocation)
+	err = scanCopy(row, &added)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error adding copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = touchBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &added, nil
+}
+
+// GetBookCopies returns all copies of a book, whatever their status
+func (s *CopyServiceStruct) GetBookCopies(ctx context.Context, bookId int) ([]book_copy.Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := copyService + "GetBookCopies"
+
+	var copies []book_copy.Copy
+	query := `SELECT ` + copyColumns + ` FROM copies WHERE book_id = $1 ORDER BY id`
+	rows, err := s.dbService.GetPool().Query(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting copies: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookCopy book_copy.Copy
+		err := scanCopy(rows, &bookCopy)
+		if err != nil {
+			log.Printf("Error scanning copies: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		copies = append(copies, bookCopy)
+	}
+
+	return copies, nil
+}
+
+// UpdateCopy changes the barcode, condition, status and shelf location of a copy. Empty barcode, condition
+// and status are left unchanged. The status of a copy that is on loan or reserved for a hold cannot be changed,
+// it goes back on the shelf when the book is returned or the hold ends.
+func (s *CopyServiceStruct) UpdateCopy(ctx context.Context, copyId int, updatedCopy book_copy.Copy) (*book_copy.Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := copyService + "UpdateCopy"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var bookId int
+	query := `SELECT book_id FROM copies WHERE id = $1`
+	err = tx.QueryRow(ctx, query, copyId).Scan(&bookId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Copy with id %d does not exist", copyId)
+			return nil, er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	var current book_copy.Copy
+	query = `SELECT ` + copyColumns + ` FROM copies WHERE id = $1`
+	err = scanCopy(tx.QueryRow(ctx, query, copyId), &current)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if updatedCopy.Status != "" && updatedCopy.Status != current.Status &&
+		(current.Status == book_copy.StatusOnLoan || current.Status == book_copy.StatusReserved) {
+		message := fmt.Sprintf("Copy with id %d is %s and its status cannot be changed", copyId, current.Status)
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	err = barcodeExists(ctx, tx, updatedCopy.Barcode, copyId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	query = `UPDATE copies SET barcode = COALESCE(NULLIF($2, ''), barcode), condition = COALESCE(NULLIF($3, ''), condition),
+		status = COALESCE(NULLIF($4, ''), status), shelf_location = $5
+		WHERE id = $1 RETURNING ` + copyColumns
+	row := tx.QueryRow(ctx, query, copyId, updatedCopy.Barcode, updatedCopy.Condition, updatedCopy.Status, updatedCopy.ShelfLocation)
+	err = scanCopy(row, &current)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error updating copy: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = touchBook(ctx, tx, bookId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing copy update: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &current, nil
+}
+
+// AuditShelf compares the barcodes scanned on a shelf with the copies recorded there.
+// Available copies of the shelf that were not scanned are reported missing, scanned copies recorded on another
+// shelf are misplaced, scanned copies that should not be on any shelf are unexpected, and barcodes that do not
+// belong to any copy are unknown.
+func (s *CopyServiceStruct) AuditShelf(ctx context.Context, audit book_copy.ShelfAudit) (*book_copy.AuditReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := copyService + "AuditShelf"
+
+	report := book_copy.AuditReport{
+		ShelfLocation: audit.ShelfLocation,
+		Missing:       []book_copy.Copy{},
+		Misplaced:     []book_copy.Copy{},
+		Unexpected:    []book_copy.Copy{},
+		Unknown:       []string{},
+	}
+
+	scanned := make(map[string]bool, len(audit.Barcodes))
+	for _, barcode := range audit.Barcodes {
+		scanned[barcode] = false
+	}
+
+	query := `SELECT ` + copyColumns + ` FROM copies
+		WHERE barcode = ANY($2) OR (shelf_location = $1 AND status = 'available')
+		ORDER BY id`
+	rows, err := s.dbService.GetPool().Query(ctx, query, audit.ShelfLocation, audit.Barcodes)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting copies: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookCopy book_copy.Copy
+		err := scanCopy(rows, &bookCopy)
+		if err != nil {
+			log.Printf("Error scanning copies: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+
+		_, wasScanned := scanned[bookCopy.Barcode]
+		switch {
+		case !wasScanned:
+			report.Missing = append(report.Missing, bookCopy)
+		case bookCopy.ShelfLocation != audit.ShelfLocation:
+			report.Misplaced = append(report.Misplaced, bookCopy)
+		case bookCopy.Status != book_copy.StatusAvailable:
+			report.Unexpected = append(report.Unexpected, bookCopy)
+		default:
+			report.Found++
+		}
+		if wasScanned {
+			scanned[bookCopy.Barcode] = true
+		}
+	}
+	if rows.Err() != nil {
+		log.Printf("Error getting copies: %v", rows.Err())
+		return nil, er.Wrap(funcName, rows.Err())
+	}
+
+	for _, barcode := range audit.Barcodes {
+		if known, ok := scanned[barcode]; ok && !known {
+			report.Unknown = append(report.Unknown, barcode)
+			delete(scanned, barcode)
+		}
+	}
+
+	return &report, nil
+}
+
+// lockBook locks the book row, serializing changes to the status of its copies. Deleted books are not found.
+func lockBook(ctx context.Context, tx pgx.Tx, bookId int) error {
+	funcName := copyService + "lockBook"
+
+	var lockedId int
+	query := `SELECT id FROM books WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err := tx.QueryRow(ctx, query, bookId).Scan(&lockedId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Book with id %d does not exist", bookId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting book: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// touchBook moves a book to its next version, so that its ETag changes with the copies that make up its quantity.
+// It must be called in the transaction that changes the copies of the book.
+func touchBook(ctx context.Context, tx pgx.Tx, bookId int) error {
+	funcName := copyService + "touchBook"
+
+	query := `UPDATE books SET version = version + 1 WHERE id = $1`
+	_, err := tx.Exec(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error updating book version: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// barcodeExists returns an error if another copy than the one with the given id already has the barcode
+func barcodeExists(ctx context.Context, tx pgx.Tx, barcode string, copyId int) error {
+	funcName := copyService + "barcodeExists"
+
+	if barcode == "" {
+		return nil
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM copies WHERE barcode = $1 AND id <> $2)`
+	err := tx.QueryRow(ctx, query, barcode, copyId).Scan(&exists)
+	if err != nil {
+		if er.HandleDeadlineExceededError(copyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error checking barcode: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if exists {
+		message := fmt.Sprintf("Copy with barcode %s already exists", barcode)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	return nil
+}
+
+// scanCopy scans a row selected with copyColumns into the given Copy
+func scanCopy(row pgx.Row, bookCopy *book_copy.Copy) error {
+	return row.Scan(&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Status,
+		&bookCopy.ShelfLocation, &bookCopy.Created_at)
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/config"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/fine"
+	"log"
+	"time"
+)
+
+type FineServiceStruct struct {
+	dbService   database.DatabaseService
+	userService UserService
+}
+
+const fineService = "fineService - "
+
+// ledgerEntryColumns lists the ledger_entries columns in the order expected by scanLedgerEntry
+const ledgerEntryColumns = `id, user_id, book_borrow_id, kind, amount, note, created_at`
+
+// FineService interface defines methods for fine and account ledger operations
+type FineService interface {
+	GetAccount(ctx context.Context, userId int) (*fine.Account, error)
+	RecordPayment(ctx context.Context, userId int, amount int, note string) (*fine.LedgerEntry, error)
+	WaiveFine(ctx context.Context, userId int, amount int, note string) (*fine.LedgerEntry, error)
+}
+
+// NewFineService creates a new instance of FineServiceStruct, implementing FineService
+func NewFineService(dbService database.DatabaseService, userService UserService) FineService {
+	return &FineServiceStruct{
+		dbService:   dbService,
+		userService: userService,
+	}
+}
+
+// GetAccount returns the user's outstanding balance and all ledger entries, the newest first
+func (s *FineServiceStruct) GetAccount(ctx context.Context, userId int) (*fine.Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := fineService + "GetAccount"
+
+	err := s.userService.UserExist(ctx, userId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	account := fine.Account{UserID: userId, Entries: []fine.LedgerEntry{}}
+	query := `SELECT ` + ledgerEntryColumns + ` FROM ledger_entries WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := s.dbService.GetPool().Query(ctx, query, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting ledger entries: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry fine.LedgerEntry
+		err := scanLedgerEntry(rows, &entry)
+		if err != nil {
+			log.Printf("Error scanning ledger entries: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		if entry.Kind == fine.KindFine {
+			account.Balance += entry.Amount
+		} else {
+			account.Balance -= entry.Amount
+		}
+		account.Entries = append(account.Entries, entry)
+	}
+
+	return &account, nil
+}
+
+// RecordPayment records a payment made by the user towards their outstanding balance
+func (s *FineServiceStruct) RecordPayment(ctx context.Context, userId int, amount int, note string) (*fine.LedgerEntry, error) {
+	funcName := fineService + "RecordPayment"
+
+	entry, err := s.credit(ctx, userId, fine.KindPayment, amount, note)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return entry, nil
+}
+
+// WaiveFine forgives part or all of the user's outstanding balance
+func (s *FineServiceStruct) WaiveFine(ctx context.Context, userId int, amount int, note string) (*fine.LedgerEntry, error) {
+	funcName := fineService + "WaiveFine"
+
+	entry, err := s.credit(ctx, userId, fine.KindWaiver, amount, note)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return entry, nil
+}
+
+// credit adds a payment or waiver to the user's ledger. The user row is locked so that concurrent credits
+// cannot together reduce the balance below zero.
+func (s *FineServiceStruct) credit(ctx context.Context, userId int, kind string, amount int, note string) (*fine.LedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := fineService + "credit"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var lockedId int
+	query := `SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, userId).Scan(&lockedId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("User with id %d does not exist", userId)
+			return nil, er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting user: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	balance, err := unpaidBalance(ctx, tx, userId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if amount > balance {
+		message := fmt.Sprintf("Amount %d exceeds the outstanding balance of %d", amount, balance)
+		return nil, er.NewKind(funcName, er.Validation, message, nil)
+	}
+
+	var entry fine.LedgerEntry
+	query = `INSERT INTO ledger_entries (user_id, kind, amount, note) VALUES ($1, $2, $3, $4) RETURNING ` + ledgerEntryColumns
+	err = scanLedgerEntry(tx.QueryRow(ctx, query, userId, kind, amount, note), &entry)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error recording %s: %v", kind, err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing %s: %v", kind, err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &entry, nil
+}
+
+// chargeLateFine fines the user for every started day the loan was returned after its due date.
+// It must run in the same transaction that sets the return date.
+func chargeLateFine(ctx context.Context, tx pgx.Tx, borrowId int, loanConfig config.LoanConfig) error {
+	funcName := fineService + "chargeLateFine"
+
+	if loanConfig.FinePerDay <= 0 {
+		return nil
+	}
+
+	query := `INSERT INTO ledger_entries (user_id, book_borrow_id, kind, amount, note)
+		SELECT user_id, id, 'fine', days_late * $2, days_late || ' day(s) late'
+		FROM (
+			SELECT user_id, id, CEIL(EXTRACT(EPOCH FROM (return_date - due_date)) / 86400)::int AS days_late
+			FROM book_borrows WHERE id = $1
+		) loan
+		WHERE days_late > 0`
+	_, err := tx.Exec(ctx, query, borrowId, loanConfig.FinePerDay)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error charging late fine: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// unpaidBalance returns the sum of the user's fines minus their payments and waivers
+func unpaidBalance(ctx context.Context, tx pgx.Tx, userId int) (int, error) {
+	funcName := fineService + "unpaidBalance"
+
+	var balance int
+	query := `SELECT COALESCE(SUM(CASE WHEN kind = 'fine' THEN amount ELSE -amount END), 0) FROM ledger_entries WHERE user_id = $1`
+	err := tx.QueryRow(ctx, query, userId).Scan(&balance)
+	if err != nil {
+		if er.HandleDeadlineExceededError(fineService, err) != nil {
+			return 0, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting unpaid balance: %v", err)
+		return 0, er.Wrap(funcName, err)
+	}
+
+	return balance, nil
+}
+
+// scanLedgerEntry scans a row selected with ledgerEntryColumns into the given LedgerEntry
+func scanLedgerEntry(row pgx.Row, entry *fine.LedgerEntry) error {
+	return row.Scan(&entry.ID, &entry.UserID, &entry.BookBorrowID, &entry.Kind, &entry.Amount, &entry.Note, &entry.Created_at)
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/config"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/hold"
+	"log"
+	"time"
+)
+
+type HoldServiceStruct struct {
+	dbService   database.DatabaseService
+	userService UserService
+	loanConfig  config.LoanConfig
+}
+
+const holdService = "holdService - "
+
+// holdColumns lists the holds columns in the order expected by scanHold
+const holdColumns = `id, book_id, user_id, copy_id, status, created_at, ready_at, expires_at`
+
+// HoldService interface defines methods for hold-related operations
+type HoldService interface {
+	PlaceHold(ctx context.Context, bookId int, userId int) (*hold.Hold, error)
+	GetBookHolds(ctx context.Context, bookId int) ([]hold.Hold, error)
+	GetHold(ctx context.Context, holdId int) (*hold.Hold, error)
+	CancelHold(ctx context.Context, holdId int) error
+}
+
+// NewHoldService creates a new instance of HoldServiceStruct, implementing HoldService
+func NewHoldService(dbService database.DatabaseService, userService UserService, loanConfig config.LoanConfig) HoldService {
+	return &HoldServiceStruct{
+		dbService:   dbService,
+		userService: userService,
+		loanConfig:  loanConfig,
+	}
+}
+
+// PlaceHold adds the user to the end of the hold queue of a book that has no copies available.
+// A user can have only one active hold per book, and cannot place a hold on a book they currently have borrowed.
+func (s *HoldServiceStruct) PlaceHold(ctx context.Context, bookId int, userId int) (*hold.Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := holdService + "PlaceHold"
+
+	err := s.userService.UserExist(ctx, userId)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	available, err := lockAvailableQuantity(ctx, tx, bookId, s.loanConfig)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if available > 0 {
+		message := "Book is available and can be borrowed without a hold"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	var borrowed, onHold bool
+	query := `SELECT
+		EXISTS (SELECT 1 FROM book_borrows WHERE book_id = $1 AND user_id = $2 AND return_date IS NULL),
+		EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND user_id = $2 AND status IN ('waiting', 'ready'))`
+	err = tx.QueryRow(ctx, query, bookId, userId).Scan(&borrowed, &onHold)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error checking existing holds: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if borrowed {
+		message := "Book is already borrowed by the user"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+	if onHold {
+		message := "User already has a hold on this book"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	var newHold hold.Hold
+	query = `INSERT INTO holds (book_id, user_id) VALUES ($1, $2) RETURNING ` + holdColumns
+	err = scanHold(tx.QueryRow(ctx, query, bookId, userId), &newHold)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error placing hold: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing hold: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &newHold, nil
+}
+
+// GetBookHolds returns the active holds of a book in queue order, ready holds first
+func (s *HoldServiceStruct) GetBookHolds(ctx context.Context, bookId int) ([]hold.Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := holdService + "GetBookHolds"
+
+	var result []hold.Hold
+	query := `SELECT ` + holdColumns + ` FROM holds WHERE book_id = $1 AND status IN ('waiting', 'ready')
+		ORDER BY status = 'ready' DESC, created_at, id`
+	rows, err := s.dbService.GetPool().Query(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting holds: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookHold hold.Hold
+		err := scanHold(rows, &bookHold)
+		if err != nil {
+			log.Printf("Error scanning holds: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		result = append(result, bookHold)
+	}
+
+	return result, nil
+}
+
+// GetHold retrieves a hold by its id
+func (s *HoldServiceStruct) GetHold(ctx context.Context, holdId int) (*hold.Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := holdService + "GetHold"
+
+	var bookHold hold.Hold
+	query := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1`
+	err := scanHold(s.dbService.GetPool().QueryRow(ctx, query, holdId), &bookHold)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Hold with id %d does not exist", holdId)
+			return nil, er.NewKind(funcName, er.NotFound, message, err)
+		}
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting hold: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return &bookHold, nil
+}
+
+// CancelHold cancels an active hold. If the hold was ready for pickup, the copy passes on to the next user in the queue.
+func (s *HoldServiceStruct) CancelHold(ctx context.Context, holdId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := holdService + "CancelHold"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var bookId int
+	query := `SELECT book_id FROM holds WHERE id = $1 AND status IN ('waiting', 'ready')`
+	err = tx.QueryRow(ctx, query, holdId).Scan(&bookId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Active hold with id %d does not exist", holdId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting hold: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	_, err = lockAvailableQuantity(ctx, tx, bookId, s.loanConfig)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query = `WITH cancelled AS (
+			UPDATE holds SET status = 'cancelled' WHERE id = $1 AND status IN ('waiting', 'ready') RETURNING copy_id
+		)
+		UPDATE copies SET status = 'available' WHERE id = (SELECT copy_id FROM cancelled) AND status = 'reserved'`
+	tag, err := tx.Exec(ctx, query, holdId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error cancelling hold: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if tag.RowsAffected() > 0 {
+		err = touchBook(ctx, tx, bookId)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	err = promoteHolds(ctx, tx, bookId, s.loanConfig)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing hold cancellation: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// lockAvailableQuantity locks the book row, brings its hold queue up to date and returns the number of copies
+// that can be borrowed by anyone, that is the copies on the shelf that are not reserved for ready holds
+func lockAvailableQuantity(ctx context.Context, tx pgx.Tx, bookId int, loanConfig config.LoanConfig) (int, error) {
+	funcName := holdService + "lockAvailableQuantity"
+
+	err := lockBook(ctx, tx, bookId)
+	if err != nil {
+		return 0, er.Wrap(funcName, err)
+	}
+
+	err = promoteHolds(ctx, tx, bookId, loanConfig)
+	if err != nil {
+		return 0, er.Wrap(funcName, err)
+	}
+
+	var available int
+	query := `SELECT COUNT(*) FROM copies WHERE book_id = $1 AND status = 'available'`
+	err = tx.QueryRow(ctx, query, bookId).Scan(&available)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return 0, er.Wrap(funcName, err)
+		}
+		log.Printf("Error counting available copies: %v", err)
+		return 0, er.Wrap(funcName, err)
+	}
+
+	return available, nil
+}
+
+// promoteHolds expires ready holds that were not picked up in time, putting their copies back on the shelf,
+// and reserves an available copy for each hold at the head of the waiting queue. The book moves to its next version
+// if any copy changed. The book row must be locked by the caller.
+func promoteHolds(ctx context.Context, tx pgx.Tx, bookId int, loanConfig config.LoanConfig) error {
+	funcName := holdService + "promoteHolds"
+
+	query := `WITH expired AS (
+			UPDATE holds SET status = 'expired' WHERE book_id = $1 AND status = 'ready' AND expires_at <= NOW() RETURNING copy_id
+		)
+		UPDATE copies SET status = 'available' WHERE id IN (SELECT copy_id FROM expired) AND status = 'reserved'`
+	expired, err := tx.Exec(ctx, query, bookId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error expiring holds: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	query = `WITH waiting AS (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS position
+			FROM holds WHERE book_id = $1 AND status = 'waiting'
+		), shelf AS (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS position
+			FROM copies WHERE book_id = $1 AND status = 'available'
+		), paired AS (
+			SELECT waiting.id AS hold_id, shelf.id AS copy_id FROM waiting JOIN shelf USING (position)
+		), reserved AS (
+			UPDATE copies SET status = 'reserved' WHERE id IN (SELECT copy_id FROM paired)
+		)
+		UPDATE holds SET status = 'ready', copy_id = paired.copy_id, ready_at = NOW(), expires_at = NOW() + make_interval(secs => $2)
+		FROM paired WHERE holds.id = paired.hold_id`
+	promoted, err := tx.Exec(ctx, query, bookId, loanConfig.HoldPickupWindow.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(holdService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error promoting holds: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if expired.RowsAffected() > 0 || promoted.RowsAffected() > 0 {
+		err = touchBook(ctx, tx, bookId)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	return nil
+}
+
+// scanHold scans a row selected with holdColumns into the given Hold
+func scanHold(row pgx.Row, bookHold *hold.Hold) error {
+	return row.Scan(&bookHold.ID, &bookHold.BookID, &bookHold.UserID, &bookHold.CopyID, &bookHold.Status,
+		&bookHold.Created_at, &bookHold.Ready_at, &bookHold.Expires_at)
+}
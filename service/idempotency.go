@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/database"
+	er "kokal5296/errors"
+	"kokal5296/models/idempotency"
+	"log"
+	"time"
+)
+
+type IdempotencyServiceStruct struct {
+	dbService database.DatabaseService
+	ttl       time.Duration
+}
+
+const idempotencyService = "idempotencyService - "
+
+// idempotencyLease is how long a key stays claimed by a request that is being handled. It is longer than any request
+// may take, so a key still being handled after it was claimed by a request that crashed or was stopped before it completed
+// can be claimed again by a retry.
+const idempotencyLease = 2 * importTimeout
+
+// IdempotencyService interface defines methods for storing the responses of requests with an idempotency key
+type IdempotencyService interface {
+	Begin(ctx context.Context, scope string, key string, fingerprint string) (*idempotency.Response, error)
+	Complete(ctx context.Context, scope string, key string, response idempotency.Response) error
+	Release(ctx context.Context, scope string, key string) error
+	PurgeExpired(ctx context.Context) error
+}
+
+// NewIdempotencyService creates a new instance of IdempotencyServiceStruct, implementing IdempotencyService.
+// Keys and their responses are kept for the ttl.
+func NewIdempotencyService(dbService database.DatabaseService, ttl time.Duration) IdempotencyService {
+	return &IdempotencyServiceStruct{
+		dbService: dbService,
+		ttl:       ttl,
+	}
+}
+
+// Begin claims the key of a request in the scope of its client, e.g. a user. It returns nil if the key is new,
+// so the request is handled, and the stored response if the request was already handled, so it is replayed.
+// Reusing a key for a request with another fingerprint is a Validation error, and retrying a request that is
+// still being handled is a Conflict, unless the key was claimed longer than idempotencyLease ago, when the retry
+// takes the key over. An expired key is claimed again as if it were new, so only the row of the key is touched;
+// expired keys are removed separately with PurgeExpired.
+func (s *IdempotencyServiceStruct) Begin(ctx context.Context, scope string, key string, fingerprint string) (*idempotency.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := idempotencyService + "Begin"
+
+	query := `INSERT INTO idempotency_keys (scope, key, fingerprint, expires_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (scope, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL,
+			created_at = NOW(), locked_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW() OR (idempotency_keys.status IS NULL
+			AND idempotency_keys.fingerprint = EXCLUDED.fingerprint AND idempotency_keys.locked_at < NOW() - make_interval(secs => $5))`
+	tag, err := s.dbService.GetPool().Exec(ctx, query, scope, key, fingerprint, s.ttl.Seconds(), idempotencyLease.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(idempotencyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error storing idempotency key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var storedFingerprint string
+	var status *int
+	var response idempotency.Response
+	query = `SELECT fingerprint, status, headers, body FROM idempotency_keys WHERE scope = $1 AND key = $2 AND expires_at >= NOW()`
+	err = s.dbService.GetPool().QueryRow(ctx, query, scope, key).Scan(&storedFingerprint, &status, &response.Headers, &response.Body)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := "The Idempotency-Key expired while the request was made, retry the request"
+			return nil, er.NewKind(funcName, er.Conflict, message, nil)
+		}
+		if er.HandleDeadlineExceededError(idempotencyService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting idempotency key: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+
+	if storedFingerprint != fingerprint {
+		message := "The Idempotency-Key was already used for a different request"
+		return nil, er.NewKind(funcName, er.Validation, message, nil)
+	}
+
+	if status == nil {
+		message := "A request with the Idempotency-Key is still being processed"
+		return nil, er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	response.Status = *status
+	return &response, nil
+}
+
+// Complete stores the response of a request whose key was claimed with Begin, so that it is replayed to retries
+func (s *IdempotencyServiceStruct) Complete(ctx context.Context, scope string, key string, response idempotency.Response) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := idempotencyService + "Complete"
+
+	query := `UPDATE idempotency_keys SET status = $3, headers = $4, body = $5 WHERE scope = $1 AND key = $2`
+	_, err := s.dbService.GetPool().Exec(ctx, query, scope, key, response.Status, response.Headers, response.Body)
+	if err != nil {
+		if er.HandleDeadlineExceededError(idempotencyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error storing idempotent response: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// Release removes a key claimed with Begin without storing a response, so that a retry of the request is handled again
+func (s *IdempotencyServiceStruct) Release(ctx context.Context, scope string, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := idempotencyService + "Release"
+
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2`
+	_, err := s.dbService.GetPool().Exec(ctx, query, scope, key)
+	if err != nil {
+		if er.HandleDeadlineExceededError(idempotencyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error releasing idempotency key: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// PurgeExpired removes the keys that have expired, with their responses. It is meant to run periodically,
+// outside of requests.
+func (s *IdempotencyServiceStruct) PurgeExpired(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	funcName := idempotencyService + "PurgeExpired"
+
+	query := `DELETE FROM idempotency_keys WHERE expires_at < NOW()`
+	tag, err := s.dbService.GetPool().Exec(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(idempotencyService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error removing expired idempotency keys: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	log.Printf("Removed %d expired idempotency keys", tag.RowsAffected())
+	return nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	er "kokal5296/errors"
+	"log"
+	"time"
+)
+
+const imports = "imports - "
+
+// importTimeout is how long an import may take, which is longer than other requests as it can hold many thousands of rows
+const importTimeout = 2 * time.Minute
+
+// importBatchSize is the number of rows that are copied into the database at once
+const importBatchSize = 1000
+
+// batches splits the rows of an import into batches of at most importBatchSize rows
+func batches[T any](rows []T) [][]T {
+	var result [][]T
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := min(start+importBatchSize, len(rows))
+		result = append(result, rows[start:end])
+	}
+	return result
+}
+
+// reserveIds takes count new ids from the id sequence of the table, so rows can be copied into it with their ids known
+func reserveIds(ctx context.Context, tx pgx.Tx, table string, count int) ([]int, error) {
+	funcName := imports + "reserveIds"
+
+	ids := make([]int, 0, count)
+	query := `SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`
+	rows, err := tx.Query(ctx, query, table, count)
+	if err != nil {
+		if er.HandleDeadlineExceededError(imports, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error reserving ids of %s: %v", table, err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			log.Printf("Error scanning reserved ids: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if rows.Err() != nil {
+		if er.HandleDeadlineExceededError(imports, rows.Err()) != nil {
+			return nil, er.Wrap(funcName, rows.Err())
+		}
+		log.Printf("Error reserving ids of %s: %v", table, rows.Err())
+		return nil, er.Wrap(funcName, rows.Err())
+	}
+
+	return ids, nil
+}
+
+// copyRows copies the rows into the columns of the table with the COPY protocol
+func copyRows(ctx context.Context, tx pgx.Tx, table string, columns []string, rows [][]interface{}) error {
+	funcName := imports + "copyRows"
+
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		if er.HandleDeadlineExceededError(imports, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error copying rows into %s: %v", table, err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// finishImport commits the transaction of an import, or rolls it back if the import is a dry run
+func finishImport(ctx context.Context, tx pgx.Tx, dryRun bool) error {
+	funcName := imports + "finishImport"
+
+	var err error
+	if dryRun {
+		err = tx.Rollback(ctx)
+	} else {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		if er.HandleDeadlineExceededError(imports, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error finishing import: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// nullIfZero returns nil for the zero value, so that it is copied as NULL like NULLIF does in statements
+func nullIfZero[T comparable](value T) interface{} {
+	var zero T
+	if value == zero {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"kokal5296/config"
+	er "kokal5296/errors"
+	"kokal5296/models/user"
+	"log"
+)
+
+const loanPolicy = "loanPolicy - "
+
+// Loan policy rules that can refuse a borrow
+const (
+	RuleAccountBlocked     = "account_blocked"
+	RuleUnpaidBalance      = "unpaid_balance"
+	RuleMaxConcurrentLoans = "max_concurrent_loans"
+	RuleMaxLoansPerTitle   = "max_loans_per_title"
+)
+
+// PolicyViolation is the error returned when a borrow is refused by a loan policy rule
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface for the PolicyViolation struct
+func (v *PolicyViolation) Error() string {
+	return v.Message
+}
+
+// PolicyEngine decides whether a user is allowed to borrow a book
+type PolicyEngine interface {
+	CheckBorrow(ctx context.Context, tx pgx.Tx, userId int, bookId int) error
+}
+
+type LoanPolicyEngine struct {
+	loanConfig config.LoanConfig
+}
+
+// NewPolicyEngine creates a new instance of LoanPolicyEngine, implementing PolicyEngine
+func NewPolicyEngine(loanConfig config.LoanConfig) PolicyEngine {
+	return &LoanPolicyEngine{
+		loanConfig: loanConfig,
+	}
+}
+
+// CheckBorrow checks the user's account and current loans against the policy of their membership tier.
+// It locks the user row, so concurrent borrows by the same user are checked one after another,
+// and returns a *PolicyViolation naming the first rule that fails.
+func (e *LoanPolicyEngine) CheckBorrow(ctx context.Context, tx pgx.Tx, userId int, bookId int) error {
+	funcName := loanPolicy + "CheckBorrow"
+
+	var membership user.Membership
+	query := `SELECT tier, blocked FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err := tx.QueryRow(ctx, query, userId).Scan(&membership.Tier, &membership.Blocked)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("User with id %d does not exist", userId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(loanPolicy, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting user membership: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if membership.Blocked {
+		return er.Wrap(funcName, &PolicyViolation{
+			Rule:    RuleAccountBlocked,
+			Message: "User account is blocked from borrowing",
+		})
+	}
+
+	balance, err := unpaidBalance(ctx, tx, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	if balance > e.loanConfig.MaxUnpaidBalance {
+		return er.Wrap(funcName, &PolicyViolation{
+			Rule:    RuleUnpaidBalance,
+			Message: fmt.Sprintf("User has an unpaid balance of %d, which exceeds the limit of %d", balance, e.loanConfig.MaxUnpaidBalance),
+		})
+	}
+
+	var openLoans, openLoansOfTitle int
+	query = `SELECT COUNT(*), COUNT(*) FILTER (WHERE book_id = $2) FROM book_borrows WHERE user_id = $1 AND return_date IS NULL`
+	err = tx.QueryRow(ctx, query, userId, bookId).Scan(&openLoans, &openLoansOfTitle)
+	if err != nil {
+		if er.HandleDeadlineExceededError(loanPolicy, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error counting open loans: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	policy := membership.Policy()
+	if openLoans >= policy.MaxConcurrentLoans {
+		return er.Wrap(funcName, &PolicyViolation{
+			Rule:    RuleMaxConcurrentLoans,
+			Message: fmt.Sprintf("User already has %d books borrowed, the limit for the %s tier is %d", openLoans, membership.Tier, policy.MaxConcurrentLoans),
+		})
+	}
+
+	if openLoansOfTitle >= policy.MaxLoansPerTitle {
+		return er.Wrap(funcName, &PolicyViolation{
+			Rule:    RuleMaxLoansPerTitle,
+			Message: fmt.Sprintf("User already has %d copies of this book borrowed, the limit for the %s tier is %d", openLoansOfTitle, membership.Tier, policy.MaxLoansPerTitle),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	er "kokal5296/errors"
+	"kokal5296/models/page"
+	"log"
+	"sort"
+	"strings"
+)
+
+const pagination = "pagination - "
+
+// likeContains is the LIKE pattern of the values that contain the filter value %[1]s. The wildcards % and _
+// and the escape character \ in the filter value are escaped, so that they only match themselves.
+const likeContains = `'%%' || replace(replace(replace(%[1]s, '\', '\\'), '%%', '\%%'), '_', '\_') || '%%'`
+
+// UserListSpec lists the sort columns and filters allowed when listing users
+var UserListSpec = page.Spec{
+	DefaultSort: "id",
+	SoftDelete:  true,
+	Sorts: map[string]page.Column{
+		"id":         {Expr: "id", Type: "int"},
+		"first_name": {Expr: "first_name", Type: "text"},
+		"last_name":  {Expr: "last_name", Type: "text"},
+		"email":      {Expr: "email", Type: "text"},
+	},
+	Filters: map[string]page.Filter{
+		"name":    {Condition: `(first_name || ' ' || last_name) ILIKE ` + likeContains, Type: "text"},
+		"tier":    {Condition: `tier = %[1]s`, Type: "text"},
+		"blocked": {Condition: `blocked = %[1]s`, Type: "bool"},
+	},
+}
+
+// BookListSpec lists the sort columns and filters allowed when listing books
+var BookListSpec = page.Spec{
+	DefaultSort: "id",
+	SoftDelete:  true,
+	Sorts: map[string]page.Column{
+		"id":               {Expr: "id", Type: "int"},
+		"title":            {Expr: "title", Type: "text"},
+		"publication_year": {Expr: "COALESCE(publication_year, 0)", Type: "int"},
+	},
+	Filters: map[string]page.Filter{
+		"title":     {Condition: `title ILIKE ` + likeContains, Type: "text"},
+		"author":    {Condition: `EXISTS (SELECT 1 FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id AND a.name ILIKE ` + likeContains + `)`, Type: "text"},
+		"publisher": {Condition: `publisher = %[1]s`, Type: "text"},
+		"language":  {Condition: `language = %[1]s`, Type: "text"},
+		"isbn":      {Condition: `isbn = %[1]s`, Type: "text"},
+	},
+}
+
+// AvailableBookListSpec lists the sort columns and filters allowed when listing available books, which are never deleted
+var AvailableBookListSpec = page.Spec{
+	DefaultSort: BookListSpec.DefaultSort,
+	Sorts:       BookListSpec.Sorts,
+	Filters:     BookListSpec.Filters,
+}
+
+// BookBorrowListSpec lists the sort columns and filters allowed when listing borrowed books
+var BookBorrowListSpec = page.Spec{
+	DefaultSort: "id",
+	Sorts: map[string]page.Column{
+		"id":          {Expr: "id", Type: "int"},
+		"borrow_date": {Expr: "borrow_date", Type: "timestamptz"},
+		"due_date":    {Expr: "due_date", Type: "timestamptz"},
+	},
+	Filters: map[string]page.Filter{
+		"user_id": {Condition: `user_id = %[1]s`, Type: "int"},
+		"book_id": {Condition: `book_id = %[1]s`, Type: "int"},
+		"overdue": {Condition: `(due_date < NOW()) = %[1]s`, Type: "bool"},
+	},
+}
+
+// LoanHistoryListSpec lists the sort columns and filters allowed when listing the borrowing history of a user or a book.
+// The status filter is one of open, returned or all, and from and to limit the borrow date.
+var LoanHistoryListSpec = page.Spec{
+	DefaultSort: "-borrow_date",
+	Sorts: map[string]page.Column{
+		"id":          {Expr: "id", Type: "int"},
+		"borrow_date": {Expr: "borrow_date", Type: "timestamptz"},
+		"due_date":    {Expr: "due_date", Type: "timestamptz"},
+	},
+	Filters: map[string]page.Filter{
+		"status": {Condition: `CASE %[1]s::text WHEN 'open' THEN return_date IS NULL WHEN 'returned' THEN return_date IS NOT NULL ELSE TRUE END`, Type: "text"},
+		"from":   {Condition: `borrow_date >= %[1]s`, Type: "time"},
+		"to":     {Condition: `borrow_date < %[1]s`, Type: "time"},
+	},
+}
+
+// cursorRow scans the sort value and id selected after the columns of a list item into the cursor of the item
+type cursorRow struct {
+	pgx.Row
+	cursor *page.Cursor
+}
+
+// Scan scans the row into the destinations of the item and the cursor
+func (r cursorRow) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, &r.cursor.Value, &r.cursor.ID)...)
+}
+
+// listPage selects one page of the rows of a table that match the conditions in where and the filters in params,
+// in the sort order of params, with rows without a sort value last and ties broken by id. Pages are found by the sort value,
+// which may be NULL, and id of the last row of the previous page, so rows added or removed meanwhile do not shift
+// the following pages. The spec must allow the sort and filters.
+// Soft deleted rows are left out of lists that have them, unless params include them.
+func listPage[T any](ctx context.Context, pool *pgxpool.Pool, spec page.Spec, params page.Params, columns string, table string,
+	where []string, scan func(pgx.Row, *T) error) (*page.Page[T], error) {
+	funcName := pagination + "listPage"
+
+	sortName := strings.TrimPrefix(params.Sort, "-")
+	column, ok := spec.Sorts[sortName]
+	if !ok {
+		message := fmt.Sprintf("Sorting by %s is not supported", sortName)
+		return nil, er.NewKind(funcName, er.Validation, message, nil)
+	}
+
+	direction, operator := "ASC", ">"
+	if strings.HasPrefix(params.Sort, "-") {
+		direction, operator = "DESC", "<"
+	}
+
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := append([]string{"TRUE"}, where...)
+	if spec.SoftDelete && !params.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	names := make([]string, 0, len(params.Filters))
+	for name := range params.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filter, ok := spec.Filters[name]
+		if !ok {
+			message := fmt.Sprintf("Filtering by %s is not supported", name)
+			return nil, er.NewKind(funcName, er.Validation, message, nil)
+		}
+		conditions = append(conditions, fmt.Sprintf(filter.Condition, arg(params.Filters[name])))
+	}
+
+	if params.Cursor != nil {
+		if params.Cursor.Sort != params.Sort {
+			message := "Cursor does not belong to the requested sort order"
+			return nil, er.NewKind(funcName, er.Validation, message, nil)
+		}
+		if params.Cursor.Value == nil {
+			// Only rows without a sort value follow a row without one
+			conditions = append(conditions, fmt.Sprintf("(%s) IS NULL AND id %s %s::int",
+				column.Expr, operator, arg(params.Cursor.ID)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("((%[1]s, id) %[2]s (%[3]s::%[4]s, %[5]s::int) OR (%[1]s) IS NULL)",
+				column.Expr, operator, arg(*params.Cursor.Value), column.Type, arg(params.Cursor.ID)))
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT %s, (%s)::text, id FROM %s WHERE %s ORDER BY %s %s NULLS LAST, id %s LIMIT %s`,
+		columns, column.Expr, table, strings.Join(conditions, " AND "), column.Expr, direction, direction, arg(params.Limit+1))
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		if er.HandleDeadlineExceededError(pagination, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error listing %s: %v", table, err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer rows.Close()
+
+	result := page.Page[T]{Items: []T{}}
+	var last page.Cursor
+	for rows.Next() {
+		if len(result.Items) == params.Limit {
+			last.Sort = params.Sort
+			result.NextCursor = last.Encode()
+			break
+		}
+
+		var item T
+		err := scan(cursorRow{Row: rows, cursor: &last}, &item)
+		if err != nil {
+			log.Printf("Error scanning %s: %v", table, err)
+			return nil, er.Wrap(funcName, err)
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,27 @@
+package service
+
+import "fmt"
+
+// patchColumn describes how a field of a merge patch is written to its column: the assignment,
+// where %s stands for the argument, and the function taking the value of the argument from the patch
+type patchColumn[T any] struct {
+	assignment string
+	value      func(T) interface{}
+}
+
+// patchAssignments returns the SET assignments of the fields of the patch that have a column, followed by
+// the assignment that moves the version, and their arguments, numbered from $1
+func patchAssignments[T any](columns map[string]patchColumn[T], patch T, fields []string) ([]string, []interface{}) {
+	assignments := make([]string, 0, len(fields)+1)
+	args := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		column, ok := columns[field]
+		if !ok {
+			continue
+		}
+		args = append(args, column.value(patch))
+		assignments = append(assignments, fmt.Sprintf(column.assignment, fmt.Sprintf("$%d", len(args))))
+	}
+	assignments = append(assignments, "version = version + 1")
+	return assignments, args
+}
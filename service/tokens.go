@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"kokal5296/models/auth"
+	"strings"
+	"time"
+)
+
+// tokenHeader is the encoded JOSE header of every token: HMAC SHA-256 signed JSON Web Tokens
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// signToken encodes the claims as a JSON Web Token signed with HMAC SHA-256
+func signToken(claims auth.Claims, key []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + tokenSignature(unsigned, key), nil
+}
+
+// parseToken verifies the signature and expiry of a token signed by signToken and returns its claims
+func parseToken(token string, key []byte, now time.Time) (*auth.Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	signature := tokenSignature(parts[0]+"."+parts[1], key)
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var claims auth.Claims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token has expired")
+	}
+
+	return &claims, nil
+}
+
+// tokenSignature returns the encoded HMAC SHA-256 signature of the header and payload of a token
+func tokenSignature(unsigned string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -3,10 +3,17 @@ package service
 import (
 	"context"
 	"fmt"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
 	"kokal5296/database"
 	er "kokal5296/errors"
+	"kokal5296/models/audit"
+	"kokal5296/models/bulk"
+	"kokal5296/models/page"
 	"kokal5296/models/user"
 	"log"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -16,14 +23,31 @@ type UserServiceStruct struct {
 
 const userService = "userService - "
 
+// userColumns lists the users columns in the order expected by scanUser
+const userColumns = `id, first_name, last_name, tier, blocked, role, COALESCE(email, ''), deleted_at, version`
+
+// UserPatchFields lists the fields of a user that can be changed with a merge patch
+var UserPatchFields = []string{"first_name", "last_name"}
+
+// userPatchColumns holds the columns of the fields of a user merge patch
+var userPatchColumns = map[string]patchColumn[user.User]{
+	"first_name": {`first_name = %s`, func(u user.User) interface{} { return u.FirstName }},
+	"last_name":  {`last_name = %s`, func(u user.User) interface{} { return u.LastName }},
+}
+
 // UserService interface defines methods for user-related operations
 type UserService interface {
 	CreateUser(ctx context.Context, newUser user.User) error
-	GetUser(ctx context.Context, userId int) (*user.User, error)
-	GetAllUsers(ctx context.Context) ([]user.User, error)
-	UpdateUser(ctx context.Context, user user.User, userId int) error
+	GetUser(ctx context.Context, userId int, includeDeleted bool) (*user.User, error)
+	GetAllUsers(ctx context.Context, params page.Params) (*page.Page[user.User], error)
+	UpdateUser(ctx context.Context, user user.User, userId int, version int) error
+	PatchUser(ctx context.Context, userId int, version int, patch user.User, fields []string) error
 	DeleteUser(ctx context.Context, userId int) error
+	RestoreUser(ctx context.Context, userId int) error
+	UpdateMembership(ctx context.Context, membership user.Membership, userId int) error
+	UpdateRole(ctx context.Context, role string, userId int) error
 	UserExist(ctx context.Context, userId int) error
+	ImportUsers(ctx context.Context, rows []bulk.Row[user.Import], options bulk.Options) (*bulk.Report, error)
 }
 
 // NewUserService creates a new instance of UserServiceStruct, implementing UserService
@@ -33,7 +57,7 @@ func NewUserService(dbService database.DatabaseService) UserService {
 	}
 }
 
-// CreateUser creates a new user in the database
+// CreateUser creates a new user in the database and records it in the audit log
 func (s *UserServiceStruct) CreateUser(ctx context.Context, newUser user.User) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -45,8 +69,44 @@ func (s *UserServiceStruct) CreateUser(ctx context.Context, newUser user.User) e
 		return er.Wrap(funcName, err)
 	}
 
-	query := `INSERT INTO users (first_name, last_name) VALUES ($1, $2)`
-	_, err = s.dbService.GetPool().Exec(ctx, query, newUser.FirstName, newUser.LastName)
+	if newUser.Email != "" {
+		err = s.emailExists(ctx, newUser.Email)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+	}
+
+	if newUser.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			return er.Wrap(funcName, err)
+		}
+		newUser.PasswordHash = string(hash)
+	}
+
+	if newUser.Tier == "" {
+		newUser.Tier = user.TierStandard
+	}
+	if newUser.Role == "" {
+		newUser.Role = user.RolePatron
+	}
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userId int
+	query := `INSERT INTO users (first_name, last_name, tier, blocked, role, email, password_hash)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, '')) RETURNING id`
+	err = tx.QueryRow(ctx, query, newUser.FirstName, newUser.LastName, newUser.Tier, newUser.Blocked,
+		newUser.Role, newUser.Email, newUser.PasswordHash).Scan(&userId)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
 			return er.Wrap(funcName, err)
@@ -55,24 +115,42 @@ func (s *UserServiceStruct) CreateUser(ctx context.Context, newUser user.User) e
 		return er.Wrap(funcName, err)
 	}
 
+	err = recordAuditEvent(ctx, tx, audit.ActionCreate, audit.EntityUser, userId, nil)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing user: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
 	log.Println("User created")
 	return nil
 }
 
-// GetUser retrieves a user from the database by their ID
-func (s *UserServiceStruct) GetUser(ctx context.Context, userId int) (*user.User, error) {
+// GetUser retrieves a user from the database by their ID. Deleted users are only found with includeDeleted set.
+func (s *UserServiceStruct) GetUser(ctx context.Context, userId int, includeDeleted bool) (*user.User, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := userService + "GetUser,"
 
 	var user user.User
-	query := `SELECT id,  first_name, last_name FROM users WHERE id = $1`
-	err := s.dbService.GetPool().QueryRow(ctx, query, userId).Scan(&user.ID, &user.FirstName, &user.LastName)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND ($2 OR deleted_at IS NULL)`
+	err := scanUser(s.dbService.GetPool().QueryRow(ctx, query, userId, includeDeleted), &user)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
 			return nil, er.Wrap(funcName, err)
 		}
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("User with id %d does not exist", userId)
+			return nil, er.NewKind(funcName, er.NotFound, message, err)
+		}
 		log.Printf("Error getting user: %v", err)
 		return nil, er.Wrap(funcName, err)
 	}
@@ -80,82 +158,213 @@ func (s *UserServiceStruct) GetUser(ctx context.Context, userId int) (*user.User
 	return &user, nil
 }
 
-// GetAllUsers retrieves all users from the database
-func (s *UserServiceStruct) GetAllUsers(ctx context.Context) ([]user.User, error) {
+// GetAllUsers retrieves one page of the users from the database
+func (s *UserServiceStruct) GetAllUsers(ctx context.Context, params page.Params) (*page.Page[user.User], error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := userService + "GetAllUsers,"
 
-	var users []user.User
-	query := `SELECT id,  first_name, last_name FROM users`
-	rows, err := s.dbService.GetPool().Query(ctx, query)
+	users, err := listPage(ctx, s.dbService.GetPool(), UserListSpec, params, userColumns, "users", nil, scanUser)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return users, nil
+}
+
+// UpdateUser updates a user's information in the database, if the user is still at the given version.
+// A version of 0 updates the user at any version. The user row is locked while it is updated.
+func (s *UserServiceStruct) UpdateUser(ctx context.Context, updateUser user.User, userId int, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := userService + "UpdateUser,"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
-			return nil, er.Wrap(funcName, err)
+			return er.Wrap(funcName, err)
 		}
-		message := fmt.Sprintf("Error getting all users")
-		return nil, er.New(funcName, message, err)
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
 
-	for rows.Next() {
-		var user user.User
-		err = rows.Scan(&user.ID, &user.FirstName, &user.LastName)
-		if err != nil {
-			message := fmt.Sprintf("Error scanning user: %v", err)
-			return nil, er.New(funcName, message, err)
+	err = lockVersion(ctx, tx, audit.EntityUser, userId, version)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	nameTaken, err := userNameTaken(ctx, tx, userId, &updateUser.FirstName, &updateUser.LastName)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	if nameTaken {
+		message := fmt.Sprintf("User with this name: %s, and last name: %s, already exists", updateUser.FirstName, updateUser.LastName)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityUser, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query := `UPDATE users SET first_name = $1, last_name = $2, version = version + 1 WHERE id = $3`
+	_, err = tx.Exec(ctx, query, updateUser.FirstName, updateUser.LastName, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
 		}
-		users = append(users, user)
+		message := fmt.Sprintf("Error updating user")
+		return er.New(funcName, message, err)
 	}
 
-	return users, nil
+	err = recordAuditEvent(ctx, tx, audit.ActionUpdate, audit.EntityUser, userId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing user update: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
 }
 
-// UpdateUser updates a user's information in the database
-func (s *UserServiceStruct) UpdateUser(ctx context.Context, updateUser user.User, userId int) error {
+// PatchUser changes only the given fields of a user to their values in the patch, in a single update,
+// unless another user already has the resulting name. The user is only changed if it is still at the given version,
+// or at any version if it is 0.
+func (s *UserServiceStruct) PatchUser(ctx context.Context, userId int, version int, patch user.User, fields []string) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	funcName := userService + "UpdateUser,"
+	funcName := userService + "PatchUser,"
 
-	err := s.UserExist(ctx, userId)
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockVersion(ctx, tx, audit.EntityUser, userId, version)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	var firstName, lastName *string
+	if slices.Contains(fields, "first_name") {
+		firstName = &patch.FirstName
+	}
+	if slices.Contains(fields, "last_name") {
+		lastName = &patch.LastName
+	}
+
+	nameTaken, err := userNameTaken(ctx, tx, userId, firstName, lastName)
 	if err != nil {
 		return er.Wrap(funcName, err)
 	}
 
-	err = s.nameAndLastNameExist(ctx, updateUser)
+	if nameTaken {
+		message := fmt.Sprintf("Another user with the name of the patched user with id %d already exists", userId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityUser, userId)
 	if err != nil {
 		return er.Wrap(funcName, err)
 	}
 
-	query := `UPDATE users SET first_name = $1, last_name = $2 WHERE id = $3`
-	_, err = s.dbService.GetPool().Exec(ctx, query, updateUser.FirstName, updateUser.LastName, userId)
+	assignments, args := patchAssignments(userPatchColumns, patch, fields)
+	query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d`, strings.Join(assignments, ", "), len(args)+1)
+	_, err = tx.Exec(ctx, query, append(args, userId)...)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
 			return er.Wrap(funcName, err)
 		}
-		message := fmt.Sprintf("Error updating user")
+		message := fmt.Sprintf("Error patching user")
 		return er.New(funcName, message, err)
 	}
 
+	err = recordAuditEvent(ctx, tx, audit.ActionUpdate, audit.EntityUser, userId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing user patch: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
 	return nil
 }
 
-// DeleteUser deletes a user from the database by their ID, if the user exists
+// DeleteUser soft deletes a user by their ID, if the user exists. The user is kept, with their loans and history,
+// until restored. Users who have not returned all books or still hold books cannot be deleted.
+// The user row is locked, so the user cannot borrow a book while being deleted.
 func (s *UserServiceStruct) DeleteUser(ctx context.Context, userId int) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	funcName := userService + "DeleteUser,"
 
-	err := s.UserExist(ctx, userId)
+	tx, err := s.dbService.GetPool().Begin(ctx)
 	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
 		return er.Wrap(funcName, err)
 	}
+	defer tx.Rollback(ctx)
 
-	query := `DELETE FROM users WHERE id = $1`
-	_, err = s.dbService.GetPool().Exec(ctx, query, userId)
+	var openLoans, activeHolds bool
+	query := `SELECT EXISTS (SELECT 1 FROM book_borrows WHERE user_id = users.id AND return_date IS NULL),
+		EXISTS (SELECT 1 FROM holds WHERE user_id = users.id AND status IN ('waiting', 'ready'))
+		FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, userId).Scan(&openLoans, &activeHolds)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("User with id %d does not exist", userId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting user: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if openLoans {
+		message := fmt.Sprintf("User with id %d has not returned all borrowed books", userId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+	if activeHolds {
+		message := fmt.Sprintf("User with id %d has active holds, which must be cancelled first", userId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityUser, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query = `UPDATE users SET deleted_at = NOW(), version = version + 1 WHERE id = $1`
+	_, err = tx.Exec(ctx, query, userId)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
 			return er.Wrap(funcName, err)
@@ -164,16 +373,152 @@ func (s *UserServiceStruct) DeleteUser(ctx context.Context, userId int) error {
 		return er.New(funcName, message, err)
 	}
 
+	err = recordAuditEvent(ctx, tx, audit.ActionDelete, audit.EntityUser, userId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing user deletion: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
 	return nil
 }
 
-// UserExist checks if a user with the given ID exists in the database
-// This ensures that the user to be updated or deleted exists
+// RestoreUser restores a soft deleted user, unless another user with the same name has been created meanwhile
+func (s *UserServiceStruct) RestoreUser(ctx context.Context, userId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := userService + "RestoreUser,"
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var nameTaken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users other WHERE other.first_name = users.first_name AND other.last_name = users.last_name
+			AND other.deleted_at IS NULL)
+		FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, userId).Scan(&nameTaken)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("Deleted user with id %d does not exist", userId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting deleted user: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	if nameTaken {
+		message := fmt.Sprintf("Another user with the name of user with id %d already exists", userId)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityUser, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query = `UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = $1`
+	_, err = tx.Exec(ctx, query, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		message := fmt.Sprintf("Error restoring user")
+		return er.New(funcName, message, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionRestore, audit.EntityUser, userId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error committing user restore: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// UpdateMembership changes the membership tier of a user and whether their account is blocked from borrowing
+func (s *UserServiceStruct) UpdateMembership(ctx context.Context, membership user.Membership, userId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := userService + "UpdateMembership,"
+
+	err := s.UserExist(ctx, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query := `UPDATE users SET tier = $1, blocked = $2, version = version + 1 WHERE id = $3`
+	err = execAudited(ctx, s.dbService.GetPool(), audit.ActionMembership, audit.EntityUser, userId, query,
+		membership.Tier, membership.Blocked, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		message := fmt.Sprintf("Error updating user membership")
+		return er.New(funcName, message, err)
+	}
+
+	return nil
+}
+
+// UpdateRole changes the role of a user
+func (s *UserServiceStruct) UpdateRole(ctx context.Context, role string, userId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	funcName := userService + "UpdateRole,"
+
+	err := s.UserExist(ctx, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query := `UPDATE users SET role = $1, version = version + 1 WHERE id = $2`
+	err = execAudited(ctx, s.dbService.GetPool(), audit.ActionRole, audit.EntityUser, userId, query, role, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		message := fmt.Sprintf("Error updating user role")
+		return er.New(funcName, message, err)
+	}
+
+	return nil
+}
+
+// UserExist checks if a user with the given ID exists in the database and is not deleted
+// This ensures that the user to be updated exists
 func (s *UserServiceStruct) UserExist(ctx context.Context, userId int) error {
 
 	funcName := userService + "userExist,"
 	var userExists bool
-	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`
 	err := s.dbService.GetPool().QueryRow(ctx, query, userId).Scan(&userExists)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
@@ -185,19 +530,123 @@ func (s *UserServiceStruct) UserExist(ctx context.Context, userId int) error {
 
 	if !userExists {
 		message := fmt.Sprintf("User with id %d does not exist", userId)
-		return er.New(funcName, message, nil)
+		return er.NewKind(funcName, er.NotFound, message, nil)
 	}
 
 	return nil
 }
 
+// ImportUsers adds the users of an import in a single transaction, copying new users in batches.
+// Users are matched to existing users by their email in any letter case, and users without an email by their
+// first and last name. In insert mode a match is reported as a failed row, and in upsert mode the names of the
+// matched user are replaced, and so are their tier, blocked state, role and password if the row has them.
+// Rows repeating an email or name of an earlier row fail, as do rows matching a deleted user, and rows whose
+// name belongs to another user. Passwords are hashed before the transaction starts, except for a dry run,
+// which is rolled back.
+func (s *UserServiceStruct) ImportUsers(ctx context.Context, rows []bulk.Row[user.Import], options bulk.Options) (*bulk.Report, error) {
+	funcName := userService + "ImportUsers,"
+
+	report := &bulk.Report{Mode: options.Mode, DryRun: options.DryRun, Errors: []bulk.RowError{}}
+
+	for i := range rows {
+		if rows[i].Value.Password == "" || options.DryRun {
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(rows[i].Value.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			return nil, er.Wrap(funcName, err)
+		}
+		rows[i].Value.PasswordHash = string(hash)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, importTimeout)
+	defer cancel()
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error starting transaction: %v", err)
+		return nil, er.Wrap(funcName, err)
+	}
+	defer tx.Rollback(ctx)
+
+	byEmail, byName, err := lockImportedUsers(ctx, tx, rows)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	var inserts []bulk.Row[user.Import]
+	seenEmails := map[string]int{}
+	seenNames := map[[2]string]int{}
+	for _, row := range rows {
+		u := row.Value
+		email := strings.ToLower(u.Email)
+		name := [2]string{u.FirstName, u.LastName}
+
+		if line, ok := seenEmails[email]; ok && email != "" {
+			report.Fail(row.Line, fmt.Sprintf("User with email %s is already on line %d", u.Email, line), nil)
+			continue
+		}
+		if line, ok := seenNames[name]; ok {
+			report.Fail(row.Line, fmt.Sprintf("User %s %s is already on line %d", u.FirstName, u.LastName, line), nil)
+			continue
+		}
+		if email != "" {
+			seenEmails[email] = row.Line
+		}
+		seenNames[name] = row.Line
+
+		match, matched := byEmail[email]
+		if email == "" {
+			match, matched = byName[name]
+		}
+		named, nameTaken := byName[name]
+
+		switch {
+		case matched && options.Mode != bulk.ModeUpsert && email != "":
+			report.Fail(row.Line, fmt.Sprintf("User with email %s already exists", u.Email), nil)
+		case matched && match.DeletedAt != nil:
+			report.Fail(row.Line, fmt.Sprintf("User with email %s is deleted, restore them first", u.Email), nil)
+		case nameTaken && (!matched || options.Mode != bulk.ModeUpsert || named.ID != match.ID):
+			message := fmt.Sprintf("User with this name: %s, and last name: %s, already exists", u.FirstName, u.LastName)
+			report.Fail(row.Line, message, nil)
+		case matched:
+			err = replaceUser(ctx, tx, match.ID, u)
+			if err != nil {
+				return nil, er.Wrap(funcName, err)
+			}
+			report.Updated++
+		default:
+			inserts = append(inserts, row)
+		}
+	}
+
+	for _, batch := range batches(inserts) {
+		err = insertUsers(ctx, tx, batch)
+		if err != nil {
+			return nil, er.Wrap(funcName, err)
+		}
+		report.Created += len(batch)
+	}
+
+	err = finishImport(ctx, tx, options.DryRun)
+	if err != nil {
+		return nil, er.Wrap(funcName, err)
+	}
+
+	return report, nil
+}
+
 // nameAndLastNameExist checks if a user with the same first and last name already exists in the database
-// This ensure that there are no duplicate users
+// This ensure that there are no duplicate users among the users that are not deleted
 func (s *UserServiceStruct) nameAndLastNameExist(ctx context.Context, user user.User) error {
 
 	funcName := userService + "NameAndLastNameExist,"
 	var exists bool
-	query := `SELECT EXISTS (SELECT 1 FROM users WHERE first_name = $1 AND last_name = $2)`
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE first_name = $1 AND last_name = $2 AND deleted_at IS NULL)`
 	err := s.dbService.GetPool().QueryRow(ctx, query, user.FirstName, user.LastName).Scan(&exists)
 	if err != nil {
 		if er.HandleDeadlineExceededError(userService, err) != nil {
@@ -209,8 +658,175 @@ func (s *UserServiceStruct) nameAndLastNameExist(ctx context.Context, user user.
 
 	if exists {
 		message := fmt.Sprintf("User with this name: %s, and last name: %s, already exists", user.FirstName, user.LastName)
-		return er.New(funcName, message, nil)
+		return er.NewKind(funcName, er.Conflict, message, nil)
+	}
+
+	return nil
+}
+
+// emailExists checks if a user with the given email, in any letter case, already exists in the database
+func (s *UserServiceStruct) emailExists(ctx context.Context, email string) error {
+
+	funcName := userService + "emailExists,"
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))`
+	err := s.dbService.GetPool().QueryRow(ctx, query, email).Scan(&exists)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		message := fmt.Sprintf("Error checking if email exists")
+		return er.New(funcName, message, err)
+	}
+
+	if exists {
+		message := fmt.Sprintf("User with email %s already exists", email)
+		return er.NewKind(funcName, er.Conflict, message, nil)
 	}
 
 	return nil
 }
+
+// userNameTaken checks in the transaction whether a user other than the one with the given ID, and not deleted,
+// has the name the user would have with the given first and last name. A nil name keeps the current one.
+func userNameTaken(ctx context.Context, tx pgx.Tx, userId int, firstName *string, lastName *string) (bool, error) {
+	funcName := userService + "userNameTaken,"
+
+	var nameTaken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users other WHERE other.id <> users.id AND other.deleted_at IS NULL
+			AND other.first_name = COALESCE($2, users.first_name) AND other.last_name = COALESCE($3, users.last_name))
+		FROM users WHERE id = $1`
+	err := tx.QueryRow(ctx, query, userId, firstName, lastName).Scan(&nameTaken)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return false, er.Wrap(funcName, err)
+		}
+		log.Printf("Error checking user name: %v", err)
+		return false, er.Wrap(funcName, err)
+	}
+
+	return nameTaken, nil
+}
+
+// lockImportedUsers locks the users that have an email of the rows, deleted or not, and the users that are not
+// deleted and have a name of the rows, and returns them by their email in lower case and by their name
+func lockImportedUsers(ctx context.Context, tx pgx.Tx, rows []bulk.Row[user.Import]) (map[string]user.User, map[[2]string]user.User, error) {
+	funcName := userService + "lockImportedUsers,"
+
+	var emails, firstNames, lastNames []string
+	for _, row := range rows {
+		if row.Value.Email != "" {
+			emails = append(emails, strings.ToLower(row.Value.Email))
+		}
+		firstNames = append(firstNames, row.Value.FirstName)
+		lastNames = append(lastNames, row.Value.LastName)
+	}
+
+	byEmail := map[string]user.User{}
+	byName := map[[2]string]user.User{}
+
+	query := `SELECT id, first_name, last_name, LOWER(COALESCE(email, '')), deleted_at FROM users
+		WHERE LOWER(email) = ANY($1)
+			OR (deleted_at IS NULL AND (first_name, last_name) IN (SELECT * FROM unnest($2::text[], $3::text[])))
+		FOR UPDATE`
+	result, err := tx.Query(ctx, query, emails, firstNames, lastNames)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return nil, nil, er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting users by email and name: %v", err)
+		return nil, nil, er.Wrap(funcName, err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var u user.User
+		err := result.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.DeletedAt)
+		if err != nil {
+			log.Printf("Error scanning users: %v", err)
+			return nil, nil, er.Wrap(funcName, err)
+		}
+		if u.Email != "" && slices.Contains(emails, u.Email) {
+			byEmail[u.Email] = u
+		}
+		if u.DeletedAt == nil {
+			byName[[2]string{u.FirstName, u.LastName}] = u
+		}
+	}
+
+	return byEmail, byName, nil
+}
+
+// replaceUser replaces the names, tier, blocked state and role of a user in the transaction, keeping the tier,
+// blocked state and role if they are not given, and the password if it has no new hash. The change is recorded in the audit log.
+func replaceUser(ctx context.Context, tx pgx.Tx, userId int, updatedUser user.Import) error {
+	funcName := userService + "replaceUser,"
+
+	before, err := auditSnapshot(ctx, tx, audit.EntityUser, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	query := `UPDATE users SET first_name = $1, last_name = $2, tier = COALESCE(NULLIF($3, ''), tier), blocked = COALESCE($4, blocked),
+		role = COALESCE(NULLIF($5, ''), role), password_hash = COALESCE(NULLIF($6, ''), password_hash), version = version + 1
+		WHERE id = $7`
+	_, err = tx.Exec(ctx, query, updatedUser.FirstName, updatedUser.LastName, updatedUser.Tier, updatedUser.Blocked,
+		updatedUser.Role, updatedUser.PasswordHash, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(userService, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error updating user: %v", err)
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditEvent(ctx, tx, audit.ActionUpdate, audit.EntityUser, userId, before)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// insertUsers copies a batch of new users into the database with reserved ids, with the standard tier and
+// the patron role unless given, and not blocked unless the row says so
+func insertUsers(ctx context.Context, tx pgx.Tx, rows []bulk.Row[user.Import]) error {
+	funcName := userService + "insertUsers,"
+
+	ids, err := reserveIds(ctx, tx, "users", len(rows))
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	userRows := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		u := row.Value
+		if u.Tier == "" {
+			u.Tier = user.TierStandard
+		}
+		if u.Role == "" {
+			u.Role = user.RolePatron
+		}
+		blocked := u.Blocked != nil && *u.Blocked
+		userRows[i] = []interface{}{ids[i], u.FirstName, u.LastName, u.Tier, blocked, u.Role, nullIfZero(u.Email),
+			nullIfZero(u.PasswordHash)}
+	}
+
+	columns := []string{"id", "first_name", "last_name", "tier", "blocked", "role", "email", "password_hash"}
+	err = copyRows(ctx, tx, "users", columns, userRows)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = recordAuditCreations(ctx, tx, audit.EntityUser, ids)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return nil
+}
+
+// scanUser scans a row selected with userColumns into the given User
+func scanUser(row pgx.Row, user *user.User) error {
+	return row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Tier, &user.Blocked, &user.Role, &user.Email, &user.DeletedAt, &user.Version)
+}
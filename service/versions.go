@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	er "kokal5296/errors"
+	"kokal5296/models/audit"
+	"log"
+)
+
+const versions = "versions - "
+
+// versionedEntity describes the table of an entity type whose rows carry a version and the name used in messages
+type versionedEntity struct {
+	table string
+	name  string
+}
+
+// versionedEntities holds the entity types whose changes are checked against the version they were read at
+var versionedEntities = map[string]versionedEntity{
+	audit.EntityUser: {table: "users", name: "User"},
+	audit.EntityBook: {table: "books", name: "Book"},
+}
+
+// lockVersion locks the row of an entity that is not deleted and checks that it is still at the given version,
+// so that a change made to an older version of the entity is refused. A version of 0 matches any version.
+func lockVersion(ctx context.Context, tx pgx.Tx, entityType string, entityId int, version int) error {
+	funcName := versions + "lockVersion"
+
+	entity := versionedEntities[entityType]
+
+	var current int
+	query := `SELECT version FROM ` + entity.table + ` WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err := tx.QueryRow(ctx, query, entityId).Scan(&current)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			message := fmt.Sprintf("%s with id %d does not exist", entity.name, entityId)
+			return er.NewKind(funcName, er.NotFound, message, nil)
+		}
+		if er.HandleDeadlineExceededError(versions, err) != nil {
+			return er.Wrap(funcName, err)
+		}
+		log.Printf("Error getting version of %s: %v", entityType, err)
+		return er.Wrap(funcName, err)
+	}
+
+	if version != 0 && version != current {
+		message := fmt.Sprintf("%s with id %d has changed, it is at version %d", entity.name, entityId, current)
+		return er.NewKind(funcName, er.PreconditionFailed, message, nil)
+	}
+
+	return nil
+}
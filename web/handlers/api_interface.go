@@ -10,7 +10,12 @@ type UserApi interface {
 	GetUser(c *fiber.Ctx) error
 	GetAllUsers(c *fiber.Ctx) error
 	UpdateUser(c *fiber.Ctx) error
+	PatchUser(c *fiber.Ctx) error
 	DeleteUser(c *fiber.Ctx) error
+	RestoreUser(c *fiber.Ctx) error
+	UpdateMembership(c *fiber.Ctx) error
+	UpdateRole(c *fiber.Ctx) error
+	ImportUsers(c *fiber.Ctx) error
 }
 
 // BookApi defines the interface for handling book related HTTP requests
@@ -18,14 +23,65 @@ type BookApi interface {
 	CreateBook(c *fiber.Ctx) error
 	GetBook(c *fiber.Ctx) error
 	GetAllBooks(c *fiber.Ctx) error
+	SearchBooks(c *fiber.Ctx) error
 	UpdateBook(c *fiber.Ctx) error
+	PatchBook(c *fiber.Ctx) error
 	DeleteBook(c *fiber.Ctx) error
+	RestoreBook(c *fiber.Ctx) error
+	ImportBooks(c *fiber.Ctx) error
 }
 
 // BookBorrowApi defines the interface for handling book borrow related HTTP requests
 type BookBorrowApi interface {
 	GetAvailableBooks(c *fiber.Ctx) error
 	AllBorrowedBooks(c *fiber.Ctx) error
+	UserLoans(c *fiber.Ctx) error
+	BookLoans(c *fiber.Ctx) error
 	BorrowBook(c *fiber.Ctx) error
 	ReturnBook(c *fiber.Ctx) error
+	CreateLoan(c *fiber.Ctx) error
+	GetLoan(c *fiber.Ctx) error
+	ReturnLoan(c *fiber.Ctx) error
+	RenewBook(c *fiber.Ctx) error
+	OverdueBooks(c *fiber.Ctx) error
+}
+
+// HoldApi defines the interface for handling hold related HTTP requests
+type HoldApi interface {
+	PlaceHold(c *fiber.Ctx) error
+	GetBookHolds(c *fiber.Ctx) error
+	CancelHold(c *fiber.Ctx) error
+}
+
+// FineApi defines the interface for handling fine and account related HTTP requests
+type FineApi interface {
+	GetAccount(c *fiber.Ctx) error
+	RecordPayment(c *fiber.Ctx) error
+	WaiveFine(c *fiber.Ctx) error
+}
+
+// CopyApi defines the interface for handling book copy related HTTP requests
+type CopyApi interface {
+	AddCopy(c *fiber.Ctx) error
+	GetBookCopies(c *fiber.Ctx) error
+	UpdateCopy(c *fiber.Ctx) error
+	AuditShelf(c *fiber.Ctx) error
+}
+
+// AuthApi defines the interface for handling login and token related HTTP requests
+type AuthApi interface {
+	Login(c *fiber.Ctx) error
+	Refresh(c *fiber.Ctx) error
+}
+
+// ApiKeyApi defines the interface for handling API key related HTTP requests
+type ApiKeyApi interface {
+	CreateApiKey(c *fiber.Ctx) error
+	GetApiKeys(c *fiber.Ctx) error
+	RevokeApiKey(c *fiber.Ctx) error
+}
+
+// AuditApi defines the interface for handling audit log related HTTP requests
+type AuditApi interface {
+	GetAuditEvents(c *fiber.Ctx) error
 }
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/api_key"
+	"kokal5296/service"
+	validate "kokal5296/web/validation"
+	"log"
+	"strconv"
+)
+
+type ApiKeyApiStruct struct {
+	apiKeyService service.ApiKeyService
+}
+
+// NewApiKeyApiService creates a new instance of ApiKeyApiStruct, which implements the ApiKeyApi interface
+func NewApiKeyApiService(apiKeyService service.ApiKeyService) ApiKeyApi {
+	return &ApiKeyApiStruct{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateApiKey handles the request to issue a new API key. The key is only returned in this response.
+func (s *ApiKeyApiStruct) CreateApiKey(c *fiber.Ctx) error {
+
+	log.Println("Requesting to create API key")
+	var newKey api_key.ApiKey
+
+	funcName := handler + "CreateApiKey"
+
+	err := json.Unmarshal(c.Body(), &newKey)
+	if err != nil {
+		log.Printf("Error while unmarshalling API key: %v", err)
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateApiKey(newKey)
+	if validateErr != nil {
+		log.Printf("Error while validating API key: %v", validateErr)
+		return badRequest(c, validateErr)
+	}
+
+	var createdBy *int
+	if authenticated := AuthenticatedUser(c); authenticated != nil {
+		createdBy = &authenticated.ID
+	}
+
+	created, err := s.apiKeyService.CreateApiKey(c.Context(), newKey, createdBy)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// GetApiKeys handles the request to list all API keys
+func (s *ApiKeyApiStruct) GetApiKeys(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get API keys")
+	funcName := handler + "GetApiKeys"
+
+	keys, err := s.apiKeyService.GetApiKeys(c.Context())
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// RevokeApiKey handles the request to revoke an API key
+func (s *ApiKeyApiStruct) RevokeApiKey(c *fiber.Ctx) error {
+
+	log.Println("Requesting to revoke API key")
+	funcName := handler + "RevokeApiKey"
+	id := c.Params("id")
+
+	keyId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = s.apiKeyService.RevokeApiKey(c.Context(), keyId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).SendString("API key was successfully revoked")
+}
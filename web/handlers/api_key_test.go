@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/models/api_key"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestApiKeys tests the scenarios for issuing, listing, using and revoking API keys
+func TestApiKeys(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	apiKeyService := service.NewApiKeyService(dbService)
+	apiKeyApi := NewApiKeyApiService(apiKeyService)
+
+	admin := &user.User{FirstName: "Ana", LastName: "Admin", Role: user.RoleAdmin}
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name, role) VALUES ($1, $2, $3) RETURNING id",
+		admin.FirstName, admin.LastName, admin.Role).Scan(&admin.ID)
+	assert.NoError(t, err)
+
+	app := newTestApp(admin)
+	app.Post("/api_key", apiKeyApi.CreateApiKey)
+	app.Get("/api_keys", apiKeyApi.GetApiKeys)
+	app.Delete("/api_key/:id", apiKeyApi.RevokeApiKey)
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		input    api_key.ApiKey
+		expected int
+	}{
+		{
+			name:     "Create an API key without a name",
+			input:    api_key.ApiKey{Scopes: []string{api_key.ScopeCatalogRead}},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Create an API key without scopes",
+			input:    api_key.ApiKey{Name: "Catalog sync"},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Create an API key with an unknown scope",
+			input:    api_key.ApiKey{Name: "Catalog sync", Scopes: []string{"catalog:delete"}},
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendRequest("POST", "/api_key", tt.input)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+
+	var created api_key.ApiKey
+
+	t.Run("Create an API key", func(t *testing.T) {
+		resp := sendRequest("POST", "/api_key", api_key.ApiKey{Name: "Catalog sync", Scopes: []string{api_key.ScopeCatalogRead}})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		err := json.NewDecoder(resp.Body).Decode(&created)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(created.Key, created.Prefix+"_"))
+		assert.True(t, strings.HasPrefix(created.Prefix, "bbk_"))
+		if assert.NotNil(t, created.CreatedBy) {
+			assert.Equal(t, admin.ID, *created.CreatedBy)
+		}
+
+		var keyHash string
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT key_hash FROM api_keys WHERE id = $1", created.ID).Scan(&keyHash)
+		assert.NoError(t, err)
+		assert.NotContains(t, keyHash, created.Key)
+	})
+
+	t.Run("Listing API keys does not return the key", func(t *testing.T) {
+		resp := sendRequest("GET", "/api_keys", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var keys []api_key.ApiKey
+		err := json.NewDecoder(resp.Body).Decode(&keys)
+		assert.NoError(t, err)
+		if assert.Len(t, keys, 1) {
+			assert.Equal(t, created.Prefix, keys[0].Prefix)
+			assert.Empty(t, keys[0].Key)
+		}
+	})
+
+	t.Run("API key is authorized by scope", func(t *testing.T) {
+		authenticated, err := apiKeyService.AuthenticateApiKey(context.Background(), created.Key)
+		assert.NoError(t, err)
+		assert.NotNil(t, authenticated.LastUsedAt)
+
+		scoped := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		scoped.Use(func(c *fiber.Ctx) error {
+			SetAuthenticatedApiKey(c, authenticated)
+			return c.Next()
+		})
+		ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+		scoped.Get("/books", Authorize(api_key.ScopeCatalogRead), ok)
+		scoped.Post("/book", Authorize(api_key.ScopeCatalogWrite, user.RoleLibrarian, user.RoleAdmin), ok)
+		scoped.Put("/user/:id/role", Authorize("", user.RoleAdmin), ok)
+
+		for _, request := range []struct {
+			method   string
+			path     string
+			expected int
+		}{
+			{method: "GET", path: "/books", expected: http.StatusOK},
+			{method: "POST", path: "/book", expected: http.StatusForbidden},
+			{method: "PUT", path: "/user/1/role", expected: http.StatusForbidden},
+		} {
+			resp, err := scoped.Test(httptest.NewRequest(request.method, request.path, nil), -1)
+			assert.NoError(t, err)
+			assert.Equal(t, request.expected, resp.StatusCode, request.path)
+		}
+	})
+
+	t.Run("Revoked API key no longer authenticates", func(t *testing.T) {
+		resp := sendRequest("DELETE", fmt.Sprintf("/api_key/%d", created.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = sendRequest("DELETE", fmt.Sprintf("/api_key/%d", created.ID), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		_, err := apiKeyService.AuthenticateApiKey(context.Background(), created.Key)
+		assert.Error(t, err)
+
+		_, err = apiKeyService.AuthenticateApiKey(context.Background(), "bbk_unknown")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/service"
+	"log"
+)
+
+type AuditApiStruct struct {
+	auditService service.AuditService
+}
+
+// NewAuditApiService creates a new instance of AuditApiStruct, which implements the AuditApi interface
+func NewAuditApiService(auditService service.AuditService) AuditApi {
+	return &AuditApiStruct{
+		auditService: auditService,
+	}
+}
+
+// GetAuditEvents handles the request to get a page of the recorded changes
+func (s *AuditApiStruct) GetAuditEvents(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get audit events")
+	funcName := handler + "GetAuditEvents"
+
+	params, err := parsePageParams(c, service.AuditListSpec)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	events, err := s.auditService.GetAuditEvents(c.Context(), params)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(events)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/audit"
+	"kokal5296/models/book"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/page"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAuditEvents tests that changes of users, books and loans are recorded with their actor and can be filtered
+func TestAuditEvents(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	auditService := service.NewAuditService(dbService)
+	userApi := NewUserApiService(userService)
+	bookApi := NewBookApiService(bookService)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+	auditApi := NewAuditApiService(auditService)
+
+	librarian := &user.User{ID: 42, FirstName: "Maja", LastName: "Librarian", Role: user.RoleLibrarian}
+
+	app := newTestApp(librarian)
+	app.Post("/user", userApi.CreateUser)
+	app.Put("/book/:id", bookApi.UpdateBook)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
+	app.Get("/audit", auditApi.GetAuditEvents)
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	getEvents := func(t *testing.T, query string) []audit.Event {
+		resp := sendRequest("GET", "/audit?"+query, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var events page.Page[audit.Event]
+		err := json.NewDecoder(resp.Body).Decode(&events)
+		assert.NoError(t, err)
+		return events.Items
+	}
+
+	bookId := insertBook(t, dbService, "The Hobbit", 2)
+
+	resp := sendRequest("POST", "/user", user.User{FirstName: "Tine", LastName: "Kokalj"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Creating a user is recorded", func(t *testing.T) {
+		events := getEvents(t, "entity_type=user")
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, audit.ActionCreate, events[0].Action)
+			assert.Equal(t, audit.ActorUser, events[0].ActorType)
+			if assert.NotNil(t, events[0].ActorID) {
+				assert.Equal(t, librarian.ID, *events[0].ActorID)
+			}
+			assert.JSONEq(t, "null", string(events[0].Before))
+			assert.Contains(t, string(events[0].After), `"first_name":"Tine"`)
+		}
+	})
+
+	t.Run("Changing the quantity of a book is recorded before and after", func(t *testing.T) {
+		resp := sendRequest("PUT", fmt.Sprintf("/book/%d", bookId), book.Book{Title: "The Hobbit", Quantity: 5})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		events := getEvents(t, fmt.Sprintf("entity_type=book&entity_id=%d", bookId))
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, audit.ActionUpdate, events[0].Action)
+			assert.Contains(t, string(events[0].Before), `"quantity":2`)
+			assert.Contains(t, string(events[0].After), `"quantity":5`)
+		}
+	})
+
+	t.Run("Borrowing and returning a book is recorded", func(t *testing.T) {
+		var userId int
+		for _, event := range getEvents(t, "entity_type=user") {
+			userId = event.EntityID
+		}
+
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		events := getEvents(t, "entity_type=book_borrow")
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, audit.ActionReturn, events[0].Action)
+			assert.Contains(t, string(events[0].Before), `"return_date":null`)
+			assert.Equal(t, audit.ActionBorrow, events[1].Action)
+		}
+
+		assert.Len(t, getEvents(t, "action=borrow"), 1)
+	})
+
+	t.Run("Failed changes are not recorded", func(t *testing.T) {
+		resp := sendRequest("PUT", "/book/1000", book.Book{Title: "Dune", Quantity: 1})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		assert.Len(t, getEvents(t, "entity_id=1000"), 0)
+	})
+
+	t.Run("Filter by time", func(t *testing.T) {
+		assert.Len(t, getEvents(t, "from=2000-01-01T00:00:00Z"), 4)
+		assert.Len(t, getEvents(t, "to=2000-01-01T00:00:00Z"), 0)
+
+		resp := sendRequest("GET", "/audit?from=yesterday", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/audit"
+	"kokal5296/models/auth"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	validate "kokal5296/web/validation"
+	"log"
+)
+
+// UserLocal is the key of the authenticated user in the locals of a request
+const UserLocal = "user"
+
+type AuthApiStruct struct {
+	authService service.AuthService
+}
+
+// NewAuthApiService creates a new instance of AuthApiStruct, which implements the AuthApi interface
+func NewAuthApiService(authService service.AuthService) AuthApi {
+	return &AuthApiStruct{
+		authService: authService,
+	}
+}
+
+// Login handles the request to log in with an email and password
+func (s *AuthApiStruct) Login(c *fiber.Ctx) error {
+
+	log.Println("Requesting to log in")
+	funcName := handler + "Login"
+
+	var credentials auth.Credentials
+	err := json.Unmarshal(c.Body(), &credentials)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateCredentials(credentials)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+
+	tokens, err := s.authService.Login(c.Context(), credentials)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// Refresh handles the request to exchange a refresh token for a new pair of tokens
+func (s *AuthApiStruct) Refresh(c *fiber.Ctx) error {
+
+	log.Println("Requesting to refresh tokens")
+	funcName := handler + "Refresh"
+
+	var request auth.RefreshRequest
+	err := json.Unmarshal(c.Body(), &request)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateRefreshRequest(request)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+
+	tokens, err := s.authService.Refresh(c.Context(), request.RefreshToken)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// AuthenticatedUser returns the user the request was authenticated as, or nil if the route does not require authentication
+func AuthenticatedUser(c *fiber.Ctx) *user.User {
+	authenticated, _ := c.Locals(UserLocal).(*user.User)
+	return authenticated
+}
+
+// SetAuthenticatedUser stores the user the request was authenticated as, who is also recorded as the actor of its changes
+func SetAuthenticatedUser(c *fiber.Ctx, authenticated *user.User) {
+	c.Locals(UserLocal, authenticated)
+	c.Locals(audit.ActorKey, audit.Actor{Type: audit.ActorUser, ID: &authenticated.ID})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/auth"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAuth tests the scenarios for creating a user with a password, logging in and refreshing tokens
+func TestAuth(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	authService := service.NewAuthService(dbService, userService, config.DefaultAuthConfig())
+	userApi := NewUserApiService(userService)
+	authApi := NewAuthApiService(authService)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Post("/user", userApi.CreateUser)
+	app.Post("/auth/login", authApi.Login)
+	app.Post("/auth/refresh", authApi.Refresh)
+
+	sendRequest := func(path string, input interface{}) *http.Response {
+		body, _ := json.Marshal(input)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	decodeTokens := func(t *testing.T, resp *http.Response) auth.TokenPair {
+		var tokens auth.TokenPair
+		err := json.NewDecoder(resp.Body).Decode(&tokens)
+		assert.NoError(t, err)
+		return tokens
+	}
+
+	resp := sendRequest("/user", user.User{FirstName: "Tine", LastName: "Kokalj", Email: "tine@example.com", Password: "correct horse"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Password is stored as a hash", func(t *testing.T) {
+		var passwordHash string
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT password_hash FROM users WHERE email = $1", "tine@example.com").Scan(&passwordHash)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "correct horse", passwordHash)
+		assert.NotEmpty(t, passwordHash)
+	})
+
+	t.Run("Create a user with a duplicate email", func(t *testing.T) {
+		resp := sendRequest("/user", user.User{FirstName: "Žan", LastName: "Kokalj", Email: "TINE@example.com", Password: "another password"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Create a user with an email but no password", func(t *testing.T) {
+		resp := sendRequest("/user", user.User{FirstName: "Žan", LastName: "Kokalj", Email: "zan@example.com"})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	var tokens auth.TokenPair
+
+	t.Run("Log in", func(t *testing.T) {
+		resp := sendRequest("/auth/login", auth.Credentials{Email: "Tine@Example.com", Password: "correct horse"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		tokens = decodeTokens(t, resp)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.Equal(t, "Bearer", tokens.TokenType)
+
+		authenticated, err := authService.Authenticate(context.Background(), tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "tine@example.com", authenticated.Email)
+	})
+
+	t.Run("Log in with a wrong password", func(t *testing.T) {
+		resp := sendRequest("/auth/login", auth.Credentials{Email: "tine@example.com", Password: "wrong password"})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("Log in with an unknown email", func(t *testing.T) {
+		resp := sendRequest("/auth/login", auth.Credentials{Email: "nobody@example.com", Password: "correct horse"})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("Refresh tokens", func(t *testing.T) {
+		resp := sendRequest("/auth/refresh", auth.RefreshRequest{RefreshToken: tokens.RefreshToken})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotEmpty(t, decodeTokens(t, resp).AccessToken)
+	})
+
+	t.Run("Refresh with an access token", func(t *testing.T) {
+		resp := sendRequest("/auth/refresh", auth.RefreshRequest{RefreshToken: tokens.AccessToken})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("Access token of a deleted user", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "DELETE FROM users WHERE email = $1", "tine@example.com")
+		assert.NoError(t, err)
+
+		_, err = authService.Authenticate(context.Background(), tokens.AccessToken)
+		assert.Error(t, err)
+	})
+}
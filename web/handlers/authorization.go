@@ -0,0 +1,176 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/api_key"
+	"kokal5296/models/audit"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/user"
+	"strings"
+)
+
+// ApiKeyLocal is the key of the authenticated API key in the locals of a request
+const ApiKeyLocal = "api_key"
+
+// Authorize returns a middleware that lets through users with one of the given roles, or with any role
+// if none are given, and API keys that have the given scope. API keys are refused when scope is empty.
+// It must run after the authentication middleware.
+func Authorize(scope string, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		funcName := handler + "Authorize"
+
+		if key := AuthenticatedApiKey(c); key != nil {
+			if scope == "" {
+				return er.NewKind(funcName, er.Forbidden, "API keys are not allowed to make this request", nil)
+			}
+			if !key.HasScope(scope) {
+				message := fmt.Sprintf("API key does not have the scope %s", scope)
+				return er.NewKind(funcName, er.Forbidden, message, nil)
+			}
+			return c.Next()
+		}
+
+		authenticated, err := requireAuthenticatedUser(c)
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+
+		if len(roles) == 0 {
+			return c.Next()
+		}
+		for _, role := range roles {
+			if authenticated.Role == role {
+				return c.Next()
+			}
+		}
+
+		message := fmt.Sprintf("Only users with the role %s are allowed to make this request", strings.Join(roles, " or "))
+		return er.NewKind(funcName, er.Forbidden, message, nil)
+	}
+}
+
+// AuthenticatedApiKey returns the API key the request was authenticated with, or nil if it was made by a user
+func AuthenticatedApiKey(c *fiber.Ctx) *api_key.ApiKey {
+	key, _ := c.Locals(ApiKeyLocal).(*api_key.ApiKey)
+	return key
+}
+
+// SetAuthenticatedApiKey stores the API key the request was authenticated with, which is also recorded as the actor of its changes
+func SetAuthenticatedApiKey(c *fiber.Ctx, key *api_key.ApiKey) {
+	c.Locals(ApiKeyLocal, key)
+	c.Locals(audit.ActorKey, audit.Actor{Type: audit.ActorApiKey, ID: &key.ID})
+}
+
+// requireAuthenticatedUser returns the user the request was authenticated as, refusing unauthenticated requests
+func requireAuthenticatedUser(c *fiber.Ctx) (*user.User, error) {
+	funcName := handler + "requireAuthenticatedUser"
+
+	authenticated := AuthenticatedUser(c)
+	if authenticated == nil {
+		return nil, er.NewKind(funcName, er.Unauthorized, "Request is not authenticated", nil)
+	}
+
+	return authenticated, nil
+}
+
+// actsForAnyUser reports whether the request may act on behalf of any user: it was made by a librarian or admin,
+// or with an API key, whose scopes were checked by Authorize
+func actsForAnyUser(c *fiber.Ctx) (bool, error) {
+	if AuthenticatedApiKey(c) != nil {
+		return true, nil
+	}
+
+	authenticated, err := requireAuthenticatedUser(c)
+	if err != nil {
+		return false, err
+	}
+
+	return authenticated.IsStaff(), nil
+}
+
+// authorizeUser allows librarians, admins and API keys to act for any user, and patrons only for themselves
+func authorizeUser(c *fiber.Ctx, userId int) error {
+	funcName := handler + "authorizeUser"
+
+	anyUser, err := actsForAnyUser(c)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	if authenticated := AuthenticatedUser(c); !anyUser && authenticated.ID != userId {
+		message := fmt.Sprintf("User with id %d is not allowed to act for user with id %d", authenticated.ID, userId)
+		return er.NewKind(funcName, er.Forbidden, message, nil)
+	}
+
+	return nil
+}
+
+// authorizeLoan allows librarians, admins and API keys to see any loan, and patrons only their own.
+// Loans of other users are reported as not existing, so that patrons cannot find out which loan ids exist.
+func authorizeLoan(c *fiber.Ctx, loan *book_borrow.BookBorrow) error {
+	funcName := handler + "authorizeLoan"
+
+	anyUser, err := actsForAnyUser(c)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	if !anyUser && AuthenticatedUser(c).ID != loan.UserID {
+		message := fmt.Sprintf("Borrow with id %d does not exist", loan.ID)
+		return er.NewKind(funcName, er.NotFound, message, nil)
+	}
+
+	return nil
+}
+
+// authorizeIncludeDeleted allows only admins to request soft deleted users and books
+func authorizeIncludeDeleted(c *fiber.Ctx, includeDeleted bool) error {
+	funcName := handler + "authorizeIncludeDeleted"
+
+	if !includeDeleted {
+		return nil
+	}
+
+	authenticated := AuthenticatedUser(c)
+	if authenticated == nil || authenticated.Role != user.RoleAdmin {
+		return er.NewKind(funcName, er.Forbidden, "Only admins can request deleted users and books", nil)
+	}
+
+	return nil
+}
+
+// authorizeRole allows only admins to give users a role other than patron
+func authorizeRole(c *fiber.Ctx, role string) error {
+	funcName := handler + "authorizeRole"
+
+	if role == "" || role == user.RolePatron {
+		return nil
+	}
+
+	authenticated := AuthenticatedUser(c)
+	if authenticated == nil || authenticated.Role != user.RoleAdmin {
+		message := fmt.Sprintf("Only admins can give users the role %s", role)
+		return er.NewKind(funcName, er.Forbidden, message, nil)
+	}
+
+	return nil
+}
+
+// authorizeMembership allows only librarians and admins to give new users a tier other than standard or to block them,
+// so that users registering themselves cannot take the borrowing limits of another tier
+func authorizeMembership(c *fiber.Ctx, tier string, blocked bool) error {
+	funcName := handler + "authorizeMembership"
+
+	if (tier == "" || tier == user.TierStandard) && !blocked {
+		return nil
+	}
+
+	authenticated := AuthenticatedUser(c)
+	if authenticated == nil || !authenticated.IsStaff() {
+		return er.NewKind(funcName, er.Forbidden, "Only librarians and admins can set the tier and blocked state of users", nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/api_key"
+	"kokal5296/models/book"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/hold"
+	"kokal5296/models/page"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAdmin is the user the requests of handler tests are authenticated as, unless a test chooses another user
+var testAdmin = &user.User{ID: 0, FirstName: "Test", LastName: "Admin", Role: user.RoleAdmin}
+
+// newTestApp creates an app with the error handler of the server, where every request is authenticated as the given user
+func newTestApp(authenticated *user.User) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		if authenticated != nil {
+			SetAuthenticatedUser(c, authenticated)
+		}
+		return c.Next()
+	})
+	return app
+}
+
+// TestAuthorization tests that patrons can only act for themselves, that librarians can act for anyone,
+// and that requests restricted to other roles are denied with 403
+func TestAuthorization(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	holdService := service.NewHoldService(dbService, userService, config.DefaultLoanConfig())
+	fineService := service.NewFineService(dbService, userService)
+	userApi := NewUserApiService(userService)
+	bookApi := NewBookApiService(bookService)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+	holdApi := NewHoldApiService(holdService)
+	fineApi := NewFineApiService(fineService)
+
+	users := map[string]*user.User{}
+	for _, u := range []user.User{
+		{FirstName: "Tine", LastName: "Patron", Role: user.RolePatron},
+		{FirstName: "Žan", LastName: "Patron", Role: user.RolePatron},
+		{FirstName: "Maja", LastName: "Librarian", Role: user.RoleLibrarian},
+		{FirstName: "Ana", LastName: "Admin", Role: user.RoleAdmin},
+	} {
+		created := u
+		err := dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name, role) VALUES ($1, $2, $3) RETURNING id",
+			u.FirstName, u.LastName, u.Role).Scan(&created.ID)
+		assert.NoError(t, err)
+		users[u.FirstName] = &created
+	}
+	patron, otherPatron, librarian, admin := users["Tine"], users["Žan"], users["Maja"], users["Ana"]
+
+	bookId := insertBook(t, dbService, "The Hobbit", 3)
+	unavailableBookId := insertBook(t, dbService, "Dune", 0)
+
+	staff := Authorize(api_key.ScopeCatalogWrite, user.RoleLibrarian, user.RoleAdmin)
+	admins := Authorize("", user.RoleAdmin)
+
+	sendRequest := func(as *user.User, method, path string, input interface{}) *http.Response {
+		app := newTestApp(as)
+		app.Post("/user", userApi.CreateUser)
+		app.Get("/user/:id", userApi.GetUser)
+		app.Delete("/user/:id", admins, userApi.DeleteUser)
+		app.Post("/book", staff, bookApi.CreateBook)
+		app.Get("/book_borrowed", bookBorrowApi.AllBorrowedBooks)
+		app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+		app.Post("/book_borrow/:id/renew", bookBorrowApi.RenewBook)
+		app.Get("/loans/:id", bookBorrowApi.GetLoan)
+		app.Post("/loans/:id/return", bookBorrowApi.ReturnLoan)
+		app.Post("/hold", holdApi.PlaceHold)
+		app.Delete("/hold/:id", holdApi.CancelHold)
+		app.Get("/user/:id/balance", fineApi.GetAccount)
+
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Patron borrows for themselves", func(t *testing.T) {
+		resp := sendRequest(patron, "POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: patron.ID})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Patron cannot borrow for another user", func(t *testing.T) {
+		resp := sendRequest(patron, "POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: otherPatron.ID})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Librarian borrows for another user", func(t *testing.T) {
+		resp := sendRequest(librarian, "POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: otherPatron.ID})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Patron only sees their own loans", func(t *testing.T) {
+		resp := sendRequest(patron, "GET", "/book_borrowed", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var borrowed page.Page[book_borrow.BookBorrow]
+		err := json.NewDecoder(resp.Body).Decode(&borrowed)
+		assert.NoError(t, err)
+		if assert.Len(t, borrowed.Items, 1) {
+			assert.Equal(t, patron.ID, borrowed.Items[0].UserID)
+		}
+
+		resp = sendRequest(patron, "GET", fmt.Sprintf("/book_borrowed?user_id=%d", otherPatron.ID), nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Patron cannot renew the loan of another user", func(t *testing.T) {
+		var borrowId int
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT id FROM book_borrows WHERE user_id = $1", otherPatron.ID).Scan(&borrowId)
+		assert.NoError(t, err)
+
+		resp := sendRequest(patron, "POST", fmt.Sprintf("/book_borrow/%d/renew", borrowId), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = sendRequest(patron, "POST", fmt.Sprintf("/book_borrow/%d/renew", borrowId+1000), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = sendRequest(otherPatron, "POST", fmt.Sprintf("/book_borrow/%d/renew", borrowId), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Patron cannot see the loan of another user", func(t *testing.T) {
+		var borrowId int
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT id FROM book_borrows WHERE user_id = $1", otherPatron.ID).Scan(&borrowId)
+		assert.NoError(t, err)
+
+		resp := sendRequest(patron, "GET", fmt.Sprintf("/loans/%d", borrowId), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = sendRequest(patron, "POST", fmt.Sprintf("/loans/%d/return", borrowId), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = sendRequest(otherPatron, "GET", fmt.Sprintf("/loans/%d", borrowId), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Patron cannot cancel the hold of another user", func(t *testing.T) {
+		resp := sendRequest(otherPatron, "POST", "/hold", hold.Hold{BookID: unavailableBookId, UserID: otherPatron.ID})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var placedHold hold.Hold
+		err := json.NewDecoder(resp.Body).Decode(&placedHold)
+		assert.NoError(t, err)
+
+		resp = sendRequest(patron, "DELETE", fmt.Sprintf("/hold/%d", placedHold.ID), nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(patron, "POST", "/hold", hold.Hold{BookID: unavailableBookId, UserID: otherPatron.ID})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Patron cannot view another user", func(t *testing.T) {
+		resp := sendRequest(patron, "GET", fmt.Sprintf("/user/%d", otherPatron.ID), nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(patron, "GET", fmt.Sprintf("/user/%d/balance", otherPatron.ID), nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(patron, "GET", fmt.Sprintf("/user/%d", patron.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Only librarians and admins manage books", func(t *testing.T) {
+		resp := sendRequest(patron, "POST", "/book", book.Book{Title: "The Silmarillion", Quantity: 1})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(librarian, "POST", "/book", book.Book{Title: "The Silmarillion", Quantity: 1})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("Only admins create users with a role", func(t *testing.T) {
+		resp := sendRequest(librarian, "POST", "/user", user.User{FirstName: "Eva", LastName: "Librarian", Role: user.RoleLibrarian})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(admin, "POST", "/user", user.User{FirstName: "Eva", LastName: "Librarian", Role: user.RoleLibrarian})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("Only staff create users with a tier or blocked", func(t *testing.T) {
+		resp := sendRequest(nil, "POST", "/user", user.User{FirstName: "Iza", LastName: "Staff", Tier: user.TierStaff})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(patron, "POST", "/user", user.User{FirstName: "Iza", LastName: "Premium", Tier: user.TierPremium})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(nil, "POST", "/user", user.User{FirstName: "Iza", LastName: "Blocked", Blocked: true})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(nil, "POST", "/user", user.User{FirstName: "Iza", LastName: "Standard", Tier: user.TierStandard})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp = sendRequest(librarian, "POST", "/user", user.User{FirstName: "Iza", LastName: "Staff", Tier: user.TierStaff})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("Only admins delete users", func(t *testing.T) {
+		resp := sendRequest(librarian, "DELETE", fmt.Sprintf("/user/%d", otherPatron.ID), nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = sendRequest(admin, "DELETE", fmt.Sprintf("/user/%d", librarian.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Unauthenticated requests are refused", func(t *testing.T) {
+		resp := sendRequest(nil, "GET", fmt.Sprintf("/user/%d", patron.ID), nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
@@ -1,14 +1,25 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	er "kokal5296/errors"
 	"kokal5296/models/book"
+	"kokal5296/models/bulk"
 	"kokal5296/service"
 	validate "kokal5296/web/validation"
 	"log"
 	"strconv"
+	"strings"
+)
+
+// Number of search results returned by default and at most
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
 )
 
 type BookApiStruct struct {
@@ -33,25 +44,25 @@ func (s *BookApiStruct) CreateBook(c *fiber.Ctx) error {
 	err := json.Unmarshal(c.Body(), &newBook)
 	if err != nil {
 		log.Printf("Error while unmarshalling book: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateBook(newBook)
 	if validateErr != nil {
 		log.Printf("Error while validating book: %v", validateErr)
-		return c.Status(fiber.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
 	}
+	newBook.ISBN = validate.NormalizeISBN(newBook.ISBN)
 
 	err = s.bookService.CreateBook(c.Context(), newBook)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(fiber.StatusCreated).SendString("Book was successfully created")
 }
 
-// GetBook handles the request to get a book by id
+// GetBook handles the request to get a book by id, returned with its ETag
 func (s *BookApiStruct) GetBook(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get book by id")
@@ -61,34 +72,83 @@ func (s *BookApiStruct) GetBook(c *fiber.Ctx) error {
 	bookId, err := strconv.Atoi(id)
 	if err != nil {
 		log.Printf("Error while converting id to int: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
+	}
+
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		return badRequest(c, err)
 	}
 
-	book, err := s.bookService.GetBook(c.Context(), bookId)
+	err = authorizeIncludeDeleted(c, includeDeleted)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(book)
+	book, err := s.bookService.GetBook(c.Context(), bookId, includeDeleted)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return sendWithETag(c, book, book.Version)
 }
 
-// GetAllBooks handles the request to get all books
+// GetAllBooks handles the request to get a page of books
 func (s *BookApiStruct) GetAllBooks(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get all books")
 	funcName := handler + "GetAllBooks"
 
-	books, err := s.bookService.GetAllBooks(c.Context())
+	params, err := parsePageParams(c, service.BookListSpec)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = authorizeIncludeDeleted(c, params.IncludeDeleted)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	books, err := s.bookService.GetAllBooks(c.Context(), params)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(books)
+}
+
+// SearchBooks handles the request to search the catalog by title, authors and publisher.
+// The q query parameter is required, available=true leaves out books that cannot be borrowed,
+// and limit caps the number of results.
+func (s *BookApiStruct) SearchBooks(c *fiber.Ctx) error {
+
+	log.Println("Requesting to search books")
+	funcName := handler + "SearchBooks"
+
+	search := strings.TrimSpace(c.Query("q"))
+	if search == "" {
+		return badRequest(c, errors.New("Query parameter q is required"))
+	}
+
+	onlyAvailable, err := strconv.ParseBool(c.Query("available", "false"))
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return badRequest(c, err)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		return badRequest(c, fmt.Errorf("Query parameter limit must be a number between 1 and %d", maxSearchLimit))
+	}
+
+	books, err := s.bookService.SearchBooks(c.Context(), search, onlyAvailable, limit)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(books)
 }
 
-// UpdateBook handles the request to update a book
+// UpdateBook handles the request to update a book, which requires the ETag of the book in If-Match
 func (s *BookApiStruct) UpdateBook(c *fiber.Ctx) error {
 
 	log.Println("Requesting to update book")
@@ -100,28 +160,78 @@ func (s *BookApiStruct) UpdateBook(c *fiber.Ctx) error {
 
 	bookId, err := strconv.Atoi(id)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	err = json.Unmarshal(c.Body(), &updateBook)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateBook(updateBook)
 	if validateErr != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
 	}
+	updateBook.ISBN = validate.NormalizeISBN(updateBook.ISBN)
 
-	err = s.bookService.UpdateBook(c.Context(), bookId, updateBook)
+	version, err := parseIfMatch(c, true)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return err
+	}
+
+	err = s.bookService.UpdateBook(c.Context(), bookId, version, updateBook)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(fiber.StatusOK).SendString("Book was updated successfully")
 }
 
+// PatchBook handles the request to change some fields of a book with a JSON merge patch.
+// If-Match is optional, without it the patch is applied to the current version of the book.
+func (s *BookApiStruct) PatchBook(c *fiber.Ctx) error {
+
+	log.Println("Requesting to patch book")
+	funcName := handler + "PatchBook"
+
+	var patch book.Book
+
+	id := c.Params("id")
+
+	bookId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = checkMergePatchType(c)
+	if err != nil {
+		return err
+	}
+
+	fields, err := parseMergePatch(c, &patch, service.BookPatchFields)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateBookPatch(patch, fields)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+	patch.ISBN = validate.NormalizeISBN(patch.ISBN)
+
+	version, err := parseIfMatch(c, false)
+	if err != nil {
+		return err
+	}
+
+	err = s.bookService.PatchBook(c.Context(), bookId, version, patch, fields)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Book was patched successfully")
+}
+
 // DeleteBook handles the request to delete a book
 func (s *BookApiStruct) DeleteBook(c *fiber.Ctx) error {
 
@@ -132,14 +242,70 @@ func (s *BookApiStruct) DeleteBook(c *fiber.Ctx) error {
 
 	bookId, err := strconv.Atoi(id)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	err = s.bookService.DeleteBook(c.Context(), bookId)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(fiber.StatusOK).SendString("Book was deleted successfully")
 }
+
+// RestoreBook handles the request to restore a deleted book
+func (s *BookApiStruct) RestoreBook(c *fiber.Ctx) error {
+
+	log.Println("Requesting to restore book")
+	funcName := handler + "RestoreBook"
+
+	id := c.Params("id")
+
+	bookId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = s.bookService.RestoreBook(c.Context(), bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Book was restored successfully")
+}
+
+// ImportBooks handles the request to import books from an upload in CSV or NDJSON, replying with a report
+// of the rows that were imported and the rows that failed
+func (s *BookApiStruct) ImportBooks(c *fiber.Ctx) error {
+
+	log.Println("Requesting to import books")
+	funcName := handler + "ImportBooks"
+
+	format, err := importFormat(c)
+	if err != nil {
+		return err
+	}
+
+	options, err := parseImportOptions(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	report := &bulk.Report{Errors: []bulk.RowError{}}
+	rows, err := readImport(bytes.NewReader(c.Body()), format, bookColumns, bookFromCSV, func(b *book.Book) error {
+		err := validate.ValidateBook(*b)
+		b.ISBN = validate.NormalizeISBN(b.ISBN)
+		return err
+	}, report)
+	if err != nil {
+		log.Printf("Error while reading books: %v", err)
+		return badRequest(c, err)
+	}
+
+	imported, err := s.bookService.ImportBooks(c.Context(), rows, options)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(mergeReports(report, imported))
+}
@@ -2,15 +2,30 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	er "kokal5296/errors"
 	"kokal5296/models/book_borrow"
+	"kokal5296/models/page"
 	"kokal5296/service"
 	validate "kokal5296/web/validation"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+// policyViolationStatus maps loan policy rules to the status code returned when they refuse a borrow.
+// Limits on the number of loans conflict with the user's current loans, while a blocked account or
+// unpaid balance makes the request unprocessable until the account is settled.
+var policyViolationStatus = map[string]int{
+	service.RuleAccountBlocked:     fiber.StatusUnprocessableEntity,
+	service.RuleUnpaidBalance:      fiber.StatusUnprocessableEntity,
+	service.RuleMaxConcurrentLoans: fiber.StatusConflict,
+	service.RuleMaxLoansPerTitle:   fiber.StatusConflict,
+}
+
 type BookBorrowApiStruct struct {
 	bookBorrowService service.BookBorrowService
 }
@@ -22,39 +37,135 @@ func NewBookBorrowApiService(bookBorrowService service.BookBorrowService) BookBo
 	}
 }
 
-// GetAvailableBooks handles the request to get all available books
+// GetAvailableBooks handles the request to get a page of available books
 func (s *BookBorrowApiStruct) GetAvailableBooks(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get available books")
 
 	funcName := handler + "GetAvailableBooks"
 
-	books, err := s.bookBorrowService.GetAvailableBooks(c.Context())
+	params, err := parsePageParams(c, service.AvailableBookListSpec)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	books, err := s.bookBorrowService.GetAvailableBooks(c.Context(), params)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(books)
 }
 
-// AllBorrowedBooks handles the request to get all borrowed books
+// AllBorrowedBooks handles the request to get a page of borrowed books
 func (s *BookBorrowApiStruct) AllBorrowedBooks(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get all borrowed books")
 
 	funcName := handler + "AllBorrowedBooks"
 
-	books, err := s.bookBorrowService.AllBorrowedBooks(c.Context())
+	params, err := parsePageParams(c, service.BookBorrowListSpec)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	anyUser, err := actsForAnyUser(c)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	// Patrons only see their own loans
+	if !anyUser {
+		if userId, ok := params.Filters["user_id"]; ok {
+			err = authorizeUser(c, userId.(int))
+			if err != nil {
+				return er.Wrap(funcName, err)
+			}
+		}
+		params.Filters["user_id"] = AuthenticatedUser(c).ID
+	}
+
+	books, err := s.bookBorrowService.AllBorrowedBooks(c.Context(), params)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(books)
 }
 
-// BorrowBook handles the request to borrow a book
+// UserLoans handles the request to get a page of the borrowing history of a user
+func (s *BookBorrowApiStruct) UserLoans(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get loans of user")
+	funcName := handler + "UserLoans"
+
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	params, err := parseLoanHistoryParams(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = authorizeUser(c, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	loans, err := s.bookBorrowService.UserLoans(c.Context(), userId, params)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(loans)
+}
+
+// BookLoans handles the request to get a page of the borrowing history of a book
+func (s *BookBorrowApiStruct) BookLoans(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get loans of book")
+	funcName := handler + "BookLoans"
+
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	params, err := parseLoanHistoryParams(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	loans, err := s.bookBorrowService.BookLoans(c.Context(), bookId, params)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(loans)
+}
+
+// parseLoanHistoryParams reads the page parameters of a borrowing history request.
+// The status filter must be open, returned or all, and all loans are listed without it.
+func parseLoanHistoryParams(c *fiber.Ctx) (page.Params, error) {
+	params, err := parsePageParams(c, service.LoanHistoryListSpec)
+	if err != nil {
+		return params, err
+	}
+
+	if status, ok := params.Filters["status"]; ok {
+		switch status {
+		case book_borrow.StatusOpen, book_borrow.StatusReturned, book_borrow.StatusAll:
+		default:
+			return params, fmt.Errorf("status must be one of %s, %s, %s", book_borrow.StatusOpen, book_borrow.StatusReturned, book_borrow.StatusAll)
+		}
+	}
+
+	return params, nil
+}
+
+// BorrowBook handles the request to borrow a book, either a specific copy or any available one
 func (s *BookBorrowApiStruct) BorrowBook(c *fiber.Ctx) error {
 
 	log.Println("Requesting to borrow book")
@@ -65,25 +176,142 @@ func (s *BookBorrowApiStruct) BorrowBook(c *fiber.Ctx) error {
 	err := json.Unmarshal(c.Body(), &bookBorrow)
 	if err != nil {
 		log.Printf("Error while unmarshalling book borrow: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateBookBorrow(bookBorrow)
 	if validateErr != nil {
 		log.Printf("Error while validating book borrow: %v", validateErr)
-		return c.Status(fiber.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
 	}
 
-	err = s.bookBorrowService.BorrowBook(c.Context(), bookBorrow.BookID, bookBorrow.UserID)
+	err = authorizeUser(c, bookBorrow.UserID)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
+	}
+
+	copyId := 0
+	if bookBorrow.CopyID != nil {
+		copyId = *bookBorrow.CopyID
+	}
+
+	err = s.bookBorrowService.BorrowBook(c.Context(), bookBorrow.BookID, bookBorrow.UserID, copyId)
+	if err != nil {
+		return borrowError(c, funcName, err)
 	}
 
 	return c.Status(fiber.StatusOK).SendString("Book was successfully borrowed")
 }
 
-// ReturnBook handles the request to return a book
+// CreateLoan handles the request to borrow a book, like BorrowBook, replying with the created loan and its location
+func (s *BookBorrowApiStruct) CreateLoan(c *fiber.Ctx) error {
+
+	log.Println("Requesting to create loan")
+	var bookBorrow book_borrow.BookBorrow
+
+	funcName := handler + "CreateLoan"
+
+	err := json.Unmarshal(c.Body(), &bookBorrow)
+	if err != nil {
+		log.Printf("Error while unmarshalling book borrow: %v", err)
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateBookBorrow(bookBorrow)
+	if validateErr != nil {
+		log.Printf("Error while validating book borrow: %v", validateErr)
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeUser(c, bookBorrow.UserID)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	copyId := 0
+	if bookBorrow.CopyID != nil {
+		copyId = *bookBorrow.CopyID
+	}
+
+	loan, err := s.bookBorrowService.CreateLoan(c.Context(), bookBorrow.BookID, bookBorrow.UserID, copyId)
+	if err != nil {
+		return borrowError(c, funcName, err)
+	}
+
+	c.Location(fmt.Sprintf("%s/%d", strings.TrimSuffix(c.Path(), "/"), loan.ID))
+	return c.Status(fiber.StatusCreated).JSON(loan)
+}
+
+// GetLoan handles the request to get a loan by its id
+func (s *BookBorrowApiStruct) GetLoan(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get loan")
+	funcName := handler + "GetLoan"
+	id := c.Params("id")
+
+	loanId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	loan, err := s.bookBorrowService.GetBookBorrow(c.Context(), loanId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = authorizeLoan(c, loan)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(loan)
+}
+
+// ReturnLoan handles the request to return the book of a loan, replying with the closed loan
+func (s *BookBorrowApiStruct) ReturnLoan(c *fiber.Ctx) error {
+
+	log.Println("Requesting to return loan")
+	funcName := handler + "ReturnLoan"
+	id := c.Params("id")
+
+	loanId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	loan, err := s.bookBorrowService.GetBookBorrow(c.Context(), loanId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = authorizeLoan(c, loan)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	loan, err = s.bookBorrowService.ReturnLoan(c.Context(), loanId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(loan)
+}
+
+// borrowError replies to a borrow refused by the loan policy with the problem details of the violated rule,
+// and returns any other error to the error handler
+func borrowError(c *fiber.Ctx, funcName string, err error) error {
+	var violation *service.PolicyViolation
+	if errors.As(err, &violation) {
+		p := newProblem(c, policyViolationStatus[violation.Rule], violation.Message)
+		p.Rule = violation.Rule
+		return sendProblem(c, p)
+	}
+	return er.Wrap(funcName, err)
+}
+
+// ReturnBook handles the request to return a book, optionally naming the returned copy
 func (s *BookBorrowApiStruct) ReturnBook(c *fiber.Ctx) error {
 
 	log.Println("Requesting to return book")
@@ -94,20 +322,75 @@ func (s *BookBorrowApiStruct) ReturnBook(c *fiber.Ctx) error {
 	err := json.Unmarshal(c.Body(), &bookBorrow)
 	if err != nil {
 		log.Printf("Error while unmarshalling book borrow: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateBookBorrow(bookBorrow)
 	if validateErr != nil {
 		log.Printf("Error while validating book borrow: %v", validateErr)
-		return c.Status(fiber.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeUser(c, bookBorrow.UserID)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	copyId := 0
+	if bookBorrow.CopyID != nil {
+		copyId = *bookBorrow.CopyID
 	}
 
-	err = s.bookBorrowService.ReturnBook(c.Context(), bookBorrow.BookID, bookBorrow.UserID)
+	err = s.bookBorrowService.ReturnBook(c.Context(), bookBorrow.BookID, bookBorrow.UserID, copyId)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(fiber.StatusOK).SendString("Book was successfully returned")
 }
+
+// RenewBook handles the request to renew a borrowed book, extending its due date
+func (s *BookBorrowApiStruct) RenewBook(c *fiber.Ctx) error {
+
+	log.Println("Requesting to renew borrowed book")
+	funcName := handler + "RenewBook"
+	id := c.Params("id")
+
+	borrowId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	bookBorrow, err := s.bookBorrowService.GetBookBorrow(c.Context(), borrowId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = authorizeLoan(c, bookBorrow)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	bookBorrow, err = s.bookBorrowService.RenewBook(c.Context(), borrowId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(bookBorrow)
+}
+
+// OverdueBooks handles the request to get all borrowed books that are past their due date
+func (s *BookBorrowApiStruct) OverdueBooks(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get overdue books")
+
+	funcName := handler + "OverdueBooks"
+
+	books, err := s.bookBorrowService.OverdueBooks(c.Context())
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(books)
+}
@@ -4,16 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"github.com/gofiber/fiber/v2"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"kokal5296/config"
 	"kokal5296/models/book"
 	"kokal5296/models/book_borrow"
+	"kokal5296/models/page"
+	"kokal5296/models/problem"
 	"kokal5296/models/user"
 	"kokal5296/service"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestAvailibleBooks tests the scenarios for retrieving all available books
@@ -25,10 +32,10 @@ func TestAvailibleBooks(t *testing.T) {
 
 	userService := service.NewUserService(dbService)
 	bookService := service.NewBookService(dbService)
-	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
 	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/book_borrow", bookBorrowApi.GetAvailableBooks)
 
 	t.Run("Retrieve all available books", func(t *testing.T) {
@@ -39,8 +46,7 @@ func TestAvailibleBooks(t *testing.T) {
 		}
 
 		for _, b := range existingBooks {
-			_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-			assert.NoError(t, err)
+			insertBook(t, dbService, b.Title, b.Quantity)
 		}
 
 		req, _ := http.NewRequest("GET", "/book_borrow", nil)
@@ -48,8 +54,9 @@ func TestAvailibleBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var borrowedBooks []book.Book
-		err = json.NewDecoder(resp.Body).Decode(&borrowedBooks)
+		var borrowedBooksPage page.Page[book.Book]
+		err = json.NewDecoder(resp.Body).Decode(&borrowedBooksPage)
+		borrowedBooks := borrowedBooksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, borrowedBooks, 2)
 		log.Printf("Borrowed books: %v", borrowedBooks)
@@ -66,8 +73,7 @@ func TestAvailibleBooks(t *testing.T) {
 		}
 
 		for _, b := range existingBooks {
-			_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-			assert.NoError(t, err)
+			insertBook(t, dbService, b.Title, b.Quantity)
 		}
 
 		req, _ := http.NewRequest("GET", "/book_borrow", nil)
@@ -75,8 +81,9 @@ func TestAvailibleBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var borrowedBooks []book.Book
-		err = json.NewDecoder(resp.Body).Decode(&borrowedBooks)
+		var borrowedBooksPage page.Page[book.Book]
+		err = json.NewDecoder(resp.Body).Decode(&borrowedBooksPage)
+		borrowedBooks := borrowedBooksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, borrowedBooks, 0)
 		log.Printf("Borrowed books: %v", borrowedBooks)
@@ -92,10 +99,10 @@ func TestBorrowBook(t *testing.T) {
 
 	userService := service.NewUserService(dbService)
 	bookService := service.NewBookService(dbService)
-	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
 	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
 
 	existingBooks := []book.Book{
@@ -111,8 +118,7 @@ func TestBorrowBook(t *testing.T) {
 	}
 
 	for _, b := range existingBooks {
-		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-		assert.NoError(t, err)
+		insertBook(t, dbService, b.Title, b.Quantity)
 	}
 
 	for _, u := range existingUsers {
@@ -135,25 +141,25 @@ func TestBorrowBook(t *testing.T) {
 		{
 			name:          "Borrow a book that is not available",
 			input:         book_borrow.BookBorrow{BookID: 2, UserID: 1},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusConflict,
 			expectedCount: 1,
 		},
 		{
 			name:          "Borrow a book that is already borrowed",
 			input:         book_borrow.BookBorrow{BookID: 1, UserID: 1},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusConflict,
 			expectedCount: 1,
 		},
 		{
 			name:          "Borrow a book that does not exist",
 			input:         book_borrow.BookBorrow{BookID: 100, UserID: 1},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusNotFound,
 			expectedCount: 1,
 		},
 		{
 			name:          "Borrow a book with a user that does not exist",
 			input:         book_borrow.BookBorrow{BookID: 1, UserID: 100},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusNotFound,
 			expectedCount: 1,
 		},
 	}
@@ -186,10 +192,10 @@ func TestReturnBook(t *testing.T) {
 
 	userService := service.NewUserService(dbService)
 	bookService := service.NewBookService(dbService)
-	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
 	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
 
 	existingBooks := []book.Book{
@@ -205,8 +211,7 @@ func TestReturnBook(t *testing.T) {
 	}
 
 	for _, b := range existingBooks {
-		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-		assert.NoError(t, err)
+		insertBook(t, dbService, b.Title, b.Quantity)
 	}
 
 	for _, u := range existingUsers {
@@ -226,19 +231,19 @@ func TestReturnBook(t *testing.T) {
 		{
 			name:          "Return a book that is not borrowed",
 			input:         book_borrow.BookBorrow{BookID: 3, UserID: 1},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusNotFound,
 			expectedCount: 1,
 		},
 		{
 			name:          "Return a book that does not exist",
 			input:         book_borrow.BookBorrow{BookID: 100, UserID: 1},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusNotFound,
 			expectedCount: 1,
 		},
 		{
 			name:          "Return a book with a user that does not exist",
 			input:         book_borrow.BookBorrow{BookID: 1, UserID: 100},
-			expected:      http.StatusInternalServerError,
+			expected:      http.StatusNotFound,
 			expectedCount: 1,
 		},
 		{
@@ -276,10 +281,10 @@ func TestAllBorrowedBooks(t *testing.T) {
 
 	userService := service.NewUserService(dbService)
 	bookService := service.NewBookService(dbService)
-	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
 	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/book_borrowed", bookBorrowApi.AllBorrowedBooks)
 	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
 
@@ -297,8 +302,7 @@ func TestAllBorrowedBooks(t *testing.T) {
 		}
 
 		for _, b := range existingBooks {
-			_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-			assert.NoError(t, err)
+			insertBook(t, dbService, b.Title, b.Quantity)
 		}
 
 		for _, u := range existingUsers {
@@ -316,8 +320,9 @@ func TestAllBorrowedBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var borrowedBooks []book_borrow.BookBorrow
-		err = json.NewDecoder(resp.Body).Decode(&borrowedBooks)
+		var borrowedBooksPage page.Page[book_borrow.BookBorrow]
+		err = json.NewDecoder(resp.Body).Decode(&borrowedBooksPage)
+		borrowedBooks := borrowedBooksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, borrowedBooks, 2)
 	})
@@ -331,8 +336,9 @@ func TestAllBorrowedBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var borrowedBooks []book_borrow.BookBorrow
-		err = json.NewDecoder(resp.Body).Decode(&borrowedBooks)
+		var borrowedBooksPage page.Page[book_borrow.BookBorrow]
+		err = json.NewDecoder(resp.Body).Decode(&borrowedBooksPage)
+		borrowedBooks := borrowedBooksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, borrowedBooks, 0)
 	})
@@ -361,8 +367,7 @@ func TestAllBorrowedBooks(t *testing.T) {
 		}
 
 		for _, b := range existingBooks {
-			_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-			assert.NoError(t, err)
+			insertBook(t, dbService, b.Title, b.Quantity)
 		}
 
 		for _, u := range existingUsers {
@@ -388,9 +393,587 @@ func TestAllBorrowedBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var borrowedBooks []book_borrow.BookBorrow
-		err = json.NewDecoder(resp.Body).Decode(&borrowedBooks)
+		var borrowedBooksPage page.Page[book_borrow.BookBorrow]
+		err = json.NewDecoder(resp.Body).Decode(&borrowedBooksPage)
+		borrowedBooks := borrowedBooksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, borrowedBooks, 1)
 	})
 }
+
+// TestLoanHistory tests the scenarios for retrieving the borrowing history of a user and of a book
+func TestLoanHistory(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/user/:id/loans", bookBorrowApi.UserLoans)
+	app.Get("/book/:id/loans", bookBorrowApi.BookLoans)
+
+	var userId int
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Tine', 'Kokalj') RETURNING id").Scan(&userId)
+	assert.NoError(t, err)
+	hobbitId := insertBook(t, dbService, "The Hobbit", 1)
+	duneId := insertBook(t, dbService, "Dune", 1)
+
+	_, err = dbService.GetPool().Exec(context.Background(), `INSERT INTO book_borrows (book_id, user_id, borrow_date, return_date)
+		VALUES ($1, $2, '2024-01-10T10:00:00Z', '2024-01-20T10:00:00Z')`, hobbitId, userId)
+	assert.NoError(t, err)
+	_, err = dbService.GetPool().Exec(context.Background(), `INSERT INTO book_borrows (book_id, user_id, borrow_date)
+		VALUES ($1, $2, '2024-03-01T10:00:00Z')`, duneId, userId)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedTitles []string
+	}{
+		{
+			name:           "All loans of user",
+			path:           fmt.Sprintf("/user/%d/loans", userId),
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Dune", "The Hobbit"},
+		},
+		{
+			name:           "Open loans of user",
+			path:           fmt.Sprintf("/user/%d/loans?status=open", userId),
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Dune"},
+		},
+		{
+			name:           "Returned loans of user",
+			path:           fmt.Sprintf("/user/%d/loans?status=returned", userId),
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"The Hobbit"},
+		},
+		{
+			name:           "Loans of user in date range",
+			path:           fmt.Sprintf("/user/%d/loans?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z", userId),
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"The Hobbit"},
+		},
+		{
+			name:           "Loans of book",
+			path:           fmt.Sprintf("/book/%d/loans?sort=borrow_date", hobbitId),
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"The Hobbit"},
+		},
+		{
+			name:           "Unknown status",
+			path:           fmt.Sprintf("/user/%d/loans?status=lost", userId),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Loans of unknown user",
+			path:           "/user/9999/loans",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Loans of unknown book",
+			path:           "/book/9999/loans",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == http.StatusOK {
+				var loans page.Page[book_borrow.Loan]
+				err = json.NewDecoder(resp.Body).Decode(&loans)
+				assert.NoError(t, err)
+
+				titles := []string{}
+				for _, loan := range loans.Items {
+					titles = append(titles, loan.BookTitle)
+					assert.Equal(t, "Tine Kokalj", loan.UserName)
+				}
+				assert.Equal(t, tt.expectedTitles, titles)
+			}
+		})
+	}
+}
+
+// TestConcurrentBorrowBook fires parallel borrow and return requests and checks that the inventory stays consistent.
+// At most parallelRequests requests run at once, and requests that time out waiting for the lock of the book are
+// counted apart, so that a slow database does not fail the test as long as the inventory stays right.
+func TestConcurrentBorrowBook(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
+
+	const (
+		userCount        = 300
+		copies           = 5
+		parallelRequests = 50
+	)
+
+	bookId := insertBook(t, dbService, "The Hobbit", copies)
+
+	userIds := make([]int, userCount)
+	for i := range userIds {
+		err := dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", fmt.Sprintf("User%d", i), "Reader").Scan(&userIds[i])
+		assert.NoError(t, err)
+	}
+
+	sendBorrowRequest := func(method string, input book_borrow.BookBorrow) int {
+		reqBody, _ := json.Marshal(input)
+		req := httptest.NewRequest(method, "/book_borrow", bytes.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			return 0
+		}
+		return resp.StatusCode
+	}
+
+	// runParallel calls request for every user, with at most parallelRequests calls at once
+	runParallel := func(userIds []int, request func(userId int)) {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, parallelRequests)
+		for _, userId := range userIds {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(userId int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				request(userId)
+			}(userId)
+		}
+		wg.Wait()
+	}
+
+	assertInventory := func(t *testing.T, expectedQuantity int) {
+		var quantity, openBorrows int
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM copies WHERE book_id = $1 AND status = 'available'", bookId).Scan(&quantity)
+		assert.NoError(t, err)
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM book_borrows WHERE book_id = $1 AND return_date IS NULL", bookId).Scan(&openBorrows)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedQuantity, quantity)
+		assert.Equal(t, copies, quantity+openBorrows)
+	}
+
+	t.Run("Parallel borrows of the last copies", func(t *testing.T) {
+		var succeeded, timedOut int32
+		runParallel(userIds, func(userId int) {
+			switch sendBorrowRequest("POST", book_borrow.BookBorrow{BookID: bookId, UserID: userId}) {
+			case http.StatusOK:
+				atomic.AddInt32(&succeeded, 1)
+			case http.StatusGatewayTimeout:
+				atomic.AddInt32(&timedOut, 1)
+			}
+		})
+
+		assert.LessOrEqual(t, succeeded, int32(copies))
+		if timedOut == 0 {
+			assert.Equal(t, int32(copies), succeeded)
+		} else {
+			t.Logf("%d borrows timed out", timedOut)
+		}
+		assertInventory(t, copies-int(succeeded))
+	})
+
+	t.Run("Parallel returns and borrows", func(t *testing.T) {
+		var borrowers []int
+		rows, err := dbService.GetPool().Query(context.Background(), "SELECT user_id FROM book_borrows WHERE book_id = $1 AND return_date IS NULL", bookId)
+		assert.NoError(t, err)
+		for rows.Next() {
+			var userId int
+			assert.NoError(t, rows.Scan(&userId))
+			borrowers = append(borrowers, userId)
+		}
+		rows.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			runParallel(borrowers, func(userId int) {
+				sendBorrowRequest("PUT", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			runParallel(userIds, func(userId int) {
+				sendBorrowRequest("POST", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+			})
+		}()
+		wg.Wait()
+
+		var quantity int
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM copies WHERE book_id = $1 AND status = 'available'", bookId).Scan(&quantity)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, quantity, 0)
+		assertInventory(t, quantity)
+	})
+
+	t.Run("Parallel borrows of the same book by the same user", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "UPDATE book_borrows SET return_date = NOW() WHERE return_date IS NULL")
+		assert.NoError(t, err)
+		_, err = dbService.GetPool().Exec(context.Background(), "UPDATE copies SET status = 'available' WHERE book_id = $1", bookId)
+		assert.NoError(t, err)
+
+		var succeeded, timedOut int32
+		sameUser := make([]int, parallelRequests)
+		for i := range sameUser {
+			sameUser[i] = userIds[0]
+		}
+		runParallel(sameUser, func(userId int) {
+			switch sendBorrowRequest("POST", book_borrow.BookBorrow{BookID: bookId, UserID: userId}) {
+			case http.StatusOK:
+				atomic.AddInt32(&succeeded, 1)
+			case http.StatusGatewayTimeout:
+				atomic.AddInt32(&timedOut, 1)
+			}
+		})
+
+		assert.LessOrEqual(t, succeeded, int32(1))
+		if timedOut == 0 {
+			assert.Equal(t, int32(1), succeeded)
+		}
+		assertInventory(t, copies-int(succeeded))
+	})
+}
+
+// TestRenewBook tests the scenarios for renewing a borrowed book
+func TestRenewBook(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	loanConfig := config.LoanConfig{LoanPeriod: 7 * 24 * time.Hour, MaxRenewals: 1}
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, loanConfig)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow/:id/renew", bookBorrowApi.RenewBook)
+
+	insertBook(t, dbService, "Lord of the Rings: Fellowship of the Ring", 5)
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2)", "Tine", "Kokalj")
+	assert.NoError(t, err)
+
+	var openId, returnedId int
+	var dueDate time.Time
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO book_borrows (book_id, user_id, due_date) VALUES (1, 1, NOW() + INTERVAL '2 days') RETURNING id, due_date").Scan(&openId, &dueDate)
+	assert.NoError(t, err)
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO book_borrows (book_id, user_id, return_date) VALUES (1, 1, NOW()) RETURNING id").Scan(&returnedId)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		id              string
+		expected        int
+		expectedDueDate time.Time
+	}{
+		{
+			name:            "Renew a borrowed book",
+			id:              fmt.Sprint(openId),
+			expected:        http.StatusOK,
+			expectedDueDate: dueDate.Add(loanConfig.LoanPeriod),
+		},
+		{
+			name:     "Renew a book past the maximum number of renewals",
+			id:       fmt.Sprint(openId),
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Renew a book that was already returned",
+			id:       fmt.Sprint(returnedId),
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Renew a borrow that does not exist",
+			id:       "100",
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "Renew a borrow with invalid id format",
+			id:       "invalid",
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/book_borrow/"+tt.id+"/renew", nil)
+
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+
+			if tt.expected == http.StatusOK {
+				var renewed book_borrow.BookBorrow
+				err = json.NewDecoder(resp.Body).Decode(&renewed)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, renewed.RenewalCount)
+				assert.WithinDuration(t, tt.expectedDueDate, renewed.Due_date, time.Second)
+			}
+		})
+	}
+}
+
+// TestLoans tests creating, getting and returning a loan by its id
+func TestLoans(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/loans", bookBorrowApi.CreateLoan)
+	app.Get("/loans/:id", bookBorrowApi.GetLoan)
+	app.Post("/loans/:id/return", bookBorrowApi.ReturnLoan)
+
+	bookId := insertBook(t, dbService, "The Hobbit", 1)
+	var userId int
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", "Tine", "Kokalj").Scan(&userId)
+	assert.NoError(t, err)
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		var body io.Reader
+		if input != nil {
+			reqBody, _ := json.Marshal(input)
+			body = bytes.NewReader(reqBody)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	var loan book_borrow.BookBorrow
+
+	t.Run("Create a loan", func(t *testing.T) {
+		resp := sendRequest("POST", "/loans", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		err := json.NewDecoder(resp.Body).Decode(&loan)
+		assert.NoError(t, err)
+		assert.NotZero(t, loan.ID)
+		assert.Equal(t, bookId, loan.BookID)
+		assert.NotNil(t, loan.CopyID)
+		assert.Nil(t, loan.Return_date)
+		assert.Equal(t, fmt.Sprintf("/loans/%d", loan.ID), resp.Header.Get("Location"))
+	})
+
+	t.Run("Create a loan of a book without available copies", func(t *testing.T) {
+		resp := sendRequest("POST", "/loans", book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Get a loan", func(t *testing.T) {
+		resp := sendRequest("GET", fmt.Sprintf("/loans/%d", loan.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var fetched book_borrow.BookBorrow
+		err := json.NewDecoder(resp.Body).Decode(&fetched)
+		assert.NoError(t, err)
+		assert.Equal(t, loan.ID, fetched.ID)
+	})
+
+	t.Run("Get a loan that does not exist", func(t *testing.T) {
+		resp := sendRequest("GET", "/loans/100", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Return a loan", func(t *testing.T) {
+		resp := sendRequest("POST", fmt.Sprintf("/loans/%d/return", loan.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var returned book_borrow.BookBorrow
+		err := json.NewDecoder(resp.Body).Decode(&returned)
+		assert.NoError(t, err)
+		assert.NotNil(t, returned.Return_date)
+
+		var status string
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT status FROM copies WHERE id = $1", *loan.CopyID).Scan(&status)
+		assert.NoError(t, err)
+		assert.Equal(t, "available", status)
+	})
+
+	t.Run("Return a loan that was already returned", func(t *testing.T) {
+		resp := sendRequest("POST", fmt.Sprintf("/loans/%d/return", loan.ID), nil)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Return a loan that does not exist", func(t *testing.T) {
+		resp := sendRequest("POST", "/loans/100/return", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// TestOverdueBooks tests the scenarios for retrieving overdue books
+func TestOverdueBooks(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/book_borrowed/overdue", bookBorrowApi.OverdueBooks)
+
+	existingBooks := []book.Book{
+		{Title: "Lord of the Rings: Fellowship of the Ring", Quantity: 5},
+		{Title: "Lord of the Rings: Two Towers", Quantity: 5},
+		{Title: "Lord of the Rings: Return of the King", Quantity: 5},
+	}
+
+	for _, b := range existingBooks {
+		insertBook(t, dbService, b.Title, b.Quantity)
+	}
+
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2)", "Tine", "Kokalj")
+	assert.NoError(t, err)
+
+	t.Run("No overdue books", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id) VALUES (1, 1)")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/book_borrowed/overdue", nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var overdueBooks []book_borrow.BookBorrow
+		err = json.NewDecoder(resp.Body).Decode(&overdueBooks)
+		assert.NoError(t, err)
+		assert.Len(t, overdueBooks, 0)
+	})
+
+	t.Run("Retrieve overdue books", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id, due_date) VALUES (2, 1, NOW() - INTERVAL '1 day')")
+		assert.NoError(t, err)
+		_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id, due_date, return_date) VALUES (3, 1, NOW() - INTERVAL '3 days', NOW())")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/book_borrowed/overdue", nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var overdueBooks []book_borrow.BookBorrow
+		err = json.NewDecoder(resp.Body).Decode(&overdueBooks)
+		assert.NoError(t, err)
+		assert.Len(t, overdueBooks, 1)
+		assert.Equal(t, 2, overdueBooks[0].BookID)
+	})
+}
+
+// TestLoanPolicies tests that borrowing is limited by the policy of the user's membership tier
+func TestLoanPolicies(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+
+	standardPolicy := user.TierPolicies[user.TierStandard]
+	staffPolicy := user.TierPolicies[user.TierStaff]
+
+	for i := 0; i <= standardPolicy.MaxConcurrentLoans; i++ {
+		insertBook(t, dbService, fmt.Sprintf("Book %d", i+1), 5)
+	}
+
+	existingUsers := []struct {
+		firstName string
+		tier      string
+		blocked   bool
+	}{
+		{firstName: "Standard", tier: user.TierStandard},
+		{firstName: "Staff", tier: user.TierStaff},
+		{firstName: "Blocked", tier: user.TierPremium, blocked: true},
+	}
+
+	for _, u := range existingUsers {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name, tier, blocked) VALUES ($1, $2, $3, $4)", u.firstName, "Reader", u.tier, u.blocked)
+		assert.NoError(t, err)
+	}
+
+	borrow := func(bookId, userId int) *http.Response {
+		reqBody, _ := json.Marshal(book_borrow.BookBorrow{BookID: bookId, UserID: userId})
+		req := httptest.NewRequest("POST", "/book_borrow", bytes.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assertViolation := func(t *testing.T, resp *http.Response, expectedStatus int, expectedRule string) {
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+
+		var violation problem.Problem
+		err := json.NewDecoder(resp.Body).Decode(&violation)
+		assert.NoError(t, err)
+		assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, expectedStatus, violation.Status)
+		assert.Equal(t, expectedRule, violation.Rule)
+		assert.NotEmpty(t, violation.Detail)
+	}
+
+	t.Run("Blocked user cannot borrow", func(t *testing.T) {
+		assertViolation(t, borrow(1, 3), http.StatusUnprocessableEntity, service.RuleAccountBlocked)
+	})
+
+	t.Run("User cannot borrow more books than the tier allows", func(t *testing.T) {
+		for bookId := 1; bookId <= standardPolicy.MaxConcurrentLoans; bookId++ {
+			assert.Equal(t, http.StatusOK, borrow(bookId, 1).StatusCode)
+		}
+
+		assertViolation(t, borrow(standardPolicy.MaxConcurrentLoans+1, 1), http.StatusConflict, service.RuleMaxConcurrentLoans)
+	})
+
+	t.Run("User cannot borrow more copies of a title than the tier allows", func(t *testing.T) {
+		for i := 0; i < staffPolicy.MaxLoansPerTitle; i++ {
+			assert.Equal(t, http.StatusOK, borrow(1, 2).StatusCode)
+		}
+
+		assertViolation(t, borrow(1, 2), http.StatusConflict, service.RuleMaxLoansPerTitle)
+	})
+}
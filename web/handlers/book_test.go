@@ -7,10 +7,15 @@ import (
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/database"
 	"kokal5296/models/book"
+	"kokal5296/models/bulk"
+	"kokal5296/models/page"
 	"kokal5296/service"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -23,8 +28,9 @@ func TestCreateBook(t *testing.T) {
 	bookService := service.NewBookService(dbService)
 	bookApi := NewBookApiService(bookService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Post("/book", bookApi.CreateBook)
+	app.Get("/book/:id", bookApi.GetBook)
 
 	tests := []struct {
 		name               string
@@ -33,8 +39,17 @@ func TestCreateBook(t *testing.T) {
 		expectedCount      int
 	}{
 		{
-			name:               "Create a new book",
-			input:              book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 2},
+			name: "Create a new book",
+			input: book.Book{
+				Title:           "The Lord Of The Rings: Fellowship of the Ring",
+				Quantity:        2,
+				ISBN:            "978-0-261-10357-3",
+				Authors:         []string{"J. R. R. Tolkien"},
+				Publisher:       "HarperCollins",
+				PublicationYear: 1991,
+				Language:        "en",
+				PageCount:       398,
+			},
 			expectedStatusCode: fiber.StatusCreated,
 			expectedCount:      1,
 		},
@@ -51,11 +66,35 @@ func TestCreateBook(t *testing.T) {
 			expectedCount:      1,
 		},
 		{
-			name:               "Create a new book with duplicate title",
-			input:              book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 1},
-			expectedStatusCode: fiber.StatusInternalServerError,
+			name:               "Create a new book with negative quantity",
+			input:              book.Book{Title: "The Alchemist", Quantity: -1},
+			expectedStatusCode: fiber.StatusBadRequest,
 			expectedCount:      1,
 		},
+		{
+			name:               "Create another edition with the same title",
+			input:              book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 1, ISBN: "9780618346257"},
+			expectedStatusCode: fiber.StatusCreated,
+			expectedCount:      2,
+		},
+		{
+			name:               "Create a new book with duplicate ISBN in ISBN-10 form",
+			input:              book.Book{Title: "The Fellowship of the Ring", Quantity: 1, ISBN: "0-261-10357-1"},
+			expectedStatusCode: fiber.StatusConflict,
+			expectedCount:      2,
+		},
+		{
+			name:               "Create a new book with invalid ISBN checksum",
+			input:              book.Book{Title: "The Hobbit", Quantity: 1, ISBN: "978-0-547-92822-8"},
+			expectedStatusCode: fiber.StatusBadRequest,
+			expectedCount:      2,
+		},
+		{
+			name:               "Create a new book with duplicate authors",
+			input:              book.Book{Title: "The Hobbit", Quantity: 1, Authors: []string{"J. R. R. Tolkien", "J. R. R. Tolkien"}},
+			expectedStatusCode: fiber.StatusBadRequest,
+			expectedCount:      2,
+		},
 	}
 
 	for _, tt := range tests {
@@ -74,6 +113,24 @@ func TestCreateBook(t *testing.T) {
 			assert.Equal(t, tt.expectedCount, bookCount)
 		})
 	}
+
+	t.Run("Created book has its metadata", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/book/1", nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var created book.Book
+		err = json.NewDecoder(resp.Body).Decode(&created)
+		assert.NoError(t, err)
+		assert.Equal(t, "9780261103573", created.ISBN)
+		assert.Equal(t, []string{"J. R. R. Tolkien"}, created.Authors)
+		assert.Equal(t, "HarperCollins", created.Publisher)
+		assert.Equal(t, 1991, created.PublicationYear)
+		assert.Equal(t, "en", created.Language)
+		assert.Equal(t, 398, created.PageCount)
+		assert.Equal(t, 2, created.Quantity)
+	})
 }
 
 // TestGetBook tests the scenarios for retrieving a book by ID
@@ -86,12 +143,11 @@ func TestGetBook(t *testing.T) {
 	bookService := service.NewBookService(dbService)
 	bookApi := NewBookApiService(bookService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/book/:id", bookApi.GetBook)
 
 	existingBook := book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 5}
-	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2) RETURNING id", existingBook.Title, existingBook.Quantity).Scan(&existingBook.ID)
-	assert.NoError(t, err)
+	existingBook.ID = insertBook(t, dbService, existingBook.Title, existingBook.Quantity)
 
 	tests := []struct {
 		name               string
@@ -108,7 +164,7 @@ func TestGetBook(t *testing.T) {
 		{
 			name:               "Book Not Found",
 			input:              "100",
-			expectedStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusNotFound,
 			expectedBook:       nil,
 		},
 		{
@@ -146,7 +202,7 @@ func TestGetAllBooks(t *testing.T) {
 	bookService := service.NewBookService(dbService)
 	bookApi := NewBookApiService(bookService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/books", bookApi.GetAllBooks)
 
 	t.Run("Retrieve all books when books exist", func(t *testing.T) {
@@ -157,8 +213,7 @@ func TestGetAllBooks(t *testing.T) {
 		}
 
 		for _, b := range existingBooks {
-			_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2)", b.Title, b.Quantity)
-			assert.NoError(t, err)
+			insertBook(t, dbService, b.Title, b.Quantity)
 		}
 
 		req := httptest.NewRequest("GET", "/books", nil)
@@ -166,8 +221,9 @@ func TestGetAllBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var books []book.Book
-		err = json.NewDecoder(resp.Body).Decode(&books)
+		var booksPage page.Page[book.Book]
+		err = json.NewDecoder(resp.Body).Decode(&booksPage)
+		books := booksPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, existingBooks, len(books))
 
@@ -186,13 +242,117 @@ func TestGetAllBooks(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var books []book.Book
-		err = json.NewDecoder(resp.Body).Decode(&books)
+		var booksPage page.Page[book.Book]
+		err = json.NewDecoder(resp.Body).Decode(&booksPage)
+		books := booksPage.Items
 		assert.NoError(t, err)
 		assert.Empty(t, books)
 	})
 }
 
+// TestSearchBooks tests the scenarios for searching the catalog
+func TestSearchBooks(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	bookService := service.NewBookService(dbService)
+	bookApi := NewBookApiService(bookService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/books/search", bookApi.SearchBooks)
+
+	existingBooks := []book.Book{
+		{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 2, Authors: []string{"J. R. R. Tolkien"}},
+		{Title: "The Hobbit", Quantity: 1, Authors: []string{"J. R. R. Tolkien"}},
+		{Title: "A Game of Thrones", Quantity: 1, Authors: []string{"George R. R. Martin"}, Publisher: "Bantam"},
+	}
+
+	for _, b := range existingBooks {
+		err := bookService.CreateBook(context.Background(), b)
+		assert.NoError(t, err)
+	}
+
+	_, err = dbService.GetPool().Exec(context.Background(), "UPDATE copies SET status = 'on_loan' WHERE book_id = 2")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedTitles     []string
+	}{
+		{
+			name:               "Search by author prefix",
+			query:              "q=tolk",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{"The Lord Of The Rings: Fellowship of the Ring", "The Hobbit"},
+		},
+		{
+			name:               "Search matches all words",
+			query:              "q=fellow+ring",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{"The Lord Of The Rings: Fellowship of the Ring"},
+		},
+		{
+			name:               "Search by publisher",
+			query:              "q=bantam",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{"A Game of Thrones"},
+		},
+		{
+			name:               "Search only available books",
+			query:              "q=tolkien&available=true",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{"The Lord Of The Rings: Fellowship of the Ring"},
+		},
+		{
+			name:               "Search with limit",
+			query:              "q=the&limit=1",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{"The Lord Of The Rings: Fellowship of the Ring"},
+		},
+		{
+			name:               "Search without words",
+			query:              "q=%26%7C%21",
+			expectedStatusCode: http.StatusOK,
+			expectedTitles:     []string{},
+		},
+		{
+			name:               "Search without query",
+			query:              "",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Search with invalid limit",
+			query:              "q=hobbit&limit=0",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/books/search?"+tt.query, nil)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+
+			if tt.expectedTitles != nil {
+				var books []book.Book
+				err = json.NewDecoder(resp.Body).Decode(&books)
+				assert.NoError(t, err)
+
+				titles := []string{}
+				for _, b := range books {
+					titles = append(titles, b.Title)
+				}
+				assert.Equal(t, tt.expectedTitles, titles)
+			}
+		})
+	}
+}
+
 // TestUpdateBook tests the scenarios for updating a book by ID
 func TestUpdateBook(t *testing.T) {
 
@@ -203,12 +363,11 @@ func TestUpdateBook(t *testing.T) {
 	bookService := service.NewBookService(dbService)
 	bookApi := NewBookApiService(bookService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Put("/book/:id", bookApi.UpdateBook)
 
 	existingBook := book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 5}
-	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2) RETURNING id", existingBook.Title, existingBook.Quantity).Scan(&existingBook.ID)
-	assert.NoError(t, err)
+	existingBook.ID = insertBook(t, dbService, existingBook.Title, existingBook.Quantity)
 
 	tests := []struct {
 		name               string
@@ -245,11 +404,18 @@ func TestUpdateBook(t *testing.T) {
 			expectedStatusCode: http.StatusBadRequest,
 			expectedCount:      1,
 		},
+		{
+			name:               "Update book with negative quantity",
+			input:              book.Book{ID: existingBook.ID, Title: "The Lord Of The Rings: Return of the King", Quantity: -5},
+			id:                 fmt.Sprint(existingBook.ID),
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCount:      1,
+		},
 		{
 			name:               "Update book with invalid id",
 			input:              book.Book{Title: "The Lord Of The Rings: Return of the King", Quantity: 5},
 			id:                 "100",
-			expectedStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusNotFound,
 			expectedCount:      1,
 		},
 		{
@@ -266,6 +432,7 @@ func TestUpdateBook(t *testing.T) {
 			requestBody, _ := json.Marshal(tt.input)
 			req := httptest.NewRequest("PUT", "/book/"+tt.id, bytes.NewReader(requestBody))
 			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", "*")
 
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
@@ -279,6 +446,211 @@ func TestUpdateBook(t *testing.T) {
 	}
 }
 
+// TestPatchBook tests the scenarios for changing some fields of a book with a merge patch
+func TestPatchBook(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	bookService := service.NewBookService(dbService)
+	bookApi := NewBookApiService(bookService)
+
+	app := newTestApp(testAdmin)
+	app.Patch("/book/:id", bookApi.PatchBook)
+
+	bookId := insertBook(t, dbService, "The Hobbit", 2)
+	_, err = dbService.GetPool().Exec(context.Background(), "UPDATE books SET page_count = 310 WHERE id = $1", bookId)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		id                 string
+		contentType        string
+		patch              string
+		expectedStatusCode int
+		expectedTitle      string
+		expectedQuantity   int
+		expectedPageCount  int
+	}{
+		{
+			name:               "Patch quantity",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "application/merge-patch+json",
+			patch:              `{"quantity": 4}`,
+			expectedStatusCode: http.StatusOK,
+			expectedTitle:      "The Hobbit",
+			expectedQuantity:   4,
+			expectedPageCount:  310,
+		},
+		{
+			name:               "Patch title and clear page count",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "application/merge-patch+json",
+			patch:              `{"title": "The Hobbit, or There and Back Again", "page_count": null}`,
+			expectedStatusCode: http.StatusOK,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+			expectedPageCount:  0,
+		},
+		{
+			name:               "Patch with null title",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "application/merge-patch+json",
+			patch:              `{"title": null}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+		},
+		{
+			name:               "Patch unknown field",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "application/merge-patch+json",
+			patch:              `{"id": 100}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+		},
+		{
+			name:               "Patch that is not an object",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "application/merge-patch+json",
+			patch:              `["title"]`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+		},
+		{
+			name:               "Patch with unsupported content type",
+			id:                 fmt.Sprint(bookId),
+			contentType:        "text/plain",
+			patch:              `{"quantity": 1}`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+		},
+		{
+			name:               "Patch book with invalid id",
+			id:                 "100",
+			contentType:        "application/merge-patch+json",
+			patch:              `{"quantity": 1}`,
+			expectedStatusCode: http.StatusNotFound,
+			expectedTitle:      "The Hobbit, or There and Back Again",
+			expectedQuantity:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/book/"+tt.id, strings.NewReader(tt.patch))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+
+			patched, err := bookService.GetBook(context.Background(), bookId, false)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTitle, patched.Title)
+			assert.Equal(t, tt.expectedQuantity, patched.Quantity)
+			if tt.expectedStatusCode == http.StatusOK {
+				assert.Equal(t, tt.expectedPageCount, patched.PageCount)
+			}
+		})
+	}
+}
+
+// TestBookETag tests that books are returned with an ETag, which updates must match
+func TestBookETag(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	bookService := service.NewBookService(dbService)
+	bookApi := NewBookApiService(bookService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/book/:id", bookApi.GetBook)
+	app.Put("/book/:id", bookApi.UpdateBook)
+
+	bookId := insertBook(t, dbService, "The Hobbit", 1)
+	path := fmt.Sprintf("/book/%d", bookId)
+
+	sendRequest := func(method string, header string, value string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	updated := book.Book{Title: "The Hobbit, or There and Back Again", Quantity: 1}
+
+	resp := sendRequest("GET", "", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	tag := resp.Header.Get("ETag")
+	assert.Equal(t, `"1"`, tag)
+
+	t.Run("Not modified", func(t *testing.T) {
+		resp := sendRequest("GET", "If-None-Match", tag, nil)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("Update without If-Match", func(t *testing.T) {
+		resp := sendRequest("PUT", "", "", updated)
+		assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	})
+
+	t.Run("Update with current ETag", func(t *testing.T) {
+		resp := sendRequest("PUT", "If-Match", tag, updated)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Update with stale ETag", func(t *testing.T) {
+		resp := sendRequest("PUT", "If-Match", tag, book.Book{Title: "The Hobbit", Quantity: 1})
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+		var title string
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT title FROM books WHERE id = $1", bookId).Scan(&title)
+		assert.NoError(t, err)
+		assert.Equal(t, updated.Title, title)
+	})
+
+	t.Run("Modified after update", func(t *testing.T) {
+		resp := sendRequest("GET", "If-None-Match", tag, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("Modified after a borrow", func(t *testing.T) {
+		resp := sendRequest("GET", "", "", nil)
+		tag := resp.Header.Get("ETag")
+
+		var userId int
+		err := dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Tine', 'Kokalj') RETURNING id").Scan(&userId)
+		assert.NoError(t, err)
+		bookBorrowService := service.NewBookBorrowService(dbService, bookService, service.NewUserService(dbService), config.DefaultLoanConfig())
+		_, err = bookBorrowService.CreateLoan(context.Background(), bookId, userId, 0)
+		assert.NoError(t, err)
+
+		resp = sendRequest("GET", "If-None-Match", tag, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotEqual(t, tag, resp.Header.Get("ETag"))
+
+		resp = sendRequest("PUT", "If-Match", tag, updated)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+}
+
 // TestDeleteBook tests the scenarios for deleting a book by ID
 func TestDeleteBook(t *testing.T) {
 
@@ -289,11 +661,17 @@ func TestDeleteBook(t *testing.T) {
 	bookService := service.NewBookService(dbService)
 	bookApi := NewBookApiService(bookService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Delete("/book/:id", bookApi.DeleteBook)
 
 	existingBook := book.Book{Title: "The Lord Of The Rings: Fellowship of the Ring", Quantity: 5}
-	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO books (title, quantity) VALUES ($1, $2) RETURNING id", existingBook.Title, existingBook.Quantity).Scan(&existingBook.ID)
+	existingBook.ID = insertBook(t, dbService, existingBook.Title, existingBook.Quantity)
+
+	borrowedBookId := insertBook(t, dbService, "The Hobbit", 1)
+	var userId int
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Tine', 'Kokalj') RETURNING id").Scan(&userId)
+	assert.NoError(t, err)
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id) VALUES ($1, $2)", borrowedBookId, userId)
 	assert.NoError(t, err)
 
 	tests := []struct {
@@ -305,20 +683,32 @@ func TestDeleteBook(t *testing.T) {
 		{
 			name:               "Delete book with invalid id",
 			id:                 "100",
-			expectedStatusCode: http.StatusInternalServerError,
-			expectedCount:      1,
+			expectedStatusCode: http.StatusNotFound,
+			expectedCount:      2,
 		},
 		{
 			name:               "Delete book with invalid id format",
 			id:                 "invalid",
 			expectedStatusCode: http.StatusBadRequest,
-			expectedCount:      1,
+			expectedCount:      2,
+		},
+		{
+			name:               "Delete book with copies on loan",
+			id:                 fmt.Sprint(borrowedBookId),
+			expectedStatusCode: http.StatusConflict,
+			expectedCount:      2,
 		},
 		{
 			name:               "Delete book",
 			id:                 fmt.Sprint(existingBook.ID),
 			expectedStatusCode: http.StatusOK,
-			expectedCount:      0,
+			expectedCount:      1,
+		},
+		{
+			name:               "Delete deleted book",
+			id:                 fmt.Sprint(existingBook.ID),
+			expectedStatusCode: http.StatusNotFound,
+			expectedCount:      1,
 		},
 	}
 
@@ -330,9 +720,241 @@ func TestDeleteBook(t *testing.T) {
 			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
 
 			var bookCount int
-			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM books").Scan(&bookCount)
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM books WHERE deleted_at IS NULL").Scan(&bookCount)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedCount, bookCount)
 		})
 	}
 }
+
+// TestRestoreBook tests the scenarios for restoring a deleted book and listing deleted books
+func TestRestoreBook(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	bookService := service.NewBookService(dbService)
+	bookApi := NewBookApiService(bookService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/books", bookApi.GetAllBooks)
+	app.Get("/book/:id", bookApi.GetBook)
+	app.Post("/book/:id/restore", bookApi.RestoreBook)
+
+	insertBook(t, dbService, "The Hobbit", 1)
+	deletedBookId := insertBook(t, dbService, "The Silmarillion", 1)
+	_, err = dbService.GetPool().Exec(context.Background(), "UPDATE books SET deleted_at = NOW() WHERE id = $1", deletedBookId)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		method             string
+		path               string
+		expectedStatusCode int
+		expectedCount      int
+	}{
+		{
+			name:               "List books without deleted books",
+			method:             "GET",
+			path:               "/books",
+			expectedStatusCode: http.StatusOK,
+			expectedCount:      1,
+		},
+		{
+			name:               "List books with deleted books",
+			method:             "GET",
+			path:               "/books?include_deleted=true",
+			expectedStatusCode: http.StatusOK,
+			expectedCount:      2,
+		},
+		{
+			name:               "Get deleted book",
+			method:             "GET",
+			path:               fmt.Sprintf("/book/%d", deletedBookId),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Restore book with invalid id",
+			method:             "POST",
+			path:               "/book/100/restore",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Restore book",
+			method:             "POST",
+			path:               fmt.Sprintf("/book/%d/restore", deletedBookId),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "List books after restore",
+			method:             "GET",
+			path:               "/books",
+			expectedStatusCode: http.StatusOK,
+			expectedCount:      2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+
+			if tt.expectedCount > 0 {
+				var booksPage page.Page[book.Book]
+				err = json.NewDecoder(resp.Body).Decode(&booksPage)
+				assert.NoError(t, err)
+				assert.Len(t, booksPage.Items, tt.expectedCount)
+			}
+		})
+	}
+}
+
+// insertBook inserts a book with the given number of available copies and returns its id
+func insertBook(t *testing.T, dbService database.DatabaseService, title string, quantity int) int {
+	var bookId int
+	err := dbService.GetPool().QueryRow(context.Background(), "INSERT INTO books (title) VALUES ($1) RETURNING id", title).Scan(&bookId)
+	assert.NoError(t, err)
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO copies (book_id) SELECT $1 FROM generate_series(1, $2)", bookId, quantity)
+	assert.NoError(t, err)
+	return bookId
+}
+
+// TestImportBooks tests importing books from CSV and NDJSON in insert, upsert and dry run mode
+func TestImportBooks(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	bookService := service.NewBookService(dbService)
+	bookApi := NewBookApiService(bookService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/books/import", bookApi.ImportBooks)
+
+	csvBooks := "title,quantity,isbn,authors,publisher\n" +
+		"The Hobbit,3,978-0-261-10221-7,J. R. R. Tolkien,HarperCollins\n" +
+		"Good Omens,2,0-575-04800-1,Terry Pratchett;Neil Gaiman,Gollancz\n" +
+		"The Colour of Magic,2,,Terry Pratchett,Corgi\n"
+
+	tests := []struct {
+		name               string
+		path               string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedReport     bulk.Report
+		expectedLines      []int
+		expectedBooks      int
+	}{
+		{
+			name:               "Import books with unsupported content type",
+			path:               "/books/import",
+			contentType:        fiber.MIMEApplicationJSON,
+			body:               `[{"title": "The Hobbit", "quantity": 1}]`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "Import books with unknown column",
+			path:               "/books/import",
+			contentType:        MIMETextCSV,
+			body:               "title,quantity,shelf\nThe Hobbit,1,A1\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Import books with invalid mode",
+			path:               "/books/import?mode=replace",
+			contentType:        MIMETextCSV,
+			body:               csvBooks,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Import books in dry run",
+			path:               "/books/import?dry_run=true",
+			contentType:        MIMETextCSV,
+			body:               csvBooks,
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeInsert, DryRun: true, Rows: 3, Created: 2, Failed: 1},
+			expectedLines:      []int{3},
+			expectedBooks:      0,
+		},
+		{
+			name:               "Import books from CSV",
+			path:               "/books/import",
+			contentType:        MIMETextCSV + "; charset=utf-8",
+			body:               csvBooks,
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeInsert, Rows: 3, Created: 2, Failed: 1},
+			expectedLines:      []int{3},
+			expectedBooks:      2,
+		},
+		{
+			name:        "Import existing and repeated books from NDJSON",
+			path:        "/books/import",
+			contentType: MIMENDJSON,
+			body: `{"title": "The Hobbit", "quantity": 5, "isbn": "9780261102217"}` + "\n\n" +
+				`{"title": "The Silmarillion", "quantity": 1, "isbn": "978-0-261-10273-6"}` + "\n" +
+				`{"title": "The Silmarillion", "quantity": 2, "isbn": "9780261102736"}` + "\n" +
+				`{"title": 1}` + "\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeInsert, Rows: 4, Created: 1, Failed: 3},
+			expectedLines:      []int{1, 4, 5},
+			expectedBooks:      3,
+		},
+		{
+			name:               "Upsert books from NDJSON",
+			path:               "/books/import?mode=upsert",
+			contentType:        MIMENDJSON,
+			body:               `{"title": "The Hobbit", "quantity": 5, "isbn": "9780261102217", "authors": ["J. R. R. Tolkien"]}` + "\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeUpsert, Rows: 1, Updated: 1},
+			expectedLines:      []int{},
+			expectedBooks:      3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+
+			if tt.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var report bulk.Report
+			err = json.NewDecoder(resp.Body).Decode(&report)
+			assert.NoError(t, err)
+
+			lines := []int{}
+			for _, rowError := range report.Errors {
+				lines = append(lines, rowError.Line)
+			}
+			assert.Equal(t, tt.expectedLines, lines)
+
+			report.Errors = nil
+			assert.Equal(t, tt.expectedReport, report)
+
+			var count int
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM books").Scan(&count)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBooks, count)
+		})
+	}
+
+	var quantity int
+	var authors []string
+	query := `SELECT (SELECT COUNT(*) FROM copies WHERE book_id = books.id AND status = 'available'),
+		(SELECT array_agg(a.name ORDER BY ba.position) FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id)
+		FROM books WHERE isbn = '9780261102217'`
+	err = dbService.GetPool().QueryRow(context.Background(), query).Scan(&quantity, &authors)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, quantity)
+	assert.Equal(t, []string{"J. R. R. Tolkien"}, authors)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/book_copy"
+	"kokal5296/service"
+	validate "kokal5296/web/validation"
+	"log"
+	"strconv"
+)
+
+type CopyApiStruct struct {
+	copyService service.CopyService
+}
+
+// NewCopyApiService creates a new instance of CopyApiStruct, which implements the CopyApi interface
+func NewCopyApiService(copyService service.CopyService) CopyApi {
+	return &CopyApiStruct{
+		copyService: copyService,
+	}
+}
+
+// AddCopy handles the request to add a copy to a book
+func (s *CopyApiStruct) AddCopy(c *fiber.Ctx) error {
+
+	log.Println("Requesting to add copy")
+	funcName := handler + "AddCopy"
+
+	bookId, newCopy, err := parseCopy(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	added, err := s.copyService.AddCopy(c.Context(), bookId, newCopy)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(added)
+}
+
+// GetBookCopies handles the request to get all copies of a book
+func (s *CopyApiStruct) GetBookCopies(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get copies of book")
+	funcName := handler + "GetBookCopies"
+	id := c.Params("id")
+
+	bookId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	copies, err := s.copyService.GetBookCopies(c.Context(), bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(copies)
+}
+
+// UpdateCopy handles the request to update the barcode, condition, status or shelf location of a copy
+func (s *CopyApiStruct) UpdateCopy(c *fiber.Ctx) error {
+
+	log.Println("Requesting to update copy")
+	funcName := handler + "UpdateCopy"
+
+	copyId, updatedCopy, err := parseCopy(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	updated, err := s.copyService.UpdateCopy(c.Context(), copyId, updatedCopy)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(updated)
+}
+
+// AuditShelf handles the request to compare the barcodes scanned on a shelf with the recorded copies
+func (s *CopyApiStruct) AuditShelf(c *fiber.Ctx) error {
+
+	log.Println("Requesting shelf audit")
+	var audit book_copy.ShelfAudit
+
+	funcName := handler + "AuditShelf"
+
+	err := json.Unmarshal(c.Body(), &audit)
+	if err != nil {
+		log.Printf("Error while unmarshalling shelf audit: %v", err)
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateShelfAudit(audit)
+	if validateErr != nil {
+		log.Printf("Error while validating shelf audit: %v", validateErr)
+		return badRequest(c, validateErr)
+	}
+
+	report, err := s.copyService.AuditShelf(c.Context(), audit)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+// parseCopy reads the id from the path and validates the copy in the body
+func parseCopy(c *fiber.Ctx) (int, book_copy.Copy, error) {
+	var bookCopy book_copy.Copy
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return 0, bookCopy, err
+	}
+
+	err = json.Unmarshal(c.Body(), &bookCopy)
+	if err != nil {
+		log.Printf("Error while unmarshalling copy: %v", err)
+		return 0, bookCopy, err
+	}
+
+	validateErr := validate.ValidateCopy(bookCopy)
+	if validateErr != nil {
+		log.Printf("Error while validating copy: %v", validateErr)
+		return 0, bookCopy, validateErr
+	}
+
+	return id, bookCopy, nil
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/book_copy"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCopies tests the scenarios for adding, borrowing, updating and auditing individual copies
+func TestCopies(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, config.DefaultLoanConfig())
+	copyService := service.NewCopyService(dbService)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+	copyApi := NewCopyApiService(copyService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
+	app.Post("/book/:id/copies", copyApi.AddCopy)
+	app.Get("/book/:id/copies", copyApi.GetBookCopies)
+	app.Put("/copy/:id", copyApi.UpdateCopy)
+	app.Post("/copy/audit", copyApi.AuditShelf)
+
+	bookId := insertBook(t, dbService, "The Hobbit", 0)
+
+	for _, name := range []string{"Tine", "Žan"} {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2)", name, "Kokalj")
+		assert.NoError(t, err)
+	}
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	addCopy := func(t *testing.T, input book_copy.Copy) book_copy.Copy {
+		resp := sendRequest("POST", fmt.Sprintf("/book/%d/copies", bookId), input)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var added book_copy.Copy
+		err := json.NewDecoder(resp.Body).Decode(&added)
+		assert.NoError(t, err)
+		return added
+	}
+
+	getCopy := func(t *testing.T, copyId int) book_copy.Copy {
+		resp := sendRequest("GET", fmt.Sprintf("/book/%d/copies", bookId), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var copies []book_copy.Copy
+		err := json.NewDecoder(resp.Body).Decode(&copies)
+		assert.NoError(t, err)
+		for _, c := range copies {
+			if c.ID == copyId {
+				return c
+			}
+		}
+		t.Fatalf("copy with id %d not found", copyId)
+		return book_copy.Copy{}
+	}
+
+	first := addCopy(t, book_copy.Copy{Barcode: "HOB-0001", Condition: book_copy.ConditionNew, ShelfLocation: "A1"})
+	second := addCopy(t, book_copy.Copy{ShelfLocation: "A1"})
+
+	t.Run("Added copies are available with a barcode", func(t *testing.T) {
+		assert.Equal(t, "HOB-0001", first.Barcode)
+		assert.Equal(t, book_copy.StatusAvailable, first.Status)
+		assert.NotEmpty(t, second.Barcode)
+		assert.Equal(t, book_copy.ConditionGood, second.Condition)
+	})
+
+	t.Run("Add a copy with a duplicate barcode", func(t *testing.T) {
+		resp := sendRequest("POST", fmt.Sprintf("/book/%d/copies", bookId), book_copy.Copy{Barcode: "HOB-0001"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Borrow a specific copy", func(t *testing.T) {
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: 1, CopyID: &second.ID})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, book_copy.StatusOnLoan, getCopy(t, second.ID).Status)
+
+		var copyId int
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT copy_id FROM book_borrows WHERE user_id = 1 AND return_date IS NULL").Scan(&copyId)
+		assert.NoError(t, err)
+		assert.Equal(t, second.ID, copyId)
+	})
+
+	t.Run("Borrow a copy that is on loan", func(t *testing.T) {
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: 2, CopyID: &second.ID})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Status of a copy on loan cannot be changed", func(t *testing.T) {
+		resp := sendRequest("PUT", fmt.Sprintf("/copy/%d", second.ID), book_copy.Copy{Status: book_copy.StatusLost, ShelfLocation: "A1"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Damaged copies cannot be borrowed", func(t *testing.T) {
+		resp := sendRequest("PUT", fmt.Sprintf("/copy/%d", first.ID), book_copy.Copy{Status: book_copy.StatusDamaged, Condition: book_copy.ConditionPoor, ShelfLocation: "A1"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: 2})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Update a copy with an invalid status", func(t *testing.T) {
+		resp := sendRequest("PUT", fmt.Sprintf("/copy/%d", first.ID), book_copy.Copy{Status: book_copy.StatusOnLoan})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Returning puts the copy back on the shelf", func(t *testing.T) {
+		resp := sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, book_copy.StatusAvailable, getCopy(t, second.ID).Status)
+
+		resp = sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: bookId, UserID: 2})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, book_copy.StatusOnLoan, getCopy(t, second.ID).Status)
+	})
+
+	t.Run("Audit a shelf", func(t *testing.T) {
+		resp := sendRequest("PUT", fmt.Sprintf("/copy/%d", first.ID), book_copy.Copy{Status: book_copy.StatusAvailable, ShelfLocation: "A1"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		third := addCopy(t, book_copy.Copy{ShelfLocation: "A1"})
+		fourth := addCopy(t, book_copy.Copy{ShelfLocation: "B2"})
+
+		resp = sendRequest("POST", "/copy/audit", book_copy.ShelfAudit{
+			ShelfLocation: "A1",
+			Barcodes:      []string{first.Barcode, second.Barcode, fourth.Barcode, "NOT-A-COPY"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report book_copy.AuditReport
+		err := json.NewDecoder(resp.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Found)
+		if assert.Len(t, report.Missing, 1) {
+			assert.Equal(t, third.ID, report.Missing[0].ID)
+		}
+		if assert.Len(t, report.Unexpected, 1) {
+			assert.Equal(t, second.ID, report.Unexpected[0].ID)
+		}
+		if assert.Len(t, report.Misplaced, 1) {
+			assert.Equal(t, fourth.ID, report.Misplaced[0].ID)
+		}
+		assert.Equal(t, []string{"NOT-A-COPY"}, report.Unknown)
+	})
+
+	t.Run("Audit without a shelf location", func(t *testing.T) {
+		resp := sendRequest("POST", "/copy/audit", book_copy.ShelfAudit{Barcodes: []string{first.Barcode}})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/problem"
+	validate "kokal5296/web/validation"
+	"log"
+	"net/http"
+)
+
+// errorKindStatus maps the kind of a service error to the status code it is returned with
+var errorKindStatus = map[er.Kind]int{
+	er.NotFound:           fiber.StatusNotFound,
+	er.Conflict:           fiber.StatusConflict,
+	er.Validation:         fiber.StatusUnprocessableEntity,
+	er.Unavailable:        fiber.StatusServiceUnavailable,
+	er.Timeout:            fiber.StatusGatewayTimeout,
+	er.Unauthorized:       fiber.StatusUnauthorized,
+	er.Forbidden:          fiber.StatusForbidden,
+	er.PreconditionFailed: fiber.StatusPreconditionFailed,
+}
+
+// errorKindDetail is the detail of service errors that carry no message of the application,
+// so that messages of the database are not returned to clients
+var errorKindDetail = map[er.Kind]string{
+	er.Internal:           "The request could not be completed because of an unexpected error",
+	er.NotFound:           "The requested resource does not exist",
+	er.Conflict:           "The request conflicts with the current state of the resource",
+	er.Validation:         "The request is not allowed",
+	er.Unavailable:        "The database is unavailable, try again later",
+	er.Timeout:            "The database did not respond in time, try again later",
+	er.Unauthorized:       "The request requires a valid access token",
+	er.Forbidden:          "The authenticated user is not allowed to make this request",
+	er.PreconditionFailed: "The resource has changed since it was read",
+}
+
+// ErrorHandler is the fiber error handler for errors returned by the handlers.
+// Service errors are returned as problem details with the status code of their kind and the message of
+// the innermost AppError, errors of fiber keep their own status code, and anything else is an internal server error.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return sendProblem(c, newProblem(c, fiberErr.Code, fiberErr.Message))
+	}
+
+	log.Printf("Error while handling request %s %s: %v", c.Method(), c.Path(), err)
+
+	kind := er.KindOf(err)
+	status, ok := errorKindStatus[kind]
+	if !ok {
+		status = fiber.StatusInternalServerError
+	}
+
+	detail := er.MessageOf(err)
+	if detail == "" || kind == er.Internal {
+		detail = errorKindDetail[kind]
+	}
+
+	return sendProblem(c, newProblem(c, status, detail))
+}
+
+// badRequest replies to a request that could not be parsed or failed validation.
+// Validation errors are listed per field.
+func badRequest(c *fiber.Ctx, err error) error {
+	p := newProblem(c, fiber.StatusBadRequest, err.Error())
+
+	if fieldErrors := validate.FieldErrors(err); fieldErrors != nil {
+		p.Detail = "The request failed validation"
+		p.Errors = fieldErrors
+	}
+
+	return sendProblem(c, p)
+}
+
+// newProblem creates the problem details of a failed request with the given status code
+func newProblem(c *fiber.Ctx, status int, detail string) problem.Problem {
+	return problem.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.OriginalURL(),
+		RequestID: requestID(c),
+	}
+}
+
+// sendProblem replies with the problem details as application/problem+json
+func sendProblem(c *fiber.Ctx, p problem.Problem) error {
+	return c.Status(p.Status).JSON(p, problem.ContentType)
+}
+
+// requestID returns the id the requestid middleware assigned to the request, if it is used
+func requestID(c *fiber.Ctx) string {
+	if id, ok := c.Locals("requestid").(string); ok {
+		return id
+	}
+	return c.GetRespHeader(fiber.HeaderXRequestID)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	er "kokal5296/errors"
+	"kokal5296/models/book"
+	"kokal5296/models/problem"
+	validate "kokal5296/web/validation"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestErrorHandler tests that errors returned by handlers are mapped to the status code of their kind
+func TestErrorHandler(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		err             error
+		expected        int
+		expectedMessage string
+	}{
+		{
+			name:            "Not found",
+			err:             er.NewKind("service - Get", er.NotFound, "Book with id 100 does not exist", pgx.ErrNoRows),
+			expected:        http.StatusNotFound,
+			expectedMessage: "Book with id 100 does not exist",
+		},
+		{
+			name:            "Conflict",
+			err:             er.NewKind("service - Create", er.Conflict, "Book with ISBN 9780261103573, already exists", nil),
+			expected:        http.StatusConflict,
+			expectedMessage: "Book with ISBN 9780261103573, already exists",
+		},
+		{
+			name:     "Validation",
+			err:      er.NewKind("service - Pay", er.Validation, "Amount 200 exceeds the outstanding balance of 100", nil),
+			expected: http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Precondition failed",
+			err:             er.NewKind("service - Update", er.PreconditionFailed, "Book with id 1 has changed, it is at version 2", nil),
+			expected:        http.StatusPreconditionFailed,
+			expectedMessage: "Book with id 1 has changed, it is at version 2",
+		},
+		{
+			name:            "Unique violation",
+			err:             &pgconn.PgError{Code: "23505", Message: "duplicate key value"},
+			expected:        http.StatusConflict,
+			expectedMessage: errorKindDetail[er.Conflict],
+		},
+		{
+			name:     "Deadline exceeded",
+			err:      fmt.Errorf("query: %w", context.DeadlineExceeded),
+			expected: http.StatusGatewayTimeout,
+		},
+		{
+			name:            "Internal",
+			err:             errors.New("unexpected"),
+			expected:        http.StatusInternalServerError,
+			expectedMessage: errorKindDetail[er.Internal],
+		},
+		{
+			name:     "Fiber error",
+			err:      fiber.ErrMethodNotAllowed,
+			expected: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+			app.Get("/", func(c *fiber.Ctx) error {
+				return er.Wrap("handler - Test", tt.err)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/?page=1", nil), -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+			assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+
+			var p problem.Problem
+			err = json.NewDecoder(resp.Body).Decode(&p)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, p.Status)
+			assert.Equal(t, http.StatusText(tt.expected), p.Title)
+			assert.Equal(t, "/?page=1", p.Instance)
+			if tt.expectedMessage != "" {
+				assert.Equal(t, tt.expectedMessage, p.Detail)
+			}
+		})
+	}
+}
+
+// TestBadRequest tests that validation errors are returned as problem details listing the invalid fields
+func TestBadRequest(t *testing.T) {
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(requestid.New())
+	app.Post("/", func(c *fiber.Ctx) error {
+		return badRequest(c, validate.ValidateBook(book.Book{Quantity: 1, ISBN: "123", Authors: []string{"Tolkien", "Tolkien"}}))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var p problem.Problem
+	err = json.NewDecoder(resp.Body).Decode(&p)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), p.RequestID)
+	assert.NotEmpty(t, p.RequestID)
+
+	rules := map[string]string{}
+	for _, fieldError := range p.Errors {
+		rules[fieldError.Field] = fieldError.Rule
+		assert.NotEmpty(t, fieldError.Message)
+	}
+	assert.Equal(t, map[string]string{"title": "required", "isbn": "isbn_checksum", "authors": "unique"}, rules)
+}
+
+// TestErrorKinds tests that service errors match the sentinel error of their kind
+func TestErrorKinds(t *testing.T) {
+
+	err := er.Wrap("service - Outer", er.NewKind("service - Inner", er.NotFound, "User with id 1 does not exist", pgx.ErrNoRows))
+	assert.True(t, errors.Is(err, er.ErrNotFound))
+	assert.False(t, errors.Is(err, er.ErrConflict))
+	assert.True(t, errors.Is(err, pgx.ErrNoRows))
+
+	wrapped := er.New("service - Update", "Error updating user", err)
+	assert.Equal(t, er.NotFound, er.KindOf(wrapped))
+	assert.True(t, errors.Is(er.Wrap("service - Query", context.DeadlineExceeded), er.ErrTimeout))
+}
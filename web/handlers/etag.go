@@ -0,0 +1,57 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of a resource at the given version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// sendWithETag replies with the resource as JSON and its entity tag, or with 304 Not Modified
+// if the client already has the resource at this version, as given in If-None-Match
+func sendWithETag(c *fiber.Ctx, resource interface{}, version int) error {
+	tag := etag(version)
+	c.Set(fiber.HeaderETag, tag)
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), tag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resource)
+}
+
+// parseIfMatch reads the version an update is made to from the If-Match header. Without it, required updates
+// fail with a fiber error with 428 Precondition Required and other updates apply to any version, returned as 0,
+// like If-Match: *. Entity tags that are not versions can never match, so they fail with 412 Precondition Failed.
+func parseIfMatch(c *fiber.Ctx, required bool) (int, error) {
+	value := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if value == "" && required {
+		return 0, fiber.NewError(fiber.StatusPreconditionRequired, "The If-Match header with the ETag of the resource is required")
+	}
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version < 1 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return 0, fiber.NewError(fiber.StatusPreconditionFailed, "The If-Match header does not match the ETag of the resource")
+	}
+
+	return version, nil
+}
+
+// etagMatches reports whether the list of entity tags of an If-None-Match header contains the tag, or is *.
+// Weak tags match by their opaque tag.
+func etagMatches(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
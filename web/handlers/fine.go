@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/fine"
+	"kokal5296/service"
+	validate "kokal5296/web/validation"
+	"log"
+	"strconv"
+)
+
+type FineApiStruct struct {
+	fineService service.FineService
+}
+
+// NewFineApiService creates a new instance of FineApiStruct, which implements the FineApi interface
+func NewFineApiService(fineService service.FineService) FineApi {
+	return &FineApiStruct{
+		fineService: fineService,
+	}
+}
+
+// GetAccount handles the request to get a user's balance and ledger entries
+func (s *FineApiStruct) GetAccount(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get user account")
+	funcName := handler + "GetAccount"
+	id := c.Params("id")
+
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	err = authorizeUser(c, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	account, err := s.fineService.GetAccount(c.Context(), userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(account)
+}
+
+// RecordPayment handles the request to record a payment towards a user's balance
+func (s *FineApiStruct) RecordPayment(c *fiber.Ctx) error {
+
+	log.Println("Requesting to record payment")
+	funcName := handler + "RecordPayment"
+
+	userId, entry, err := parseLedgerEntry(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	payment, err := s.fineService.RecordPayment(c.Context(), userId, entry.Amount, entry.Note)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(payment)
+}
+
+// WaiveFine handles the request to waive part of a user's balance
+func (s *FineApiStruct) WaiveFine(c *fiber.Ctx) error {
+
+	log.Println("Requesting to waive fine")
+	funcName := handler + "WaiveFine"
+
+	userId, entry, err := parseLedgerEntry(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	waiver, err := s.fineService.WaiveFine(c.Context(), userId, entry.Amount, entry.Note)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(waiver)
+}
+
+// parseLedgerEntry reads the user id from the path and validates the amount and note in the body
+func parseLedgerEntry(c *fiber.Ctx) (int, fine.LedgerEntry, error) {
+	var entry fine.LedgerEntry
+
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return 0, entry, err
+	}
+
+	err = json.Unmarshal(c.Body(), &entry)
+	if err != nil {
+		log.Printf("Error while unmarshalling ledger entry: %v", err)
+		return 0, entry, err
+	}
+
+	validateErr := validate.ValidateLedgerEntry(entry)
+	if validateErr != nil {
+		log.Printf("Error while validating ledger entry: %v", validateErr)
+		return 0, entry, validateErr
+	}
+
+	return userId, entry, nil
+}
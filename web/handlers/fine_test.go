@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/book"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/fine"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestFines tests the scenarios for charging late fines, paying and waiving them, and the borrowing limit
+func TestFines(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	loanConfig := config.DefaultLoanConfig()
+	loanConfig.FinePerDay = 50
+	loanConfig.MaxUnpaidBalance = 100
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, loanConfig)
+	fineService := service.NewFineService(dbService, userService)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+	fineApi := NewFineApiService(fineService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
+	app.Get("/user/:id/balance", fineApi.GetAccount)
+	app.Post("/user/:id/payments", fineApi.RecordPayment)
+	app.Post("/user/:id/waivers", fineApi.WaiveFine)
+
+	existingBooks := []book.Book{
+		{Title: "Lord of the Rings: Fellowship of the Ring", Quantity: 5},
+		{Title: "Lord of the Rings: Two Towers", Quantity: 5},
+		{Title: "Lord of the Rings: Return of the King", Quantity: 5},
+	}
+
+	for _, b := range existingBooks {
+		insertBook(t, dbService, b.Title, b.Quantity)
+	}
+
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2)", "Tine", "Kokalj")
+	assert.NoError(t, err)
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		body, _ := json.Marshal(input)
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	getAccount := func(t *testing.T) fine.Account {
+		resp := sendRequest("GET", "/user/1/balance", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var account fine.Account
+		err := json.NewDecoder(resp.Body).Decode(&account)
+		assert.NoError(t, err)
+		return account
+	}
+
+	t.Run("Returning a book late charges a fine", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id, due_date) VALUES (1, 1, NOW() - INTERVAL '3 days' + INTERVAL '1 hour')")
+		assert.NoError(t, err)
+
+		resp := sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		account := getAccount(t)
+		assert.Equal(t, 150, account.Balance)
+		assert.Len(t, account.Entries, 1)
+		assert.Equal(t, fine.KindFine, account.Entries[0].Kind)
+		assert.NotNil(t, account.Entries[0].BookBorrowID)
+	})
+
+	t.Run("Returning a book on time does not charge a fine", func(t *testing.T) {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id) VALUES (2, 1)")
+		assert.NoError(t, err)
+
+		resp := sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: 2, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		account := getAccount(t)
+		assert.Equal(t, 150, account.Balance)
+		assert.Len(t, account.Entries, 1)
+	})
+
+	t.Run("Borrowing is refused while the unpaid balance exceeds the limit", func(t *testing.T) {
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 3, UserID: 1})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		input    fine.LedgerEntry
+		expected int
+	}{
+		{
+			name:     "Pay more than the outstanding balance",
+			path:     "/user/1/payments",
+			input:    fine.LedgerEntry{Amount: 200},
+			expected: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Pay without an amount",
+			path:     "/user/1/payments",
+			input:    fine.LedgerEntry{Note: "cash"},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Pay a negative amount",
+			path:     "/user/1/payments",
+			input:    fine.LedgerEntry{Amount: -10},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Pay part of the balance",
+			path:     "/user/1/payments",
+			input:    fine.LedgerEntry{Amount: 100, Note: "cash"},
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "Waive part of the balance",
+			path:     "/user/1/waivers",
+			input:    fine.LedgerEntry{Amount: 30, Note: "first offence"},
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "Pay for a user that does not exist",
+			path:     "/user/100/payments",
+			input:    fine.LedgerEntry{Amount: 10},
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "Pay with invalid id format",
+			path:     "/user/invalid/payments",
+			input:    fine.LedgerEntry{Amount: 10},
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendRequest("POST", tt.path, tt.input)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+
+	t.Run("Borrowing is allowed once the balance is below the limit", func(t *testing.T) {
+		account := getAccount(t)
+		assert.Equal(t, 20, account.Balance)
+		assert.Len(t, account.Entries, 3)
+
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 3, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
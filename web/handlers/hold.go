@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/hold"
+	"kokal5296/service"
+	validate "kokal5296/web/validation"
+	"log"
+	"strconv"
+)
+
+type HoldApiStruct struct {
+	holdService service.HoldService
+}
+
+// NewHoldApiService creates a new instance of HoldApiStruct, which implements the HoldApi interface
+func NewHoldApiService(holdService service.HoldService) HoldApi {
+	return &HoldApiStruct{
+		holdService: holdService,
+	}
+}
+
+// PlaceHold handles the request to place a hold on a book
+func (s *HoldApiStruct) PlaceHold(c *fiber.Ctx) error {
+
+	log.Println("Requesting to place hold")
+	var newHold hold.Hold
+
+	funcName := handler + "PlaceHold"
+
+	err := json.Unmarshal(c.Body(), &newHold)
+	if err != nil {
+		log.Printf("Error while unmarshalling hold: %v", err)
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateHold(newHold)
+	if validateErr != nil {
+		log.Printf("Error while validating hold: %v", validateErr)
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeUser(c, newHold.UserID)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	placedHold, err := s.holdService.PlaceHold(c.Context(), newHold.BookID, newHold.UserID)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(placedHold)
+}
+
+// GetBookHolds handles the request to get the hold queue of a book
+func (s *HoldApiStruct) GetBookHolds(c *fiber.Ctx) error {
+
+	log.Println("Requesting to get holds of book")
+	funcName := handler + "GetBookHolds"
+	id := c.Params("id")
+
+	bookId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return badRequest(c, err)
+	}
+
+	holds, err := s.holdService.GetBookHolds(c.Context(), bookId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(holds)
+}
+
+// CancelHold handles the request to cancel a hold
+func (s *HoldApiStruct) CancelHold(c *fiber.Ctx) error {
+
+	log.Println("Requesting to cancel hold")
+	funcName := handler + "CancelHold"
+	id := c.Params("id")
+
+	holdId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	bookHold, err := s.holdService.GetHold(c.Context(), holdId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = authorizeUser(c, bookHold.UserID)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = s.holdService.CancelHold(c.Context(), holdId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Hold was successfully cancelled")
+}
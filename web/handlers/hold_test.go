@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	"kokal5296/models/book"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/hold"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHolds tests the scenarios for placing, fulfilling, expiring and cancelling holds
+func TestHolds(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	loanConfig := config.DefaultLoanConfig()
+
+	userService := service.NewUserService(dbService)
+	bookService := service.NewBookService(dbService)
+	bookBorrowService := service.NewBookBorrowService(dbService, bookService, userService, loanConfig)
+	holdService := service.NewHoldService(dbService, userService, loanConfig)
+	bookBorrowApi := NewBookBorrowApiService(bookBorrowService)
+	holdApi := NewHoldApiService(holdService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/book_borrow", bookBorrowApi.BorrowBook)
+	app.Put("/book_borrow", bookBorrowApi.ReturnBook)
+	app.Post("/hold", holdApi.PlaceHold)
+	app.Get("/book/:id/holds", holdApi.GetBookHolds)
+	app.Delete("/hold/:id", holdApi.CancelHold)
+
+	existingBooks := []book.Book{
+		{Title: "The Hobbit", Quantity: 1},
+		{Title: "The Silmarillion", Quantity: 3},
+	}
+
+	existingUsers := []user.User{
+		{FirstName: "Tine", LastName: "Kokalj"},
+		{FirstName: "Žan", LastName: "Horvat"},
+		{FirstName: "Luka", LastName: "Potočnik"},
+	}
+
+	for _, b := range existingBooks {
+		insertBook(t, dbService, b.Title, b.Quantity)
+	}
+
+	for _, u := range existingUsers {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2)", u.FirstName, u.LastName)
+		assert.NoError(t, err)
+	}
+
+	sendRequest := func(method, path string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	getHolds := func(t *testing.T, bookId int) []hold.Hold {
+		resp := sendRequest("GET", fmt.Sprintf("/book/%d/holds", bookId), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var holds []hold.Hold
+		err := json.NewDecoder(resp.Body).Decode(&holds)
+		assert.NoError(t, err)
+		return holds
+	}
+
+	resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 1})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tests := []struct {
+		name     string
+		input    hold.Hold
+		expected int
+	}{
+		{
+			name:     "Place a hold on an unavailable book",
+			input:    hold.Hold{BookID: 1, UserID: 2},
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "Place a second hold on an unavailable book",
+			input:    hold.Hold{BookID: 1, UserID: 3},
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "Place a duplicate hold",
+			input:    hold.Hold{BookID: 1, UserID: 2},
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Place a hold on a book the user has borrowed",
+			input:    hold.Hold{BookID: 1, UserID: 1},
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Place a hold on an available book",
+			input:    hold.Hold{BookID: 2, UserID: 2},
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Place a hold with a user that does not exist",
+			input:    hold.Hold{BookID: 1, UserID: 100},
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "Place a hold without a book",
+			input:    hold.Hold{UserID: 2},
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendRequest("POST", "/hold", tt.input)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+
+	t.Run("Returning a book makes the first hold ready for pickup", func(t *testing.T) {
+		resp := sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		holds := getHolds(t, 1)
+		assert.Len(t, holds, 2)
+		assert.Equal(t, 2, holds[0].UserID)
+		assert.Equal(t, hold.StatusReady, holds[0].Status)
+		assert.NotNil(t, holds[0].Expires_at)
+		assert.Equal(t, 3, holds[1].UserID)
+		assert.Equal(t, hold.StatusWaiting, holds[1].Status)
+	})
+
+	t.Run("Other users cannot borrow a reserved copy", func(t *testing.T) {
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 3})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 1})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Hold owner borrows the reserved copy", func(t *testing.T) {
+		resp := sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 2})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var status string
+		err := dbService.GetPool().QueryRow(context.Background(), "SELECT status FROM holds WHERE book_id = 1 AND user_id = 2").Scan(&status)
+		assert.NoError(t, err)
+		assert.Equal(t, hold.StatusFulfilled, status)
+
+		holds := getHolds(t, 1)
+		assert.Len(t, holds, 1)
+		assert.Equal(t, 3, holds[0].UserID)
+	})
+
+	t.Run("Expired hold releases the copy", func(t *testing.T) {
+		resp := sendRequest("PUT", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 2})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		holds := getHolds(t, 1)
+		assert.Len(t, holds, 1)
+		assert.Equal(t, hold.StatusReady, holds[0].Status)
+
+		_, err := dbService.GetPool().Exec(context.Background(), "UPDATE holds SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1", holds[0].ID)
+		assert.NoError(t, err)
+
+		resp = sendRequest("POST", "/book_borrow", book_borrow.BookBorrow{BookID: 1, UserID: 1})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var status string
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT status FROM holds WHERE id = $1", holds[0].ID).Scan(&status)
+		assert.NoError(t, err)
+		assert.Equal(t, hold.StatusExpired, status)
+	})
+
+	t.Run("Cancel a hold", func(t *testing.T) {
+		resp := sendRequest("POST", "/hold", hold.Hold{BookID: 1, UserID: 3})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var placedHold hold.Hold
+		err := json.NewDecoder(resp.Body).Decode(&placedHold)
+		assert.NoError(t, err)
+		assert.Equal(t, hold.StatusWaiting, placedHold.Status)
+
+		resp = sendRequest("DELETE", fmt.Sprintf("/hold/%d", placedHold.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = sendRequest("DELETE", fmt.Sprintf("/hold/%d", placedHold.ID), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = sendRequest("DELETE", "/hold/invalid", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		assert.Len(t, getHolds(t, 1), 0)
+	})
+}
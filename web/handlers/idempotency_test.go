@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	er "kokal5296/errors"
+	"kokal5296/models/idempotency"
+	"kokal5296/service"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestIdempotencyLease tests that a key still claimed by a request that never completed is taken over by a retry
+// once its lease has run out, and only by a retry of the same request
+func TestIdempotencyLease(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	idempotencyService := service.NewIdempotencyService(dbService, 24*time.Hour)
+	ctx := context.Background()
+
+	stored, err := idempotencyService.Begin(ctx, "user:1", "kiosk-1", "first")
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	t.Run("Retry while the request is being handled", func(t *testing.T) {
+		_, err := idempotencyService.Begin(ctx, "user:1", "kiosk-1", "first")
+		assert.Equal(t, er.Conflict, er.KindOf(err))
+	})
+
+	_, err = dbService.GetPool().Exec(ctx, "UPDATE idempotency_keys SET locked_at = NOW() - INTERVAL '1 hour' WHERE key = 'kiosk-1'")
+	assert.NoError(t, err)
+
+	t.Run("Different request after the lease ran out", func(t *testing.T) {
+		_, err := idempotencyService.Begin(ctx, "user:1", "kiosk-1", "second")
+		assert.Equal(t, er.Validation, er.KindOf(err))
+	})
+
+	t.Run("Retry after the lease ran out", func(t *testing.T) {
+		stored, err := idempotencyService.Begin(ctx, "user:1", "kiosk-1", "first")
+		assert.NoError(t, err)
+		assert.Nil(t, stored)
+
+		_, err = idempotencyService.Begin(ctx, "user:1", "kiosk-1", "first")
+		assert.Equal(t, er.Conflict, er.KindOf(err))
+	})
+
+	t.Run("Completed request is replayed", func(t *testing.T) {
+		err := idempotencyService.Complete(ctx, "user:1", "kiosk-1", idempotency.Response{Status: http.StatusCreated, Headers: map[string]string{}})
+		assert.NoError(t, err)
+
+		_, err = dbService.GetPool().Exec(ctx, "UPDATE idempotency_keys SET locked_at = NOW() - INTERVAL '1 hour' WHERE key = 'kiosk-1'")
+		assert.NoError(t, err)
+
+		stored, err := idempotencyService.Begin(ctx, "user:1", "kiosk-1", "first")
+		assert.NoError(t, err)
+		if assert.NotNil(t, stored) {
+			assert.Equal(t, http.StatusCreated, stored.Status)
+		}
+	})
+}
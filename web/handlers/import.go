@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"kokal5296/models/book"
+	"kokal5296/models/bulk"
+	"kokal5296/models/user"
+	validate "kokal5296/web/validation"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types of the uploads accepted by imports: CSV with a header row, or one JSON object per line
+const (
+	MIMETextCSV = "text/csv"
+	MIMENDJSON  = "application/x-ndjson"
+)
+
+// ndjsonTypes lists the media types that are accepted for uploads of one JSON object per line
+var ndjsonTypes = []string{MIMENDJSON, "application/jsonl", "application/x-jsonlines"}
+
+// authorSeparator separates the authors of a book in a single CSV field
+const authorSeparator = ";"
+
+// Columns of CSV imports, named like the fields of the JSON of a book and a user
+var (
+	bookColumns = []string{"title", "quantity", "isbn", "authors", "publisher", "publication_year", "language", "page_count"}
+	userColumns = []string{"first_name", "last_name", "tier", "blocked", "role", "email", "password"}
+)
+
+// csvFields holds the fields of a CSV row by the names of their columns
+type csvFields map[string]string
+
+// importFormat decides how to read an import from its content type, which must be CSV or NDJSON
+func importFormat(c *fiber.Ctx) (string, error) {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(string(c.Request().Header.ContentType()), ";")[0]))
+
+	switch {
+	case contentType == MIMETextCSV:
+		return MIMETextCSV, nil
+	case slices.Contains(ndjsonTypes, contentType):
+		return MIMENDJSON, nil
+	default:
+		message := fmt.Sprintf("Imports must be uploaded as %s or %s", MIMETextCSV, MIMENDJSON)
+		return "", fiber.NewError(fiber.StatusUnsupportedMediaType, message)
+	}
+}
+
+// parseImportOptions reads the mode query parameter, which is insert or upsert, and the dry_run query parameter
+func parseImportOptions(c *fiber.Ctx) (bulk.Options, error) {
+	options := bulk.Options{Mode: c.Query("mode", bulk.ModeInsert)}
+	if options.Mode != bulk.ModeInsert && options.Mode != bulk.ModeUpsert {
+		return options, fmt.Errorf("mode must be %s or %s", bulk.ModeInsert, bulk.ModeUpsert)
+	}
+
+	dryRun := c.Query("dry_run")
+	if dryRun != "" {
+		var err error
+		options.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			return options, fmt.Errorf("dry_run must be true or false")
+		}
+	}
+
+	return options, nil
+}
+
+// readImport reads the rows of an upload one at a time, in the given format. CSV rows are converted with fromCSV
+// and must only have the given columns, while NDJSON lines are unmarshalled, skipping blank lines.
+// Every row is checked, and may be normalized, by validateRow, and rows that cannot be read or fail validation are recorded
+// in the report instead of being returned. An error is only returned if the upload cannot be read at all.
+func readImport[T any](body io.Reader, format string, columns []string, fromCSV func(csvFields) (T, error),
+	validateRow func(*T) error, report *bulk.Report) ([]bulk.Row[T], error) {
+
+	var rows []bulk.Row[T]
+	add := func(line int, value T, err error) {
+		report.Rows++
+		if err == nil {
+			err = validateRow(&value)
+		}
+		if err != nil {
+			failRow(report, line, err)
+			return
+		}
+		rows = append(rows, bulk.Row[T]{Line: line, Value: value})
+	}
+
+	if format == MIMENDJSON {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			var value T
+			err := json.Unmarshal(data, &value)
+			add(line, value, err)
+		}
+		return rows, scanner.Err()
+	}
+
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, column := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if !slices.Contains(columns, header[i]) {
+			return nil, fmt.Errorf("unknown column %q, the columns are %s", header[i], strings.Join(columns, ", "))
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Rows++
+			failRow(report, parseErr.StartLine, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		fields := csvFields{}
+		for i, field := range record {
+			fields[header[i]] = strings.TrimSpace(field)
+		}
+		value, err := fromCSV(fields)
+		add(line, value, err)
+	}
+}
+
+// failRow records a row that could not be read or failed validation, listing the failed fields of validation errors
+func failRow(report *bulk.Report, line int, err error) {
+	if fieldErrors := validate.FieldErrors(err); fieldErrors != nil {
+		report.Fail(line, "The row failed validation", fieldErrors)
+		return
+	}
+	report.Fail(line, err.Error(), nil)
+}
+
+// mergeReports adds the outcome of the rows the service imported to the report of the rows that were read,
+// keeping the failed rows in the order of the upload
+func mergeReports(report *bulk.Report, imported *bulk.Report) *bulk.Report {
+	report.Mode = imported.Mode
+	report.DryRun = imported.DryRun
+	report.Created = imported.Created
+	report.Updated = imported.Updated
+	report.Failed += imported.Failed
+	report.Errors = append(report.Errors, imported.Errors...)
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	return report
+}
+
+// number reads a whole number field, which is 0 if empty
+func (f csvFields) number(name string) (int, error) {
+	if f[name] == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(f[name])
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a whole number", name)
+	}
+	return value, nil
+}
+
+// boolean reads a true or false field, which is nil if empty or not a column of the upload
+func (f csvFields) boolean(name string) (*bool, error) {
+	if f[name] == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(f[name])
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &value, nil
+}
+
+// list reads a field holding values separated by sep, leaving out empty values
+func (f csvFields) list(name string, sep string) []string {
+	var values []string
+	for _, value := range strings.Split(f[name], sep) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// bookFromCSV converts a CSV row to a book, with its authors separated by authorSeparator
+func bookFromCSV(f csvFields) (book.Book, error) {
+	newBook := book.Book{
+		Title:     f["title"],
+		ISBN:      f["isbn"],
+		Authors:   f.list("authors", authorSeparator),
+		Publisher: f["publisher"],
+		Language:  f["language"],
+	}
+
+	var err error
+	newBook.Quantity, err = f.number("quantity")
+	if err != nil {
+		return newBook, err
+	}
+	newBook.PublicationYear, err = f.number("publication_year")
+	if err != nil {
+		return newBook, err
+	}
+	newBook.PageCount, err = f.number("page_count")
+	if err != nil {
+		return newBook, err
+	}
+
+	return newBook, nil
+}
+
+// userFromCSV converts a CSV row to a user, leaving whether they are blocked unset if the field is empty
+func userFromCSV(f csvFields) (user.Import, error) {
+	newUser := user.Import{User: user.User{
+		FirstName: f["first_name"],
+		LastName:  f["last_name"],
+		Tier:      f["tier"],
+		Role:      f["role"],
+		Email:     f["email"],
+		Password:  f["password"],
+	}}
+
+	var err error
+	newUser.Blocked, err = f.boolean("blocked")
+	return newUser, err
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kokal5296/models/book"
+	"kokal5296/models/bulk"
+	"kokal5296/models/user"
+	validate "kokal5296/web/validation"
+	"strings"
+	"testing"
+)
+
+// TestReadImport tests reading and validating the rows of CSV and NDJSON uploads
+func TestReadImport(t *testing.T) {
+
+	validateBook := func(b *book.Book) error {
+		err := validate.ValidateBook(*b)
+		b.ISBN = validate.NormalizeISBN(b.ISBN)
+		return err
+	}
+
+	tests := []struct {
+		name          string
+		format        string
+		body          string
+		expectedError bool
+		expectedRows  []bulk.Row[book.Book]
+		expectedLines []int
+	}{
+		{
+			name:   "Read CSV",
+			format: MIMETextCSV,
+			body: "\ufefftitle, quantity,isbn,authors\n" +
+				"The Hobbit,3,0-261-10221-4,J. R. R. Tolkien\n" +
+				"\"Good Omens, or the Nice and Accurate Prophecies\",2,,Terry Pratchett; Neil Gaiman\n",
+			expectedRows: []bulk.Row[book.Book]{
+				{Line: 2, Value: book.Book{Title: "The Hobbit", Quantity: 3, ISBN: "9780261102217", Authors: []string{"J. R. R. Tolkien"}}},
+				{Line: 3, Value: book.Book{Title: "Good Omens, or the Nice and Accurate Prophecies", Quantity: 2, Authors: []string{"Terry Pratchett", "Neil Gaiman"}}},
+			},
+			expectedLines: []int{},
+		},
+		{
+			name:   "Read CSV with invalid rows",
+			format: MIMETextCSV,
+			body: "title,quantity\n" +
+				"The Hobbit,three\n" +
+				",1\n" +
+				"Dune,1,extra\n" +
+				"Emma,1\n",
+			expectedRows:  []bulk.Row[book.Book]{{Line: 5, Value: book.Book{Title: "Emma", Quantity: 1}}},
+			expectedLines: []int{2, 3, 4},
+		},
+		{
+			name:          "Read CSV with unknown column",
+			format:        MIMETextCSV,
+			body:          "title,quantity,shelf\nThe Hobbit,1,A1\n",
+			expectedError: true,
+		},
+		{
+			name:   "Read NDJSON",
+			format: MIMENDJSON,
+			body: `{"title": "The Hobbit", "quantity": 3}` + "\n\n" +
+				`{"title": "Dune", "quantity": "one"}` + "\n" +
+				`{"quantity": 1}` + "\n" +
+				`{"title": "Emma", "quantity": 1}`,
+			expectedRows: []bulk.Row[book.Book]{
+				{Line: 1, Value: book.Book{Title: "The Hobbit", Quantity: 3}},
+				{Line: 5, Value: book.Book{Title: "Emma", Quantity: 1}},
+			},
+			expectedLines: []int{3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &bulk.Report{}
+			rows, err := readImport(strings.NewReader(tt.body), tt.format, bookColumns, bookFromCSV, validateBook, report)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedRows, rows)
+
+			lines := []int{}
+			for _, rowError := range report.Errors {
+				lines = append(lines, rowError.Line)
+			}
+			assert.Equal(t, tt.expectedLines, lines)
+			assert.Equal(t, len(tt.expectedRows)+len(tt.expectedLines), report.Rows)
+			assert.Equal(t, len(tt.expectedLines), report.Failed)
+		})
+	}
+}
+
+// TestReadImportBlocked tests that users are only blocked or unblocked by an import that says so
+func TestReadImportBlocked(t *testing.T) {
+
+	validateUser := func(u *user.Import) error {
+		return validate.ValidateUser(u.User)
+	}
+	blocked := func(rows []bulk.Row[user.Import]) []*bool {
+		values := []*bool{}
+		for _, row := range rows {
+			values = append(values, row.Value.Blocked)
+		}
+		return values
+	}
+	yes, no := true, false
+
+	rows, err := readImport(strings.NewReader("first_name,last_name\nLuka,Kralj\n"), MIMETextCSV, userColumns, userFromCSV, validateUser, &bulk.Report{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*bool{nil}, blocked(rows))
+
+	rows, err = readImport(strings.NewReader("first_name,last_name,blocked\nLuka,Kralj,\nAna,Novak,true\nTine,Kokalj,false\n"), MIMETextCSV, userColumns, userFromCSV, validateUser, &bulk.Report{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*bool{nil, &yes, &no}, blocked(rows))
+
+	body := `{"first_name": "Luka", "last_name": "Kralj"}` + "\n" + `{"first_name": "Ana", "last_name": "Novak", "blocked": true}`
+	rows, err = readImport(strings.NewReader(body), MIMENDJSON, userColumns, userFromCSV, validateUser, &bulk.Report{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*bool{nil, &yes}, blocked(rows))
+}
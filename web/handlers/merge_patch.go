@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// mergePatchContentType is the media type of JSON merge patches, as described by RFC 7396
+const mergePatchContentType = "application/merge-patch+json"
+
+// checkMergePatchType returns a fiber error with 415 Unsupported Media Type unless the request is a merge patch.
+// Patches are accepted as application/merge-patch+json and as application/json.
+func checkMergePatchType(c *fiber.Ctx) error {
+	contentType := strings.TrimSpace(strings.SplitN(c.Get(fiber.HeaderContentType), ";", 2)[0])
+	if contentType != mergePatchContentType && contentType != fiber.MIMEApplicationJSON {
+		message := fmt.Sprintf("Content-Type must be %s", mergePatchContentType)
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, message)
+	}
+	return nil
+}
+
+// parseMergePatch reads a JSON merge patch of a resource onto patch, which must point to the zero value of the
+// resource, and returns the sorted names of the members it supplies, so only they are validated and updated.
+// Members set to null are left at their zero value, which clears optional fields. Only the members in patchable
+// can be supplied.
+func parseMergePatch(c *fiber.Ctx, patch interface{}, patchable []string) ([]string, error) {
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(c.Body(), &members)
+	if err != nil || members == nil {
+		return nil, fmt.Errorf("the merge patch must be a JSON object")
+	}
+
+	fields := make([]string, 0, len(members))
+	for name := range members {
+		if !slices.Contains(patchable, name) {
+			return nil, fmt.Errorf("%s cannot be patched, patchable fields are %s", name, strings.Join(patchable, ", "))
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	err = json.Unmarshal(c.Body(), patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"kokal5296/models/page"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of items in a page returned by default and at most
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// parsePageParams reads the limit, cursor, sort and filter query parameters of a list request.
+// The sort and filters must be allowed by the spec of the list, and any other query parameter is rejected.
+func parsePageParams(c *fiber.Ctx, spec page.Spec) (page.Params, error) {
+	params := page.Params{
+		Limit:   defaultPageLimit,
+		Sort:    spec.DefaultSort,
+		Filters: map[string]interface{}{},
+	}
+
+	var err error
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		if err != nil {
+			return
+		}
+		err = parsePageParam(&params, spec, string(key), string(value))
+	})
+	if err != nil {
+		return params, err
+	}
+
+	if params.Cursor != nil && params.Cursor.Sort != params.Sort {
+		return params, fmt.Errorf("cursor does not belong to the requested sort order")
+	}
+
+	return params, nil
+}
+
+// parseIncludeDeleted reads the include_deleted query parameter, which requests soft deleted items as well
+func parseIncludeDeleted(c *fiber.Ctx) (bool, error) {
+	value := c.Query("include_deleted")
+	if value == "" {
+		return false, nil
+	}
+
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("include_deleted must be true or false")
+	}
+
+	return include, nil
+}
+
+// parsePageParam reads a single query parameter of a list request into params
+func parsePageParam(params *page.Params, spec page.Spec, key string, value string) error {
+	switch key {
+	case "limit":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		params.Limit = limit
+	case "cursor":
+		cursor, err := page.DecodeCursor(value)
+		if err != nil {
+			return err
+		}
+		params.Cursor = cursor
+	case "include_deleted":
+		if !spec.SoftDelete {
+			return fmt.Errorf("unknown query parameter %s, filters are %s", key, strings.Join(specKeys(spec.Filters), ", "))
+		}
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		params.IncludeDeleted = include
+	case "sort":
+		if _, ok := spec.Sorts[strings.TrimPrefix(value, "-")]; !ok {
+			return fmt.Errorf("sort must be one of %s, optionally prefixed with - for descending order", strings.Join(specKeys(spec.Sorts), ", "))
+		}
+		params.Sort = value
+	default:
+		filter, ok := spec.Filters[key]
+		if !ok {
+			return fmt.Errorf("unknown query parameter %s, filters are %s", key, strings.Join(specKeys(spec.Filters), ", "))
+		}
+		switch filter.Type {
+		case "int":
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a number", key)
+			}
+			params.Filters[key] = number
+		case "bool":
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false", key)
+			}
+			params.Filters[key] = flag
+		case "time":
+			moment, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("%s must be a time in RFC 3339 format, such as 2024-05-01T10:00:00Z", key)
+			}
+			params.Filters[key] = moment
+		default:
+			params.Filters[key] = value
+		}
+	}
+
+	return nil
+}
+
+// specKeys returns the names of the sort columns or filters of a spec in alphabetical order
+func specKeys[T any](entries map[string]T) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
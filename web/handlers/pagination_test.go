@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/models/book"
+	"kokal5296/models/page"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestPagination tests paging through lists with cursors, sorting and filtering
+func TestPagination(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userApi := NewUserApiService(service.NewUserService(dbService))
+	bookApi := NewBookApiService(service.NewBookService(dbService))
+
+	app := newTestApp(testAdmin)
+	app.Get("/users", userApi.GetAllUsers)
+	app.Get("/books", bookApi.GetAllBooks)
+
+	titles := []string{"Dune", "Anathem", "Emma", "Beloved", "Carrie"}
+	for _, title := range titles {
+		insertBook(t, dbService, title, 1)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name, tier) VALUES ($1, $2, $3)", fmt.Sprintf("User%d", i), "Reader", user.TierPremium)
+		assert.NoError(t, err)
+	}
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name, email) VALUES ($1, $2, $3), ($4, $5, $6)",
+		"Tine", "Kokalj", "tine@example.com", "Ana_Marija", "Novak", "ana@example.com")
+	assert.NoError(t, err)
+
+	getUsers := func(t *testing.T, query url.Values) (int, page.Page[user.User]) {
+		req := httptest.NewRequest("GET", "/users?"+query.Encode(), nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var usersPage page.Page[user.User]
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&usersPage)
+			assert.NoError(t, err)
+		}
+		return resp.StatusCode, usersPage
+	}
+
+	getBooks := func(t *testing.T, query url.Values) (int, page.Page[book.Book]) {
+		req := httptest.NewRequest("GET", "/books?"+query.Encode(), nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var booksPage page.Page[book.Book]
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&booksPage)
+			assert.NoError(t, err)
+		}
+		return resp.StatusCode, booksPage
+	}
+
+	t.Run("Follow cursors through all pages", func(t *testing.T) {
+		var retrieved []string
+		query := url.Values{"limit": {"2"}, "sort": {"-title"}}
+		for pages := 0; pages < 10; pages++ {
+			status, booksPage := getBooks(t, query)
+			assert.Equal(t, http.StatusOK, status)
+			assert.LessOrEqual(t, len(booksPage.Items), 2)
+			for _, b := range booksPage.Items {
+				retrieved = append(retrieved, b.Title)
+			}
+			if booksPage.NextCursor == "" {
+				break
+			}
+			query.Set("cursor", booksPage.NextCursor)
+		}
+
+		assert.Equal(t, []string{"Emma", "Dune", "Carrie", "Beloved", "Anathem"}, retrieved)
+	})
+
+	t.Run("Last page has no next cursor", func(t *testing.T) {
+		status, booksPage := getBooks(t, url.Values{"limit": {"5"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Len(t, booksPage.Items, 5)
+		assert.Empty(t, booksPage.NextCursor)
+	})
+
+	t.Run("Filter books", func(t *testing.T) {
+		status, booksPage := getBooks(t, url.Values{"title": {"em"}})
+		assert.Equal(t, http.StatusOK, status)
+		if assert.Len(t, booksPage.Items, 2) {
+			assert.Equal(t, "Anathem", booksPage.Items[0].Title)
+			assert.Equal(t, "Emma", booksPage.Items[1].Title)
+		}
+	})
+
+	t.Run("Follow cursors through users without an email", func(t *testing.T) {
+		for _, sort := range []string{"email", "-email"} {
+			var retrieved []string
+			query := url.Values{"limit": {"1"}, "sort": {sort}}
+			for pages := 0; pages < 10; pages++ {
+				status, usersPage := getUsers(t, query)
+				assert.Equal(t, http.StatusOK, status)
+				for _, u := range usersPage.Items {
+					retrieved = append(retrieved, u.FirstName)
+				}
+				if usersPage.NextCursor == "" {
+					break
+				}
+				query.Set("cursor", usersPage.NextCursor)
+			}
+
+			withEmail := []string{"Ana_Marija", "Tine"}
+			if sort == "-email" {
+				withEmail = []string{"Tine", "Ana_Marija"}
+			}
+			assert.Equal(t, append(withEmail, "User0", "User1", "User2"), retrieved, sort)
+		}
+	})
+
+	t.Run("Filter users by a name with a wildcard", func(t *testing.T) {
+		status, usersPage := getUsers(t, url.Values{"name": {"_"}})
+		assert.Equal(t, http.StatusOK, status)
+		if assert.Len(t, usersPage.Items, 1) {
+			assert.Equal(t, "Ana_Marija", usersPage.Items[0].FirstName)
+		}
+
+		status, usersPage = getUsers(t, url.Values{"name": {"%"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, usersPage.Items)
+	})
+
+	t.Run("Filter users", func(t *testing.T) {
+		status, usersPage := getUsers(t, url.Values{"tier": {"premium"}, "limit": {"2"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Len(t, usersPage.Items, 2)
+		assert.NotEmpty(t, usersPage.NextCursor)
+		for _, u := range usersPage.Items {
+			assert.Equal(t, user.TierPremium, u.Tier)
+		}
+	})
+
+	dune := "Dune"
+	invalid := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "Sort by a column that is not allowed", query: url.Values{"sort": {"quantity"}}},
+		{name: "Filter that is not allowed", query: url.Values{"quantity": {"1"}}},
+		{name: "Limit too large", query: url.Values{"limit": {"1000"}}},
+		{name: "Malformed cursor", query: url.Values{"cursor": {"not a cursor"}}},
+		{name: "Cursor of another sort order", query: url.Values{"cursor": {page.Cursor{Sort: "title", Value: &dune, ID: 1}.Encode()}}},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := getBooks(t, tt.query)
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	}
+}
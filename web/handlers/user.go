@@ -1,9 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/gofiber/fiber/v2"
 	er "kokal5296/errors"
+	"kokal5296/models/bulk"
 	"kokal5296/models/user"
 	"kokal5296/service"
 	validate "kokal5296/web/validation"
@@ -34,25 +36,34 @@ func (s *UserApiStruct) CreateUser(c *fiber.Ctx) error {
 	err := json.Unmarshal(c.Body(), &newUser)
 	if err != nil {
 		log.Printf("Error while unmarshalling user: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateUser(newUser)
 	if validateErr != nil {
 		log.Printf("Error while validating user: %v", validateErr)
-		return c.Status(fiber.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeRole(c, newUser.Role)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	err = authorizeMembership(c, newUser.Tier, newUser.Blocked)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
 	err = s.userService.CreateUser(c.Context(), newUser)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusCreated).SendString("User was successfully created")
 }
 
-// GetUser handles the request to get a user by id
+// GetUser handles the request to get a user by id, returned with its ETag
 func (s *UserApiStruct) GetUser(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get user by id")
@@ -62,34 +73,57 @@ func (s *UserApiStruct) GetUser(c *fiber.Ctx) error {
 	userId, err := strconv.Atoi(id)
 	if err != nil {
 		log.Printf("Error while converting id to int: %v", err)
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
+	}
+
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = authorizeUser(c, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
-	user, err := s.userService.GetUser(c.Context(), userId)
+	err = authorizeIncludeDeleted(c, includeDeleted)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusBadRequest).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
-	return c.Status(http.StatusOK).JSON(user)
+	user, err := s.userService.GetUser(c.Context(), userId, includeDeleted)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return sendWithETag(c, user, user.Version)
 }
 
-// GetAllUsers handles the request to get all users
+// GetAllUsers handles the request to get a page of users
 func (s *UserApiStruct) GetAllUsers(c *fiber.Ctx) error {
 
 	log.Println("Requesting to get all users")
 	funcName := handler + "GetAllUsers"
 
-	users, err := s.userService.GetAllUsers(c.Context())
+	params, err := parsePageParams(c, service.UserListSpec)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusBadRequest).SendString(er.UnwrapError(err).Error())
+		return badRequest(c, err)
+	}
+
+	err = authorizeIncludeDeleted(c, params.IncludeDeleted)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	users, err := s.userService.GetAllUsers(c.Context(), params)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(users)
 }
 
-// UpdateUser handles the request to update a user
+// UpdateUser handles the request to update a user, which requires the ETag of the user in If-Match
 func (s *UserApiStruct) UpdateUser(c *fiber.Ctx) error {
 
 	log.Println("Requesting to update user")
@@ -100,28 +134,85 @@ func (s *UserApiStruct) UpdateUser(c *fiber.Ctx) error {
 
 	userId, err := strconv.Atoi(id)
 	if err != nil {
-		return c.Status(http.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	err = json.Unmarshal(c.Body(), &updateUser)
 	if err != nil {
-		return c.Status(http.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	validateErr := validate.ValidateUser(updateUser)
 	if validateErr != nil {
-		return c.Status(http.StatusBadRequest).SendString(validateErr.Error())
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeUser(c, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
-	err = s.userService.UpdateUser(c.Context(), updateUser, userId)
+	version, err := parseIfMatch(c, true)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return err
+	}
+
+	err = s.userService.UpdateUser(c.Context(), updateUser, userId, version)
+	if err != nil {
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusOK).SendString("User was updated successfully")
 }
 
+// PatchUser handles the request to change some fields of a user with a JSON merge patch.
+// If-Match is optional, without it the patch is applied to the current version of the user.
+func (s *UserApiStruct) PatchUser(c *fiber.Ctx) error {
+
+	log.Println("Requesting to patch user")
+	var patch user.User
+	funcName := handler + "PatchUser"
+
+	id := c.Params("id")
+
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = checkMergePatchType(c)
+	if err != nil {
+		return err
+	}
+
+	fields, err := parseMergePatch(c, &patch, service.UserPatchFields)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateUserPatch(patch, fields)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+
+	err = authorizeUser(c, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	version, err := parseIfMatch(c, false)
+	if err != nil {
+		return err
+	}
+
+	err = s.userService.PatchUser(c.Context(), userId, version, patch, fields)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).SendString("User was patched successfully")
+}
+
 // DeleteUser handles the request to delete a user
 func (s *UserApiStruct) DeleteUser(c *fiber.Ctx) error {
 
@@ -131,14 +222,131 @@ func (s *UserApiStruct) DeleteUser(c *fiber.Ctx) error {
 
 	userId, err := strconv.Atoi(id)
 	if err != nil {
-		return c.Status(http.StatusBadRequest).SendString(err.Error())
+		return badRequest(c, err)
 	}
 
 	err = s.userService.DeleteUser(c.Context(), userId)
 	if err != nil {
-		er.Wrap(funcName, err)
-		return c.Status(fiber.StatusInternalServerError).SendString(er.UnwrapError(err).Error())
+		return er.Wrap(funcName, err)
 	}
 
 	return c.Status(http.StatusOK).SendString("User was successfully deleted")
 }
+
+// RestoreUser handles the request to restore a deleted user
+func (s *UserApiStruct) RestoreUser(c *fiber.Ctx) error {
+
+	log.Println("Requesting to restore user")
+	id := c.Params("id")
+	funcName := handler + "RestoreUser"
+
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = s.userService.RestoreUser(c.Context(), userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).SendString("User was successfully restored")
+}
+
+// UpdateMembership handles the request to change a user's membership tier and blocked status
+func (s *UserApiStruct) UpdateMembership(c *fiber.Ctx) error {
+
+	log.Println("Requesting to update user membership")
+	var membership user.Membership
+	funcName := handler + "UpdateMembership"
+
+	id := c.Params("id")
+
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = json.Unmarshal(c.Body(), &membership)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateMembership(membership)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+
+	err = s.userService.UpdateMembership(c.Context(), membership, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).SendString("User membership was updated successfully")
+}
+
+// UpdateRole handles the request to change the role of a user
+func (s *UserApiStruct) UpdateRole(c *fiber.Ctx) error {
+
+	log.Println("Requesting to update user role")
+	var roleChange user.RoleChange
+	funcName := handler + "UpdateRole"
+
+	id := c.Params("id")
+
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	err = json.Unmarshal(c.Body(), &roleChange)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	validateErr := validate.ValidateRoleChange(roleChange)
+	if validateErr != nil {
+		return badRequest(c, validateErr)
+	}
+
+	err = s.userService.UpdateRole(c.Context(), roleChange.Role, userId)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).SendString("User role was updated successfully")
+}
+
+// ImportUsers handles the request to import users from an upload in CSV or NDJSON, replying with a report
+// of the rows that were imported and the rows that failed
+func (s *UserApiStruct) ImportUsers(c *fiber.Ctx) error {
+
+	log.Println("Requesting to import users")
+	funcName := handler + "ImportUsers"
+
+	format, err := importFormat(c)
+	if err != nil {
+		return err
+	}
+
+	options, err := parseImportOptions(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	report := &bulk.Report{Errors: []bulk.RowError{}}
+	rows, err := readImport(bytes.NewReader(c.Body()), format, userColumns, userFromCSV, func(u *user.Import) error {
+		return validate.ValidateUser(u.User)
+	}, report)
+	if err != nil {
+		log.Printf("Error while reading users: %v", err)
+		return badRequest(c, err)
+	}
+
+	imported, err := s.userService.ImportUsers(c.Context(), rows, options)
+	if err != nil {
+		return er.Wrap(funcName, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(mergeReports(report, imported))
+}
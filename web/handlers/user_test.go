@@ -5,13 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"kokal5296/database"
+	"kokal5296/models/bulk"
+	"kokal5296/models/page"
 	"kokal5296/models/user"
 	"kokal5296/service"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -25,8 +27,8 @@ const (
 func SetupTestDB() (database.DatabaseService, func(), error) {
 	dbService := database.NewDatabaseService()
 
-	// Connect to the main "postgres" database for admin tasks
-	adminConn, err := dbService.NewDatabase(connStr, "postgres")
+	// Connect to the main "postgres" database for admin tasks, without migrating it
+	adminConn, err := database.NewDatabaseService().Connect(connStr, "postgres")
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect to postgres database: %v", err)
 	}
@@ -49,7 +51,7 @@ func SetupTestDB() (database.DatabaseService, func(), error) {
 		time.Sleep(100 * time.Millisecond)
 
 		// Reconnect to the "postgres" database to terminate active connections and drop the test database
-		dropConn, err := database.NewDatabaseService().NewDatabase(connStr, "postgres")
+		dropConn, err := database.NewDatabaseService().Connect(connStr, "postgres")
 		if err != nil {
 			fmt.Printf("Failed to connect to drop test database: %v\n", err)
 			return
@@ -85,7 +87,7 @@ func TestCreateUser(t *testing.T) {
 	userService := service.NewUserService(dbService)
 	userApi := NewUserApiService(userService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Post("/users", userApi.CreateUser)
 
 	tests := []struct {
@@ -115,7 +117,7 @@ func TestCreateUser(t *testing.T) {
 		{
 			name:           "Duplicate User",
 			input:          user.User{FirstName: "Tine", LastName: "Kokalj"},
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusConflict,
 			expectedCount:  1,
 		},
 		{
@@ -153,10 +155,10 @@ func TestGetUser(t *testing.T) {
 	userService := service.NewUserService(dbService)
 	userApi := NewUserApiService(userService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/users/:id", userApi.GetUser)
 
-	existingUser := user.User{FirstName: "Tine", LastName: "Kokalj"}
+	existingUser := user.User{FirstName: "Tine", LastName: "Kokalj", Tier: user.TierStandard}
 	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", existingUser.FirstName, existingUser.LastName).Scan(&existingUser.ID)
 	assert.NoError(t, err)
 
@@ -175,7 +177,7 @@ func TestGetUser(t *testing.T) {
 		{
 			name:           "User Not Found",
 			input:          "9999",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusNotFound,
 			expectedUser:   nil,
 		},
 		{
@@ -213,7 +215,7 @@ func TestGetAllUsers(t *testing.T) {
 	userService := service.NewUserService(dbService)
 	userApi := NewUserApiService(userService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Get("/users", userApi.GetAllUsers)
 
 	t.Run("Retrieve all users when users exist", func(t *testing.T) {
@@ -233,8 +235,9 @@ func TestGetAllUsers(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var retrievedUsers []user.User
-		err = json.NewDecoder(resp.Body).Decode(&retrievedUsers)
+		var retrievedUsersPage page.Page[user.User]
+		err = json.NewDecoder(resp.Body).Decode(&retrievedUsersPage)
+		retrievedUsers := retrievedUsersPage.Items
 		assert.NoError(t, err)
 		assert.Len(t, retrievedUsers, len(existingUsers))
 
@@ -254,8 +257,9 @@ func TestGetAllUsers(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var retrievedUsers []user.User
-		err = json.NewDecoder(resp.Body).Decode(&retrievedUsers)
+		var retrievedUsersPage page.Page[user.User]
+		err = json.NewDecoder(resp.Body).Decode(&retrievedUsersPage)
+		retrievedUsers := retrievedUsersPage.Items
 		assert.NoError(t, err)
 		assert.Empty(t, retrievedUsers)
 	})
@@ -271,7 +275,7 @@ func TestUpdateUser(t *testing.T) {
 	userService := service.NewUserService(dbService)
 	userApi := NewUserApiService(userService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Put("/users/:id", userApi.UpdateUser)
 
 	existingUser := user.User{FirstName: "Tine", LastName: "Kokalj"}
@@ -295,7 +299,7 @@ func TestUpdateUser(t *testing.T) {
 			name:           "Duplicate User",
 			input:          user.User{FirstName: "Gašper", LastName: "Zajc"},
 			id:             fmt.Sprint(existingUser.ID),
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusConflict,
 			expectedCount:  1,
 		},
 		{
@@ -316,7 +320,7 @@ func TestUpdateUser(t *testing.T) {
 			name:           "User Not Found",
 			input:          user.User{FirstName: "Tine", LastName: "Kokalj"},
 			id:             "9999",
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusNotFound,
 			expectedCount:  1,
 		},
 		{
@@ -333,6 +337,7 @@ func TestUpdateUser(t *testing.T) {
 			requestBody, _ := json.Marshal(tt.input)
 			req := httptest.NewRequest("PUT", "/users/"+tt.id, bytes.NewReader(requestBody))
 			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", "*")
 
 			resp, err := app.Test(req, -1)
 			assert.NoError(t, err)
@@ -347,6 +352,181 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+// TestPatchUser tests the scenarios for changing some fields of a user with a merge patch.
+func TestPatchUser(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	userApi := NewUserApiService(userService)
+
+	app := newTestApp(testAdmin)
+	app.Patch("/users/:id", userApi.PatchUser)
+
+	var userId int
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Tine', 'Kokalj') RETURNING id").Scan(&userId)
+	assert.NoError(t, err)
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Luka', 'Zajc')")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		id                string
+		patch             string
+		expectedStatus    int
+		expectedFirstName string
+		expectedLastName  string
+	}{
+		{
+			name:              "Patch Last Name",
+			id:                fmt.Sprint(userId),
+			patch:             `{"last_name": "Novak"}`,
+			expectedStatus:    http.StatusOK,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+		{
+			name:              "Patch Unchanged Name",
+			id:                fmt.Sprint(userId),
+			patch:             `{"first_name": "Tine"}`,
+			expectedStatus:    http.StatusOK,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+		{
+			name:              "Duplicate User",
+			id:                fmt.Sprint(userId),
+			patch:             `{"first_name": "Luka", "last_name": "Zajc"}`,
+			expectedStatus:    http.StatusConflict,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+		{
+			name:              "Null First Name",
+			id:                fmt.Sprint(userId),
+			patch:             `{"first_name": null}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+		{
+			name:              "Field Not Patchable",
+			id:                fmt.Sprint(userId),
+			patch:             `{"role": "admin"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+		{
+			name:              "User Not Found",
+			id:                "9999",
+			patch:             `{"first_name": "Gašper"}`,
+			expectedStatus:    http.StatusNotFound,
+			expectedFirstName: "Tine",
+			expectedLastName:  "Novak",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/users/"+tt.id, strings.NewReader(tt.patch))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var firstName, lastName string
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT first_name, last_name FROM users WHERE id = $1", userId).Scan(&firstName, &lastName)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedFirstName, firstName)
+			assert.Equal(t, tt.expectedLastName, lastName)
+		})
+	}
+}
+
+// TestUserETag tests that users are returned with an ETag, which updates must match.
+func TestUserETag(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	userApi := NewUserApiService(userService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/users/:id", userApi.GetUser)
+	app.Put("/users/:id", userApi.UpdateUser)
+	app.Put("/users/:id/membership", userApi.UpdateMembership)
+
+	var userId int
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ('Tine', 'Kokalj') RETURNING id").Scan(&userId)
+	assert.NoError(t, err)
+
+	sendRequest := func(method string, path string, ifMatch string, input interface{}) *http.Response {
+		var body []byte
+		if input != nil {
+			body, _ = json.Marshal(input)
+		}
+		req := httptest.NewRequest(method, fmt.Sprintf("/users/%d%s", userId, path), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := sendRequest("GET", "", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	tag := resp.Header.Get("ETag")
+
+	t.Run("Not modified", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/users/%d", userId), nil)
+		req.Header.Set("If-None-Match", `W/"0", `+tag)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("Membership change moves the version", func(t *testing.T) {
+		resp := sendRequest("PUT", "/membership", "", user.Membership{Tier: user.TierPremium})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = sendRequest("PUT", "", tag, user.User{FirstName: "Gašper", LastName: "Zajc"})
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("Update with current ETag", func(t *testing.T) {
+		resp := sendRequest("GET", "", "", nil)
+		tag = resp.Header.Get("ETag")
+
+		resp = sendRequest("PUT", "", tag, user.User{FirstName: "Gašper", LastName: "Zajc"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Update keeping the name", func(t *testing.T) {
+		resp := sendRequest("GET", "", "", nil)
+		tag = resp.Header.Get("ETag")
+
+		resp = sendRequest("PUT", "", tag, user.User{FirstName: "Gašper", LastName: "Zajc"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = sendRequest("PUT", "", tag, user.User{FirstName: "Gašper", LastName: "Zajc"})
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("Invalid If-Match", func(t *testing.T) {
+		resp := sendRequest("PUT", "", "abc", user.User{FirstName: "Luka", LastName: "Potočnik"})
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+}
+
 // TestDeleteUser tests the scenarios for deleting a user.
 func TestDeleteUser(t *testing.T) {
 
@@ -357,13 +537,20 @@ func TestDeleteUser(t *testing.T) {
 	userService := service.NewUserService(dbService)
 	userApi := NewUserApiService(userService)
 
-	app := fiber.New()
+	app := newTestApp(testAdmin)
 	app.Delete("/users/:id", userApi.DeleteUser)
 
 	existingUser := user.User{FirstName: "Tine", LastName: "Kokalj"}
 	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", existingUser.FirstName, existingUser.LastName).Scan(&existingUser.ID)
 	assert.NoError(t, err)
 
+	borrowingUser := user.User{FirstName: "Gašper", LastName: "Zajc"}
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", borrowingUser.FirstName, borrowingUser.LastName).Scan(&borrowingUser.ID)
+	assert.NoError(t, err)
+	bookId := insertBook(t, dbService, "The Hobbit", 1)
+	_, err = dbService.GetPool().Exec(context.Background(), "INSERT INTO book_borrows (book_id, user_id) VALUES ($1, $2)", bookId, borrowingUser.ID)
+	assert.NoError(t, err)
+
 	tests := []struct {
 		name           string
 		id             string
@@ -374,19 +561,31 @@ func TestDeleteUser(t *testing.T) {
 			name:           "Invalid ID format",
 			id:             "abc",
 			expectedStatus: http.StatusBadRequest,
-			expectedCount:  1,
+			expectedCount:  2,
 		},
 		{
 			name:           "User Not Found",
 			id:             "9999",
-			expectedStatus: http.StatusInternalServerError,
-			expectedCount:  1,
+			expectedStatus: http.StatusNotFound,
+			expectedCount:  2,
+		},
+		{
+			name:           "User With Open Loans",
+			id:             fmt.Sprint(borrowingUser.ID),
+			expectedStatus: http.StatusConflict,
+			expectedCount:  2,
 		},
 		{
 			name:           "Successful User Deletion",
 			id:             fmt.Sprint(existingUser.ID),
 			expectedStatus: http.StatusOK,
-			expectedCount:  0,
+			expectedCount:  1,
+		},
+		{
+			name:           "User Already Deleted",
+			id:             fmt.Sprint(existingUser.ID),
+			expectedStatus: http.StatusNotFound,
+			expectedCount:  1,
 		},
 	}
 
@@ -398,9 +597,325 @@ func TestDeleteUser(t *testing.T) {
 			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
 
 			var userCount int
-			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE id = $1", existingUser.ID).Scan(&userCount)
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE deleted_at IS NULL").Scan(&userCount)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedCount, userCount)
 		})
 	}
+
+	t.Run("Deleted user is kept", func(t *testing.T) {
+		var userCount int
+		err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&userCount)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, userCount)
+	})
+}
+
+// TestRestoreUser tests the scenarios for restoring a deleted user and getting deleted users.
+func TestRestoreUser(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	userApi := NewUserApiService(userService)
+
+	app := newTestApp(testAdmin)
+	app.Get("/users", userApi.GetAllUsers)
+	app.Get("/users/:id", userApi.GetUser)
+	app.Post("/users/:id/restore", userApi.RestoreUser)
+
+	deletedUser := user.User{FirstName: "Tine", LastName: "Kokalj"}
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name, deleted_at) VALUES ($1, $2, NOW()) RETURNING id", deletedUser.FirstName, deletedUser.LastName).Scan(&deletedUser.ID)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "Deleted User Not Found",
+			method:         "GET",
+			path:           fmt.Sprintf("/users/%d", deletedUser.ID),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Deleted User Included",
+			method:         "GET",
+			path:           fmt.Sprintf("/users/%d?include_deleted=true", deletedUser.ID),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid Include Deleted",
+			method:         "GET",
+			path:           "/users?include_deleted=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Restore User Not Found",
+			method:         "POST",
+			path:           "/users/9999/restore",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Successful User Restore",
+			method:         "POST",
+			path:           fmt.Sprintf("/users/%d/restore", deletedUser.ID),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "User Not Deleted",
+			method:         "POST",
+			path:           fmt.Sprintf("/users/%d/restore", deletedUser.ID),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Restored User Found",
+			method:         "GET",
+			path:           fmt.Sprintf("/users/%d", deletedUser.ID),
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	t.Run("Only admins include deleted users", func(t *testing.T) {
+		patron := &user.User{ID: deletedUser.ID, FirstName: "Tine", LastName: "Kokalj", Role: user.RolePatron}
+		patronApp := newTestApp(patron)
+		patronApp.Get("/users/:id", userApi.GetUser)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/users/%d?include_deleted=true", deletedUser.ID), nil)
+		resp, err := patronApp.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+// TestUpdateMembership tests the scenarios for changing a user's membership tier and blocked status.
+func TestUpdateMembership(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	userApi := NewUserApiService(userService)
+
+	app := newTestApp(testAdmin)
+	app.Put("/users/:id/membership", userApi.UpdateMembership)
+
+	existingUser := user.User{FirstName: "Tine", LastName: "Kokalj"}
+	err = dbService.GetPool().QueryRow(context.Background(), "INSERT INTO users (first_name, last_name) VALUES ($1, $2) RETURNING id", existingUser.FirstName, existingUser.LastName).Scan(&existingUser.ID)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		input           user.Membership
+		id              string
+		expectedStatus  int
+		expectedTier    string
+		expectedBlocked bool
+	}{
+		{
+			name:            "Upgrade Tier",
+			input:           user.Membership{Tier: user.TierPremium},
+			id:              fmt.Sprint(existingUser.ID),
+			expectedStatus:  http.StatusOK,
+			expectedTier:    user.TierPremium,
+			expectedBlocked: false,
+		},
+		{
+			name:            "Block User",
+			input:           user.Membership{Tier: user.TierPremium, Blocked: true},
+			id:              fmt.Sprint(existingUser.ID),
+			expectedStatus:  http.StatusOK,
+			expectedTier:    user.TierPremium,
+			expectedBlocked: true,
+		},
+		{
+			name:            "Unknown Tier",
+			input:           user.Membership{Tier: "gold"},
+			id:              fmt.Sprint(existingUser.ID),
+			expectedStatus:  http.StatusBadRequest,
+			expectedTier:    user.TierPremium,
+			expectedBlocked: true,
+		},
+		{
+			name:            "Missing Tier",
+			input:           user.Membership{Blocked: false},
+			id:              fmt.Sprint(existingUser.ID),
+			expectedStatus:  http.StatusBadRequest,
+			expectedTier:    user.TierPremium,
+			expectedBlocked: true,
+		},
+		{
+			name:            "User Not Found",
+			input:           user.Membership{Tier: user.TierStaff},
+			id:              "9999",
+			expectedStatus:  http.StatusNotFound,
+			expectedTier:    user.TierPremium,
+			expectedBlocked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestBody, _ := json.Marshal(tt.input)
+			req := httptest.NewRequest("PUT", "/users/"+tt.id+"/membership", bytes.NewReader(requestBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var membership user.Membership
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT tier, blocked FROM users WHERE id = $1", existingUser.ID).Scan(&membership.Tier, &membership.Blocked)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTier, membership.Tier)
+			assert.Equal(t, tt.expectedBlocked, membership.Blocked)
+		})
+	}
+}
+
+// TestImportUsers tests importing users from CSV and NDJSON in insert, upsert and dry run mode
+func TestImportUsers(t *testing.T) {
+
+	dbService, teardown, err := SetupTestDB()
+	assert.NoError(t, err)
+	defer teardown()
+
+	userService := service.NewUserService(dbService)
+	userApi := NewUserApiService(userService)
+
+	app := newTestApp(testAdmin)
+	app.Post("/users/import", userApi.ImportUsers)
+
+	_, err = dbService.GetPool().Exec(context.Background(), `INSERT INTO users (first_name, last_name, email, deleted_at)
+		VALUES ('Ana', 'Novak', 'ana@example.com', NULL), ('Old', 'Reader', 'old@example.com', NOW())`)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		path               string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedReport     bulk.Report
+		expectedLines      []int
+		expectedUsers      int
+	}{
+		{
+			name:               "Import users with unknown column",
+			path:               "/users/import",
+			contentType:        MIMETextCSV,
+			body:               "first_name,last_name,password_hash\nLuka,Kralj,x\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:        "Import users from CSV",
+			path:        "/users/import",
+			contentType: MIMETextCSV,
+			body: "first_name,last_name,email,password,tier,role\n" +
+				"Luka,Kralj,,,premium,\n" +
+				"Ana,Novak,ANA@example.com,correct horse,,\n" +
+				"Maja,Horvat,maja@example.com,short,,\n" +
+				"Luka,Kralj,,,,\n" +
+				"Old,Reader,old@example.com,correct horse,,\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeInsert, Rows: 5, Created: 1, Failed: 4},
+			expectedLines:      []int{3, 4, 5, 6},
+			expectedUsers:      3,
+		},
+		{
+			name:        "Upsert users from NDJSON",
+			path:        "/users/import?mode=upsert",
+			contentType: MIMENDJSON,
+			body: `{"first_name": "Ana", "last_name": "Novak", "email": "ana@example.com", "password": "correct horse", "tier": "premium"}` + "\n" +
+				`{"first_name": "Luka", "last_name": "Kralj", "blocked": true}` + "\n" +
+				`{"first_name": "Old", "last_name": "Reader", "email": "OLD@example.com", "password": "correct horse"}` + "\n" +
+				`{"first_name": "Luka", "last_name": "Kralj", "email": "luka@example.com", "password": "correct horse"}` + "\n" +
+				`{"first_name": "Nina", "last_name": "Zupan"}` + "\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeUpsert, Rows: 5, Created: 1, Updated: 2, Failed: 2},
+			expectedLines:      []int{3, 4},
+			expectedUsers:      4,
+		},
+		{
+			name:               "Upsert users from CSV without the blocked column",
+			path:               "/users/import?mode=upsert",
+			contentType:        MIMETextCSV,
+			body:               "first_name,last_name,tier\nLuka,Kralj,staff\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeUpsert, Rows: 1, Updated: 1},
+			expectedLines:      []int{},
+			expectedUsers:      4,
+		},
+		{
+			name:               "Import users in dry run",
+			path:               "/users/import?mode=upsert&dry_run=true",
+			contentType:        MIMENDJSON,
+			body:               `{"first_name": "Tina", "last_name": "Kos", "email": "tina@example.com", "password": "correct horse"}` + "\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     bulk.Report{Mode: bulk.ModeUpsert, DryRun: true, Rows: 1, Created: 1},
+			expectedLines:      []int{},
+			expectedUsers:      4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+
+			if tt.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var report bulk.Report
+			err = json.NewDecoder(resp.Body).Decode(&report)
+			assert.NoError(t, err)
+
+			lines := []int{}
+			for _, rowError := range report.Errors {
+				lines = append(lines, rowError.Line)
+			}
+			assert.Equal(t, tt.expectedLines, lines)
+
+			report.Errors = nil
+			assert.Equal(t, tt.expectedReport, report)
+
+			var count int
+			err = dbService.GetPool().QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedUsers, count)
+		})
+	}
+
+	var tier, lukaTier string
+	var hasPassword, blocked bool
+	query := `SELECT tier, password_hash IS NOT NULL FROM users WHERE email = 'ana@example.com'`
+	err = dbService.GetPool().QueryRow(context.Background(), query).Scan(&tier, &hasPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, user.TierPremium, tier)
+	assert.True(t, hasPassword)
+
+	// The last upsert of Luka had no blocked column, which keeps them blocked
+	query = `SELECT tier, blocked FROM users WHERE first_name = 'Luka' AND last_name = 'Kralj'`
+	err = dbService.GetPool().QueryRow(context.Background(), query).Scan(&lukaTier, &blocked)
+	assert.NoError(t, err)
+	assert.Equal(t, user.TierStaff, lukaTier)
+	assert.True(t, blocked)
 }
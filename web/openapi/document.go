@@ -0,0 +1,350 @@
+package openapi
+
+import (
+	"fmt"
+	"kokal5296/models/page"
+	"kokal5296/models/problem"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the OpenAPI specification the documents conform to
+const Version = "3.1.0"
+
+// Auth describes how an operation is authenticated
+type Auth int
+
+const (
+	// AuthUserOrApiKey operations require an access token or an API key
+	AuthUserOrApiKey Auth = iota
+	// AuthUser operations require an access token and are closed to API keys
+	AuthUser
+	// AuthOptional operations accept an access token or an API key, but do not require one
+	AuthOptional
+	// AuthNone operations are public
+	AuthNone
+)
+
+// Names of the security schemes of the document
+const (
+	bearerScheme = "bearerAuth"
+	apiKeyScheme = "apiKeyAuth"
+)
+
+// Operation describes a route of the API: its method and path as registered with fiber, e.g. /user/:id,
+// the bodies of its request and successful response, and its query and header parameters.
+// Request and Response are values of the types of the bodies; a nil Response is a plain text message.
+// ContentType overrides the media type of the response, and MergePatch marks a JSON merge patch request.
+// Upload lists the media types of a file that is uploaded as the request body instead of a Request.
+// List adds the pagination, sort and filter parameters of a list, ETag the entity tag of the response,
+// Location the location header of a created resource and Idempotent the Idempotency-Key header.
+type Operation struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Access      string
+	Auth        Auth
+	Request     interface{}
+	MergePatch  bool
+	Upload      []string
+	Status      int
+	Response    interface{}
+	ContentType string
+	List        *page.Spec
+	ETag        bool
+	Location    bool
+	Idempotent  bool
+	Parameters  []Parameter
+}
+
+// Parameter represents a path, query or header parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Document represents an OpenAPI document
+type Document struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       Info                                   `json:"info"`
+	Servers    []Server                               `json:"servers,omitempty"`
+	Paths      map[string]map[string]*operationObject `json:"paths"`
+	Components components                             `json:"components"`
+}
+
+// Info represents the title, description and version of the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server represents the URL the paths of the document are relative to
+type Server struct {
+	URL string `json:"url"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type operationObject struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Media types of request and response bodies
+const (
+	jsonType       = "application/json"
+	mergePatchType = "application/merge-patch+json"
+	textType       = "text/plain"
+)
+
+// pathParam matches the parameters of fiber paths, e.g. :id
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// New creates the document of the API mounted at basePath with the given operations, whose paths are relative to it.
+// Every struct in a request or response body is described by a schema component.
+func New(info Info, basePath string, operations []Operation) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Servers: []Server{{URL: basePath}},
+		Paths:   map[string]map[string]*operationObject{},
+		Components: components{
+			SecuritySchemes: map[string]securityScheme{
+				bearerScheme: {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "An access token returned by /auth/login"},
+				apiKeyScheme: {Type: "apiKey", In: "header", Name: "Authorization", Description: "An API key, sent as Authorization: ApiKey <key>"},
+			},
+		},
+	}
+
+	gen := &schemas{components: map[string]*Schema{}}
+	problemSchema := gen.schemaOf(reflect.TypeOf(problem.Problem{}))
+
+	for _, operation := range operations {
+		path := pathParam.ReplaceAllString(operation.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*operationObject{}
+		}
+		doc.Paths[path][strings.ToLower(operation.Method)] = gen.operation(operation, problemSchema)
+	}
+
+	doc.Components.Schemas = gen.components
+	return doc
+}
+
+// HasOperation reports whether the document describes the operation with the method and fiber path
+func (d *Document) HasOperation(method, path string) bool {
+	_, ok := d.Paths[pathParam.ReplaceAllString(path, "{$1}")][strings.ToLower(method)]
+	return ok
+}
+
+// operation returns the operation object of an operation
+func (g *schemas) operation(operation Operation, problemSchema *Schema) *operationObject {
+	object := &operationObject{
+		Summary:     operation.Summary,
+		Description: operation.Access,
+		OperationID: operationID(operation.Method, operation.Path),
+		Responses:   map[string]response{},
+		Security:    security(operation.Auth),
+	}
+	if operation.Tag != "" {
+		object.Tags = []string{operation.Tag}
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(operation.Path, -1) {
+		object.Parameters = append(object.Parameters, Parameter{Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "integer"}})
+	}
+	if operation.List != nil {
+		object.Parameters = append(object.Parameters, listParameters(*operation.List)...)
+	}
+	if operation.ETag {
+		object.Parameters = append(object.Parameters, HeaderParam("If-None-Match", "Replies with 304 Not Modified if the entity tag of the resource matches"))
+	}
+	if operation.Idempotent {
+		object.Parameters = append(object.Parameters, HeaderParam("Idempotency-Key",
+			"A unique key of the request. Retries with the same key replay the first response instead of repeating the request."))
+	}
+	for _, parameter := range operation.Parameters {
+		object.Parameters = setParameter(object.Parameters, parameter)
+	}
+
+	if operation.Request != nil {
+		contentType := jsonType
+		if operation.MergePatch {
+			contentType = mergePatchType
+		}
+		object.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{contentType: {Schema: g.schemaOf(reflect.TypeOf(operation.Request))}},
+		}
+	}
+	if len(operation.Upload) > 0 {
+		object.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{}}
+		for _, contentType := range operation.Upload {
+			object.RequestBody.Content[contentType] = mediaType{Schema: &Schema{Type: "string"}}
+		}
+	}
+
+	status := operation.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := response{Description: http.StatusText(status)}
+	switch {
+	case operation.ContentType != "":
+		success.Content = map[string]mediaType{operation.ContentType: {Schema: &Schema{Type: "string"}}}
+	case operation.Response != nil:
+		success.Content = map[string]mediaType{jsonType: {Schema: g.schemaOf(reflect.TypeOf(operation.Response))}}
+	default:
+		success.Content = map[string]mediaType{textType: {Schema: &Schema{Type: "string"}}}
+	}
+	if operation.ETag {
+		success.Headers = map[string]header{"ETag": {Description: "The version of the resource", Schema: &Schema{Type: "string"}}}
+		object.Responses[fmt.Sprint(http.StatusNotModified)] = response{Description: http.StatusText(http.StatusNotModified)}
+	}
+	if operation.Location {
+		success.Headers = map[string]header{"Location": {Description: "The path of the created resource", Schema: &Schema{Type: "string"}}}
+	}
+	object.Responses[fmt.Sprint(status)] = success
+
+	object.Responses["default"] = response{
+		Description: "The problem details of a failed request",
+		Content:     map[string]mediaType{problem.ContentType: {Schema: problemSchema}},
+	}
+
+	return object
+}
+
+// QueryParam returns a query parameter of the given type
+func QueryParam(name, schemaType, description string) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: schemaType}}
+}
+
+// HeaderParam returns an optional header parameter
+func HeaderParam(name, description string) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: &Schema{Type: "string"}}
+}
+
+// setParameter adds a parameter to the parameters, replacing a parameter of the same name and location,
+// so that an operation can describe a filter of its list in more detail
+func setParameter(parameters []Parameter, parameter Parameter) []Parameter {
+	for i, existing := range parameters {
+		if existing.Name == parameter.Name && existing.In == parameter.In {
+			parameters[i] = parameter
+			return parameters
+		}
+	}
+	return append(parameters, parameter)
+}
+
+// listParameters returns the query parameters of a list with the spec
+func listParameters(spec page.Spec) []Parameter {
+	sorts := []string{}
+	for _, name := range sortedKeys(spec.Sorts) {
+		sorts = append(sorts, name, "-"+name)
+	}
+
+	parameters := []Parameter{
+		QueryParam("limit", "integer", "Number of items in the page"),
+		QueryParam("cursor", "string", "The next_cursor of the previous page"),
+		{Name: "sort", In: "query", Description: "Sort column, prefixed with - for descending order",
+			Schema: &Schema{Type: "string", Enum: sorts, Default: spec.DefaultSort}},
+	}
+	if spec.SoftDelete {
+		parameters = append(parameters, QueryParam("include_deleted", "boolean", "Include soft deleted items, for admins"))
+	}
+
+	for _, name := range sortedKeys(spec.Filters) {
+		parameter := QueryParam(name, "string", "Filter")
+		switch spec.Filters[name].Type {
+		case "int":
+			parameter.Schema.Type = "integer"
+		case "bool":
+			parameter.Schema.Type = "boolean"
+		case "time":
+			parameter.Schema.Format = "date-time"
+		}
+		parameters = append(parameters, parameter)
+	}
+
+	return parameters
+}
+
+// security returns the security requirements of an operation. An empty requirement makes authentication optional.
+func security(auth Auth) []map[string][]string {
+	bearer := map[string][]string{bearerScheme: {}}
+	apiKey := map[string][]string{apiKeyScheme: {}}
+
+	switch auth {
+	case AuthUser:
+		return []map[string][]string{bearer}
+	case AuthOptional:
+		return []map[string][]string{{}, bearer, apiKey}
+	case AuthNone:
+		return []map[string][]string{}
+	default:
+		return []map[string][]string{bearer, apiKey}
+	}
+}
+
+// operationID returns a unique id of an operation from its method and path, e.g. get_user_id_loans
+func operationID(method, path string) string {
+	replacer := strings.NewReplacer("/", "_", ":", "", ".", "_")
+	return strings.ToLower(method) + strings.TrimSuffix(replacer.Replace(path), "_")
+}
+
+// sortedKeys returns the keys of a map in order, so that the document is stable
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
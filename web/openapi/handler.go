@@ -0,0 +1,41 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"html/template"
+)
+
+//go:embed swagger.html
+var swaggerPage string
+
+var swaggerTemplate = template.Must(template.New("swagger").Parse(swaggerPage))
+
+// Handler serves the document as JSON. The document is marshalled once, as it does not change.
+func Handler(doc *Document) fiber.Handler {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		panic("Cannot marshal the OpenAPI document: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(fiber.StatusOK).Send(body)
+	}
+}
+
+// UIHandler serves the Swagger UI page, which shows the document served at specURL
+func UIHandler(title, specURL string) fiber.Handler {
+	var page bytes.Buffer
+	err := swaggerTemplate.Execute(&page, struct{ Title, SpecURL string }{title, specURL})
+	if err != nil {
+		panic("Cannot render the Swagger UI page: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Status(fiber.StatusOK).Send(page.Bytes())
+	}
+}
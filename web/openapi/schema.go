@@ -0,0 +1,203 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema represents the JSON Schema of a request or response body, parameter or component
+type Schema struct {
+	Ref              string             `json:"$ref,omitempty"`
+	Type             interface{}        `json:"type,omitempty"`
+	Format           string             `json:"format,omitempty"`
+	Description      string             `json:"description,omitempty"`
+	Properties       map[string]*Schema `json:"properties,omitempty"`
+	Required         []string           `json:"required,omitempty"`
+	Items            *Schema            `json:"items,omitempty"`
+	Enum             []string           `json:"enum,omitempty"`
+	Default          interface{}        `json:"default,omitempty"`
+	MinLength        *int               `json:"minLength,omitempty"`
+	MaxLength        *int               `json:"maxLength,omitempty"`
+	Minimum          *int               `json:"minimum,omitempty"`
+	Maximum          *int               `json:"maximum,omitempty"`
+	ExclusiveMinimum *int               `json:"exclusiveMinimum,omitempty"`
+	MinItems         *int               `json:"minItems,omitempty"`
+	MaxItems         *int               `json:"maxItems,omitempty"`
+	UniqueItems      bool               `json:"uniqueItems,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas generates the schemas of Go types, collecting the schemas of structs as components that are referenced
+type schemas struct {
+	components map[string]*Schema
+}
+
+// schemaOf returns the schema of values of the type. Pointers are nullable, and structs are referenced by their name.
+func (g *schemas) schemaOf(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{Description: "Any JSON value"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := g.schemaOf(t.Elem())
+		if typeName, ok := schema.Type.(string); ok {
+			schema.Type = []string{typeName, "null"}
+		}
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil
+			g.components[name] = g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema returns the object schema of a struct, with a property for each field that is marshalled to JSON.
+// Fields of embedded structs are properties of the struct itself, as they are in JSON.
+func (g *schemas) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded := g.structSchema(field.Type)
+			for name, property := range embedded.Properties {
+				schema.Properties[name] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.schemaOf(field.Type)
+		if applyValidation(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+
+	return schema
+}
+
+// applyValidation adds the constraints of the validator tags of a field to its schema and reports whether
+// the field is required. Tags after dive apply to the items of a slice.
+func applyValidation(schema *Schema, tag string) bool {
+	required := false
+
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "" {
+			continue
+		}
+		if rule == "dive" {
+			if schema.Items != nil {
+				applyValidation(schema.Items, strings.Join(rules[i+1:], ","))
+			}
+			break
+		}
+
+		name, param, _ := strings.Cut(rule, "=")
+		number, numberErr := strconv.Atoi(param)
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "unique":
+			schema.UniqueItems = true
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		case "isbn_checksum":
+			schema.Description = "ISBN-10 or ISBN-13, with or without hyphens"
+		case "gt":
+			if numberErr == nil {
+				schema.ExclusiveMinimum = &number
+			}
+		case "min", "max":
+			if numberErr == nil {
+				applyBound(schema, name == "min", number)
+			}
+		}
+	}
+
+	return required
+}
+
+// applyBound sets the minimum or maximum of a field, which is its length for strings and its number of items for arrays
+func applyBound(schema *Schema, isMin bool, bound int) {
+	switch schema.Type {
+	case "string":
+		if isMin {
+			schema.MinLength = &bound
+		} else {
+			schema.MaxLength = &bound
+		}
+	case "array":
+		if isMin {
+			schema.MinItems = &bound
+		} else {
+			schema.MaxItems = &bound
+		}
+	default:
+		if isMin {
+			schema.Minimum = &bound
+		} else {
+			schema.Maximum = &bound
+		}
+	}
+}
+
+// componentName returns the name of the component of a struct type. Instances of generic types are named
+// after their type argument, e.g. page.Page[book.Book] is BookPage.
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	generic, argument, found := strings.Cut(name, "[")
+	if !found {
+		return name
+	}
+
+	argument = strings.TrimSuffix(argument, "]")
+	if i := strings.LastIndex(argument, "."); i >= 0 {
+		argument = argument[i+1:]
+	}
+	return argument + generic
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/service"
+	api "kokal5296/web/handlers"
+	"strings"
+)
+
+const middleware = "middleware - "
+
+// challenge is sent in the WWW-Authenticate header of refused requests
+const challenge = "Bearer, ApiKey"
+
+// Authenticate returns a middleware that requires a bearer access token or an API key. The user the token
+// was issued to is stored in the locals of the request, where handlers can read it with api.AuthenticatedUser,
+// and the API key is stored where they can read it with api.AuthenticatedApiKey.
+func Authenticate(authService service.AuthService, apiKeyService service.ApiKeyService) fiber.Handler {
+	return authenticate(authService, apiKeyService, true)
+}
+
+// AuthenticateIfPresent returns a middleware like Authenticate that also lets requests without an
+// Authorization header through, unauthenticated. Requests with an invalid token or key are still refused.
+func AuthenticateIfPresent(authService service.AuthService, apiKeyService service.ApiKeyService) fiber.Handler {
+	return authenticate(authService, apiKeyService, false)
+}
+
+// authenticate returns the authentication middleware, requiring a token or key when required is set
+func authenticate(authService service.AuthService, apiKeyService service.ApiKeyService, required bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		funcName := middleware + "Authenticate"
+
+		header := c.Get(fiber.HeaderAuthorization)
+		if header == "" && !required {
+			return c.Next()
+		}
+
+		scheme, credential, found := strings.Cut(header, " ")
+		if !found || credential == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, challenge)
+			return er.NewKind(funcName, er.Unauthorized, "Missing bearer token or API key", nil)
+		}
+
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			authenticated, err := authService.Authenticate(c.Context(), credential)
+			if err != nil {
+				if er.KindOf(err) == er.Unauthorized {
+					c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				}
+				return er.Wrap(funcName, err)
+			}
+			api.SetAuthenticatedUser(c, authenticated)
+
+		case strings.EqualFold(scheme, "ApiKey"):
+			key, err := apiKeyService.AuthenticateApiKey(c.Context(), credential)
+			if err != nil {
+				if er.KindOf(err) == er.Unauthorized {
+					c.Set(fiber.HeaderWWWAuthenticate, "ApiKey")
+				}
+				return er.Wrap(funcName, err)
+			}
+			api.SetAuthenticatedApiKey(c, key)
+
+		default:
+			c.Set(fiber.HeaderWWWAuthenticate, challenge)
+			return er.NewKind(funcName, er.Unauthorized, "Unsupported authorization scheme", nil)
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	er "kokal5296/errors"
+	"kokal5296/models/api_key"
+	"kokal5296/models/auth"
+	"kokal5296/models/user"
+	api "kokal5296/web/handlers"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubAuthService authenticates the single access token it knows
+type stubAuthService struct{}
+
+func (stubAuthService) Login(ctx context.Context, credentials auth.Credentials) (*auth.TokenPair, error) {
+	return nil, nil
+}
+
+func (stubAuthService) Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error) {
+	return nil, nil
+}
+
+func (stubAuthService) Authenticate(ctx context.Context, accessToken string) (*user.User, error) {
+	if accessToken != "valid" {
+		return nil, er.NewKind("stub - Authenticate", er.Unauthorized, "Invalid token", nil)
+	}
+	return &user.User{ID: 1, FirstName: "Tine", LastName: "Kokalj"}, nil
+}
+
+// stubApiKeyService authenticates the single API key it knows
+type stubApiKeyService struct{}
+
+func (stubApiKeyService) CreateApiKey(ctx context.Context, newKey api_key.ApiKey, createdBy *int) (*api_key.ApiKey, error) {
+	return nil, nil
+}
+
+func (stubApiKeyService) GetApiKeys(ctx context.Context) ([]api_key.ApiKey, error) {
+	return nil, nil
+}
+
+func (stubApiKeyService) RevokeApiKey(ctx context.Context, keyId int) error {
+	return nil
+}
+
+func (stubApiKeyService) AuthenticateApiKey(ctx context.Context, key string) (*api_key.ApiKey, error) {
+	if key != "bbk_valid" {
+		return nil, er.NewKind("stub - AuthenticateApiKey", er.Unauthorized, "Invalid API key", nil)
+	}
+	return &api_key.ApiKey{ID: 1, Name: "Catalog sync", Scopes: []string{api_key.ScopeCatalogRead}}, nil
+}
+
+// TestAuthenticate tests that the middleware only lets requests with a valid bearer token or API key through
+func TestAuthenticate(t *testing.T) {
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(Authenticate(stubAuthService{}, stubApiKeyService{}))
+	app.Get("/me", func(c *fiber.Ctx) error {
+		if key := api.AuthenticatedApiKey(c); key != nil {
+			return c.JSON(key)
+		}
+		return c.JSON(api.AuthenticatedUser(c))
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{name: "Valid token", authorization: "Bearer valid", expected: http.StatusOK},
+		{name: "Missing header", authorization: "", expected: http.StatusUnauthorized},
+		{name: "Wrong scheme", authorization: "Basic valid", expected: http.StatusUnauthorized},
+		{name: "Invalid token", authorization: "Bearer invalid", expected: http.StatusUnauthorized},
+		{name: "Valid API key", authorization: "ApiKey bbk_valid", expected: http.StatusOK},
+		{name: "Invalid API key", authorization: "ApiKey bbk_invalid", expected: http.StatusUnauthorized},
+		{name: "API key as bearer token", authorization: "Bearer bbk_valid", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me", nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+			if tt.expected == http.StatusUnauthorized {
+				assert.NotEmpty(t, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"kokal5296/config"
+	"net/http"
+)
+
+// Deprecated returns a middleware for routes that are kept as aliases of the routes of a version of the API.
+// It replies with a Deprecation header with the time the aliases were deprecated (RFC 9745), a Sunset header
+// with the time they are removed (RFC 8594) and a Link to the same route under successorPath.
+func Deprecated(cfg config.ApiConfig, successorPath string) fiber.Handler {
+	deprecation := fmt.Sprintf("@%d", cfg.LegacyDeprecatedAt.Unix())
+	sunset := cfg.LegacySunsetAt.UTC().Format(http.TimeFormat)
+
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", deprecation)
+		c.Set("Sunset", sunset)
+		c.Append(fiber.HeaderLink, fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPath, c.OriginalURL()))
+		return c.Next()
+	}
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	er "kokal5296/errors"
+	"kokal5296/models/audit"
+	"kokal5296/models/idempotency"
+	"kokal5296/service"
+	"log"
+)
+
+// replayedHeaders lists the headers of a response that are stored with it and replayed
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderLocation, fiber.HeaderETag}
+
+// Idempotent returns a middleware that makes requests with an Idempotency-Key header safe to retry.
+// The first request with a key is handled and its response stored, and retries with the same key get the stored
+// response, marked with the Idempotent-Replayed header, without being handled again. Keys are scoped to the
+// authenticated user or API key, so it must run after the authentication middleware. Unauthenticated requests
+// ignore the header, as there is nothing that tells their clients apart.
+// Reusing a key for a different request fails with 422 Unprocessable Entity. Responses to failures of the server
+// are not stored, so that those requests can be retried. Safe methods ignore the header.
+func Idempotent(idempotencyService service.IdempotencyService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		funcName := middleware + "Idempotent"
+
+		key := c.Get(idempotency.HeaderKey)
+		scope, authenticated := idempotencyScope(c)
+		if key == "" || !authenticated ||
+			c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead || c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+		if len(key) > idempotency.MaxKeyLength {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", idempotency.HeaderKey, idempotency.MaxKeyLength))
+		}
+
+		stored, err := idempotencyService.Begin(c.Context(), scope, key, requestFingerprint(c))
+		if err != nil {
+			return er.Wrap(funcName, err)
+		}
+		if stored != nil {
+			for name, value := range stored.Headers {
+				c.Set(name, value)
+			}
+			c.Set(idempotency.HeaderReplayed, "true")
+			return c.Status(stored.Status).Send(stored.Body)
+		}
+
+		// Errors are handled here instead of by the app, so that the problem details are stored as well
+		err = c.Next()
+		if err != nil {
+			err = c.App().ErrorHandler(c, err)
+			if err != nil {
+				releaseIdempotencyKey(c, idempotencyService, scope, key)
+				return err
+			}
+		}
+
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			releaseIdempotencyKey(c, idempotencyService, scope, key)
+			return nil
+		}
+
+		response := idempotency.Response{
+			Status:  status,
+			Headers: map[string]string{},
+			Body:    append([]byte(nil), c.Response().Body()...),
+		}
+		for _, name := range replayedHeaders {
+			if value := c.GetRespHeader(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+
+		err = idempotencyService.Complete(c.Context(), scope, key, response)
+		if err != nil {
+			log.Printf("Error storing response for idempotency key: %v", err)
+			releaseIdempotencyKey(c, idempotencyService, scope, key)
+		}
+
+		return nil
+	}
+}
+
+// releaseIdempotencyKey releases the key of a request whose response is not stored
+func releaseIdempotencyKey(c *fiber.Ctx, idempotencyService service.IdempotencyService, scope string, key string) {
+	err := idempotencyService.Release(c.Context(), scope, key)
+	if err != nil {
+		log.Printf("Error releasing idempotency key: %v", err)
+	}
+}
+
+// idempotencyScope returns the scope of the idempotency keys of the client making the request, which is the
+// authenticated user or API key, and reports whether the request is authenticated at all
+func idempotencyScope(c *fiber.Ctx) (string, bool) {
+	actor := audit.ActorFrom(c.Context())
+	if actor.ID == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%d", actor.Type, *actor.ID), true
+}
+
+// requestFingerprint returns the SHA-256 hash of the method, path and body of the request,
+// which tells a retry of a request apart from a different request with the same key
+func requestFingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	er "kokal5296/errors"
+	"kokal5296/models/idempotency"
+	api "kokal5296/web/handlers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubIdempotencyService keeps idempotency keys in memory, with the semantics of the service
+type stubIdempotencyService struct {
+	mu      sync.Mutex
+	entries map[string]*stubIdempotencyEntry
+}
+
+type stubIdempotencyEntry struct {
+	fingerprint string
+	response    *idempotency.Response
+}
+
+func newStubIdempotencyService() *stubIdempotencyService {
+	return &stubIdempotencyService{entries: map[string]*stubIdempotencyEntry{}}
+}
+
+func (s *stubIdempotencyService) Begin(ctx context.Context, scope string, key string, fingerprint string) (*idempotency.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[scope+"/"+key]
+	if !ok {
+		s.entries[scope+"/"+key] = &stubIdempotencyEntry{fingerprint: fingerprint}
+		return nil, nil
+	}
+	if entry.fingerprint != fingerprint {
+		return nil, er.NewKind("stub - Begin", er.Validation, "The Idempotency-Key was already used for a different request", nil)
+	}
+	if entry.response == nil {
+		return nil, er.NewKind("stub - Begin", er.Conflict, "A request with the Idempotency-Key is still being processed", nil)
+	}
+	return entry.response, nil
+}
+
+func (s *stubIdempotencyService) Complete(ctx context.Context, scope string, key string, response idempotency.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[scope+"/"+key].response = &response
+	return nil
+}
+
+func (s *stubIdempotencyService) Release(ctx context.Context, scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, scope+"/"+key)
+	return nil
+}
+
+func (s *stubIdempotencyService) PurgeExpired(ctx context.Context) error {
+	return nil
+}
+
+// TestIdempotent tests that requests with an Idempotency-Key are handled once and their responses replayed to retries
+func TestIdempotent(t *testing.T) {
+
+	calls := 0
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(Authenticate(stubAuthService{}, stubApiKeyService{}), Idempotent(newStubIdempotencyService()))
+	app.Post("/book_borrow", func(c *fiber.Ctx) error {
+		calls++
+		c.Location("/loans/1")
+		return c.Status(fiber.StatusCreated).SendString("Book was successfully borrowed")
+	})
+	app.Post("/fail", func(c *fiber.Ctx) error {
+		calls++
+		if strings.Contains(string(c.Body()), "conflict") {
+			return er.NewKind("test", er.Conflict, "Book is not available", nil)
+		}
+		return er.NewKind("test", er.Unavailable, "", nil)
+	})
+
+	sendRequest := func(path, key, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer valid")
+		if key != "" {
+			req.Header.Set(idempotency.HeaderKey, key)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Retry replays the response", func(t *testing.T) {
+		calls = 0
+		first := sendRequest("/book_borrow", "kiosk-1", `{"book_id":1,"user_id":1}`)
+		assert.Equal(t, http.StatusCreated, first.StatusCode)
+		assert.Empty(t, first.Header.Get(idempotency.HeaderReplayed))
+
+		retry := sendRequest("/book_borrow", "kiosk-1", `{"book_id":1,"user_id":1}`)
+		assert.Equal(t, http.StatusCreated, retry.StatusCode)
+		assert.Equal(t, "true", retry.Header.Get(idempotency.HeaderReplayed))
+		assert.Equal(t, "/loans/1", retry.Header.Get(fiber.HeaderLocation))
+		body, _ := io.ReadAll(retry.Body)
+		assert.Equal(t, "Book was successfully borrowed", string(body))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Key reused with a different body", func(t *testing.T) {
+		resp := sendRequest("/book_borrow", "kiosk-1", `{"book_id":2,"user_id":1}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("Requests without a key are always handled", func(t *testing.T) {
+		calls = 0
+		sendRequest("/book_borrow", "", `{"book_id":1,"user_id":1}`)
+		sendRequest("/book_borrow", "", `{"book_id":1,"user_id":1}`)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Client errors are replayed", func(t *testing.T) {
+		calls = 0
+		first := sendRequest("/fail", "kiosk-2", "conflict")
+		assert.Equal(t, http.StatusConflict, first.StatusCode)
+
+		retry := sendRequest("/fail", "kiosk-2", "conflict")
+		assert.Equal(t, http.StatusConflict, retry.StatusCode)
+		assert.Equal(t, "application/problem+json", retry.Header.Get(fiber.HeaderContentType))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		calls = 0
+		first := sendRequest("/fail", "kiosk-3", "")
+		assert.Equal(t, http.StatusServiceUnavailable, first.StatusCode)
+
+		sendRequest("/fail", "kiosk-3", "")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Key that is too long", func(t *testing.T) {
+		resp := sendRequest("/book_borrow", strings.Repeat("k", idempotency.MaxKeyLength+1), "{}")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// TestIdempotentUnauthenticated tests that unauthenticated requests ignore the Idempotency-Key, so that
+// clients that cannot be told apart do not get the responses of each other
+func TestIdempotentUnauthenticated(t *testing.T) {
+
+	calls := 0
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Post("/user", AuthenticateIfPresent(stubAuthService{}, stubApiKeyService{}), Idempotent(newStubIdempotencyService()),
+		func(c *fiber.Ctx) error {
+			calls++
+			return c.Status(fiber.StatusCreated).SendString("User was successfully created")
+		})
+
+	for _, body := range []string{`{"first_name":"Tine"}`, `{"first_name":"Tine"}`, `{"first_name":"Ana"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+		req.Header.Set(idempotency.HeaderKey, "signup")
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(idempotency.HeaderReplayed))
+	}
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"kokal5296/models/api_key"
+	"kokal5296/models/audit"
+	"kokal5296/models/auth"
+	"kokal5296/models/book"
+	"kokal5296/models/book_borrow"
+	"kokal5296/models/book_copy"
+	"kokal5296/models/bulk"
+	"kokal5296/models/fine"
+	"kokal5296/models/hold"
+	"kokal5296/models/page"
+	"kokal5296/models/user"
+	"kokal5296/service"
+	api "kokal5296/web/handlers"
+	"kokal5296/web/openapi"
+	"strings"
+)
+
+const (
+	specPath = "/openapi.json"
+	docsPath = "/docs"
+)
+
+var apiInfo = openapi.Info{
+	Title:       "BorrowBook API",
+	Description: "Manage the users, books and loans of a library",
+	Version:     "1.0.0",
+}
+
+// Access of the operations, matching the authorization of their routes
+const (
+	accessPublic            = "Public"
+	accessAnyUser           = "Any user, patrons only for themselves"
+	accessAdmins            = "Admins"
+	accessStaff             = "Librarians and admins"
+	accessCatalogRead       = "Any user, API keys with the catalog:read scope"
+	accessLoansRead         = "Any user, patrons only for themselves, API keys with the loans:read scope"
+	accessLoansWrite        = "Any user, patrons only for themselves, API keys with the loans:write scope"
+	accessUsersRead         = "Any user, patrons only for themselves, API keys with the users:read scope"
+	accessStaffCatalogWrite = "Librarians and admins, API keys with the catalog:write scope"
+	accessStaffLoansRead    = "Librarians and admins, API keys with the loans:read scope"
+	accessStaffUsersRead    = "Librarians and admins, API keys with the users:read scope"
+)
+
+// Parameters shared by several operations
+var (
+	includeDeleted = openapi.QueryParam("include_deleted", "boolean", "Return the resource even if it is soft deleted, for admins")
+	ifMatch        = openapi.HeaderParam("If-Match", "The entity tag the resource was read with, or * to update any version")
+	ifMatchPut     = openapi.Parameter{Name: "If-Match", In: "header", Required: true, Schema: &openapi.Schema{Type: "string"},
+		Description: "The entity tag the resource was read with, or * to update any version. Updates without it fail with 428 Precondition Required."}
+	importParams = []openapi.Parameter{
+		{Name: "mode", In: "query", Description: "Whether rows matching an existing item fail or update it",
+			Schema: &openapi.Schema{Type: "string", Enum: []string{bulk.ModeInsert, bulk.ModeUpsert}}},
+		openapi.QueryParam("dry_run", "boolean", "Check and report the rows without importing them"),
+	}
+	importTypes = []string{api.MIMETextCSV, api.MIMENDJSON}
+	loanStatus  = openapi.Parameter{Name: "status", In: "query", Description: "Loans to list",
+		Schema: &openapi.Schema{Type: "string", Enum: []string{book_borrow.StatusOpen, book_borrow.StatusReturned, book_borrow.StatusAll}}}
+)
+
+// operations describes every route of SetupRoutes for the OpenAPI document
+func operations() []openapi.Operation {
+	operations := []openapi.Operation{
+		{Method: fiber.MethodGet, Path: specPath, Tag: "docs", Summary: "Get the OpenAPI document", Access: accessPublic, Auth: openapi.AuthNone, Response: map[string]interface{}{}},
+		{Method: fiber.MethodGet, Path: docsPath, Tag: "docs", Summary: "Browse the API with Swagger UI", Access: accessPublic, Auth: openapi.AuthNone, ContentType: fiber.MIMETextHTML},
+
+		{Method: fiber.MethodPost, Path: authPath + "/login", Tag: "auth", Summary: "Log in", Access: accessPublic, Auth: openapi.AuthNone, Request: auth.Credentials{}, Response: auth.TokenPair{}},
+		{Method: fiber.MethodPost, Path: authPath + "/refresh", Tag: "auth", Summary: "Refresh the tokens", Access: accessPublic, Auth: openapi.AuthNone, Request: auth.RefreshRequest{}, Response: auth.TokenPair{}},
+
+		{Method: fiber.MethodPost, Path: apiKeyPath, Tag: "api keys", Summary: "Create an API key", Access: accessAdmins, Auth: openapi.AuthUser, Request: api_key.ApiKey{}, Status: fiber.StatusCreated, Response: api_key.ApiKey{}},
+		{Method: fiber.MethodGet, Path: apiKeyPath + "s", Tag: "api keys", Summary: "List the API keys", Access: accessAdmins, Auth: openapi.AuthUser, Response: []api_key.ApiKey{}},
+		{Method: fiber.MethodDelete, Path: apiKeyPath + "/:id", Tag: "api keys", Summary: "Revoke an API key", Access: accessAdmins, Auth: openapi.AuthUser},
+
+		{Method: fiber.MethodGet, Path: auditPath, Tag: "audit", Summary: "List the audit log", Access: accessAdmins, Auth: openapi.AuthUser, List: &service.AuditListSpec, Response: page.Page[audit.Event]{}},
+
+		{Method: fiber.MethodPost, Path: userPath, Tag: "users", Summary: "Create a user", Access: "Public, staff roles only by admins", Auth: openapi.AuthOptional, Request: user.User{}, Status: fiber.StatusCreated},
+		{Method: fiber.MethodGet, Path: userPath + "/:id", Tag: "users", Summary: "Get a user", Access: accessUsersRead, ETag: true, Parameters: []openapi.Parameter{includeDeleted}, Response: user.User{}},
+		{Method: fiber.MethodGet, Path: userPath + "s", Tag: "users", Summary: "List users", Access: accessStaffUsersRead, List: &service.UserListSpec, Response: page.Page[user.User]{}},
+		{Method: fiber.MethodPut, Path: userPath + "/:id", Tag: "users", Summary: "Update a user", Access: accessAnyUser, Auth: openapi.AuthUser, Parameters: []openapi.Parameter{ifMatchPut}, Request: user.User{}},
+		{Method: fiber.MethodPatch, Path: userPath + "/:id", Tag: "users", Summary: "Patch a user", Access: accessAnyUser, Auth: openapi.AuthUser, Parameters: []openapi.Parameter{ifMatch}, Request: user.User{}, MergePatch: true},
+		{Method: fiber.MethodDelete, Path: userPath + "/:id", Tag: "users", Summary: "Delete a user", Access: accessAdmins, Auth: openapi.AuthUser},
+		{Method: fiber.MethodPost, Path: userPath + "/:id/restore", Tag: "users", Summary: "Restore a deleted user", Access: accessAdmins, Auth: openapi.AuthUser},
+		{Method: fiber.MethodPut, Path: userPath + "/:id/membership", Tag: "users", Summary: "Update the membership of a user", Access: accessStaff, Auth: openapi.AuthUser, Request: user.Membership{}},
+		{Method: fiber.MethodPost, Path: userPath + "s/import", Tag: "users", Summary: "Import users from CSV or NDJSON", Access: accessAdmins, Auth: openapi.AuthUser, Parameters: importParams, Upload: importTypes, Response: bulk.Report{}},
+		{Method: fiber.MethodPut, Path: userPath + "/:id/role", Tag: "users", Summary: "Change the role of a user", Access: accessAdmins, Auth: openapi.AuthUser, Request: user.RoleChange{}},
+
+		{Method: fiber.MethodPost, Path: bookPath, Tag: "books", Summary: "Create a book", Access: accessStaffCatalogWrite, Request: book.Book{}, Status: fiber.StatusCreated},
+		{Method: fiber.MethodGet, Path: bookPath + "/:id", Tag: "books", Summary: "Get a book", Access: accessCatalogRead, ETag: true, Parameters: []openapi.Parameter{includeDeleted}, Response: book.Book{}},
+		{Method: fiber.MethodGet, Path: bookPath + "s", Tag: "books", Summary: "List books", Access: accessCatalogRead, List: &service.BookListSpec, Response: page.Page[book.Book]{}},
+		{Method: fiber.MethodGet, Path: bookPath + "s/search", Tag: "books", Summary: "Search books by title, author or ISBN", Access: accessCatalogRead, Response: []book.Book{},
+			Parameters: []openapi.Parameter{
+				openapi.QueryParam("q", "string", "Search terms"),
+				openapi.QueryParam("available", "boolean", "Only return books with available copies"),
+				openapi.QueryParam("limit", "integer", "Number of books returned"),
+			}},
+		{Method: fiber.MethodPost, Path: bookPath + "s/import", Tag: "books", Summary: "Import books from CSV or NDJSON", Access: accessStaffCatalogWrite, Parameters: importParams, Upload: importTypes, Response: bulk.Report{}},
+		{Method: fiber.MethodPut, Path: bookPath + "/:id", Tag: "books", Summary: "Update a book", Access: accessStaffCatalogWrite, Parameters: []openapi.Parameter{ifMatchPut}, Request: book.Book{}},
+		{Method: fiber.MethodPatch, Path: bookPath + "/:id", Tag: "books", Summary: "Patch a book", Access: accessStaffCatalogWrite, Parameters: []openapi.Parameter{ifMatch}, Request: book.Book{}, MergePatch: true},
+		{Method: fiber.MethodDelete, Path: bookPath + "/:id", Tag: "books", Summary: "Delete a book", Access: accessStaffCatalogWrite},
+		{Method: fiber.MethodPost, Path: bookPath + "/:id/restore", Tag: "books", Summary: "Restore a deleted book", Access: accessStaffCatalogWrite},
+
+		{Method: fiber.MethodGet, Path: bookBorrowPath, Tag: "loans", Summary: "List available books", Access: accessCatalogRead, List: &service.AvailableBookListSpec, Response: page.Page[book.Book]{}},
+		{Method: fiber.MethodGet, Path: bookBorrowPath + "ed", Tag: "loans", Summary: "List borrowed books", Access: accessLoansRead, List: &service.BookBorrowListSpec, Response: page.Page[book_borrow.BookBorrow]{}},
+		{Method: fiber.MethodGet, Path: bookBorrowPath + "ed/overdue", Tag: "loans", Summary: "List overdue books", Access: accessStaffLoansRead, Response: []book_borrow.BookBorrow{}},
+		{Method: fiber.MethodGet, Path: userPath + "/:id/loans", Tag: "loans", Summary: "List the borrowing history of a user", Access: accessLoansRead, List: &service.LoanHistoryListSpec, Parameters: []openapi.Parameter{loanStatus}, Response: page.Page[book_borrow.Loan]{}},
+		{Method: fiber.MethodGet, Path: bookPath + "/:id/loans", Tag: "loans", Summary: "List the borrowing history of a book", Access: accessStaffLoansRead, List: &service.LoanHistoryListSpec, Parameters: []openapi.Parameter{loanStatus}, Response: page.Page[book_borrow.Loan]{}},
+		{Method: fiber.MethodPost, Path: bookBorrowPath, Tag: "loans", Summary: "Borrow a book", Access: accessLoansWrite, Request: book_borrow.BookBorrow{}},
+		{Method: fiber.MethodPut, Path: bookBorrowPath, Tag: "loans", Summary: "Return a book", Access: accessLoansWrite, Request: book_borrow.BookBorrow{}},
+		{Method: fiber.MethodPost, Path: bookBorrowPath + "/:id/renew", Tag: "loans", Summary: "Renew a loan", Access: accessLoansWrite, Response: book_borrow.BookBorrow{}},
+		{Method: fiber.MethodPost, Path: loansPath, Tag: "loans", Summary: "Borrow a book, creating a loan", Access: accessLoansWrite, Request: book_borrow.BookBorrow{}, Status: fiber.StatusCreated, Response: book_borrow.BookBorrow{}, Location: true},
+		{Method: fiber.MethodGet, Path: loansPath + "/:id", Tag: "loans", Summary: "Get a loan", Access: accessLoansRead, Response: book_borrow.BookBorrow{}},
+		{Method: fiber.MethodPost, Path: loansPath + "/:id/return", Tag: "loans", Summary: "Return the book of a loan", Access: accessLoansWrite, Response: book_borrow.BookBorrow{}},
+
+		{Method: fiber.MethodPost, Path: holdPath, Tag: "holds", Summary: "Place a hold on a book", Access: accessLoansWrite, Request: hold.Hold{}, Status: fiber.StatusCreated, Response: hold.Hold{}},
+		{Method: fiber.MethodGet, Path: bookPath + "/:id/holds", Tag: "holds", Summary: "List the holds of a book", Access: accessStaffLoansRead, Response: []hold.Hold{}},
+		{Method: fiber.MethodDelete, Path: holdPath + "/:id", Tag: "holds", Summary: "Cancel a hold", Access: accessLoansWrite},
+
+		{Method: fiber.MethodGet, Path: userPath + "/:id/balance", Tag: "fines", Summary: "Get the fine account of a user", Access: accessUsersRead, Response: fine.Account{}},
+		{Method: fiber.MethodPost, Path: userPath + "/:id/payments", Tag: "fines", Summary: "Record a payment of fines", Access: accessStaff, Auth: openapi.AuthUser, Request: fine.LedgerEntry{}, Status: fiber.StatusCreated, Response: fine.LedgerEntry{}},
+		{Method: fiber.MethodPost, Path: userPath + "/:id/waivers", Tag: "fines", Summary: "Waive fines", Access: accessStaff, Auth: openapi.AuthUser, Request: fine.LedgerEntry{}, Status: fiber.StatusCreated, Response: fine.LedgerEntry{}},
+
+		{Method: fiber.MethodPost, Path: bookPath + "/:id/copies", Tag: "copies", Summary: "Add a copy of a book", Access: accessStaffCatalogWrite, Request: book_copy.Copy{}, Status: fiber.StatusCreated, Response: book_copy.Copy{}},
+		{Method: fiber.MethodGet, Path: bookPath + "/:id/copies", Tag: "copies", Summary: "List the copies of a book", Access: accessCatalogRead, Response: []book_copy.Copy{}},
+		{Method: fiber.MethodPut, Path: copyPath + "/:id", Tag: "copies", Summary: "Update a copy", Access: accessStaffCatalogWrite, Request: book_copy.Copy{}, Response: book_copy.Copy{}},
+		{Method: fiber.MethodPost, Path: copyPath + "/audit", Tag: "copies", Summary: "Audit a shelf", Access: accessStaffCatalogWrite, Request: book_copy.ShelfAudit{}, Response: book_copy.AuditReport{}},
+	}
+
+	// Requests that change data go through the Idempotent middleware, except logging in and refreshing tokens
+	for i, operation := range operations {
+		operations[i].Idempotent = operation.Method != fiber.MethodGet && !strings.HasPrefix(operation.Path, authPath+"/")
+	}
+
+	return operations
+}
+
+// setupDocsRoutes serves the OpenAPI document of the routes of the version mounted at basePath,
+// and the Swagger UI page that shows it
+func setupDocsRoutes(app fiber.Router, basePath string) {
+	app.Get(specPath, openapi.Handler(openapi.New(apiInfo, basePath, operations())))
+	app.Get(docsPath, openapi.UIHandler(apiInfo.Title, basePath+specPath))
+}
@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"kokal5296/config"
+	api "kokal5296/web/handlers"
+	"kokal5296/web/openapi"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// v1Path is the path the routes of v1 are mounted at
+const v1Path = apiPath + "/v1"
+
+// newHandlers returns the handlers of every route, without services
+func newHandlers() Handlers {
+	return Handlers{
+		Auth:       api.NewAuthApiService(nil),
+		ApiKey:     api.NewApiKeyApiService(nil),
+		Audit:      api.NewAuditApiService(nil),
+		User:       api.NewUserApiService(nil),
+		Book:       api.NewBookApiService(nil),
+		BookBorrow: api.NewBookBorrowApiService(nil),
+		Hold:       api.NewHoldApiService(nil),
+		Fine:       api.NewFineApiService(nil),
+		Copy:       api.NewCopyApiService(nil),
+	}
+}
+
+// newDocsApp returns an app with every route of SetupRoutes, with handlers that have no services
+func newDocsApp() *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	SetupRoutes(app, stubAuthService{}, stubApiKeyService{}, newStubIdempotencyService(), config.DefaultApiConfig(), ApiVersion{Name: "v1", Handlers: newHandlers()})
+	return app
+}
+
+// TestOpenAPICoversRoutes tests that every registered route of v1 is described by the OpenAPI document
+func TestOpenAPICoversRoutes(t *testing.T) {
+
+	app := newDocsApp()
+	doc := openapi.New(apiInfo, v1Path, operations())
+
+	routes := 0
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead || !strings.HasPrefix(route.Path, v1Path+"/") {
+			continue
+		}
+		routes++
+		path := strings.TrimPrefix(route.Path, v1Path)
+		assert.True(t, doc.HasOperation(route.Method, path), "%s %s is missing from the OpenAPI document", route.Method, route.Path)
+	}
+
+	operationCount := 0
+	for _, path := range doc.Paths {
+		operationCount += len(path)
+	}
+	assert.Equal(t, routes, operationCount, "the OpenAPI document describes routes that are not registered")
+}
+
+// TestOpenAPIDocument tests that the document and the Swagger UI page are served without authentication
+func TestOpenAPIDocument(t *testing.T) {
+
+	app := newDocsApp()
+
+	req := httptest.NewRequest(http.MethodGet, v1Path+specPath, nil)
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Servers    []openapi.Server                             `json:"servers"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]openapi.Schema `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	assert.Equal(t, []openapi.Server{{URL: v1Path}}, doc.Servers)
+	assert.Contains(t, doc.Paths, "/user/{id}/loans")
+	assert.Contains(t, doc.Paths["/book/{id}"], "patch")
+
+	// Schemas are derived from the models, with their validator tags
+	userSchema := doc.Components.Schemas["User"]
+	assert.Contains(t, userSchema.Required, "first_name")
+	assert.Equal(t, "email", userSchema.Properties["email"].Format)
+	assert.NotContains(t, userSchema.Properties, "version")
+	assert.Equal(t, 8, *userSchema.Properties["password"].MinLength)
+	assert.Equal(t, []string{"standard", "premium", "staff"}, userSchema.Properties["tier"].Enum)
+
+	bookSchema := doc.Components.Schemas["Book"]
+	assert.Equal(t, "array", bookSchema.Properties["authors"].Type)
+	assert.True(t, bookSchema.Properties["authors"].UniqueItems)
+	assert.Contains(t, doc.Components.Schemas, "BookBorrow")
+	assert.Contains(t, doc.Components.Schemas, "BookPage")
+
+	req = httptest.NewRequest(http.MethodGet, v1Path+docsPath, nil)
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), fiber.MIMETextHTML))
+}
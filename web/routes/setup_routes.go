@@ -2,41 +2,226 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"kokal5296/config"
+	"kokal5296/models/api_key"
+	"kokal5296/models/user"
+	"kokal5296/service"
 	api "kokal5296/web/handlers"
 )
 
+// Prefix of the versioned routes, and the version whose routes are also kept at the root
+const (
+	apiPath       = "/api"
+	legacyVersion = "v1"
+)
+
 const (
 	userPath       = "/user"
 	bookPath       = "/book"
 	bookBorrowPath = "/book_borrow"
+	loansPath      = "/loans"
+	holdPath       = "/hold"
+	copyPath       = "/copy"
+	authPath       = "/auth"
+	apiKeyPath     = "/api_key"
+	auditPath      = "/audit"
+)
+
+// Roles allowed to make requests that are not open to every authenticated user
+var staffRoles = []string{user.RoleLibrarian, user.RoleAdmin}
+
+// Requests open to any user and to API keys with the scope
+var (
+	catalogRead = api.Authorize(api_key.ScopeCatalogRead)
+	loansRead   = api.Authorize(api_key.ScopeLoansRead)
+	loansWrite  = api.Authorize(api_key.ScopeLoansWrite)
+	usersRead   = api.Authorize(api_key.ScopeUsersRead)
+	anyUser     = api.Authorize("")
 )
 
-// SetupRoutes initializes all routes for the application
-func SetupRoutes(app *fiber.App, userHandler api.UserApi, bookHandler api.BookApi, bookBorrowHandler api.BookBorrowApi) {
-	setupUserRoutes(app, userHandler)
-	setupBookRoutes(app, bookHandler)
-	setupBookBorrowRoutes(app, bookBorrowHandler)
+// Requests restricted to librarians and admins, and to API keys with the scope
+var (
+	staffCatalogWrite = api.Authorize(api_key.ScopeCatalogWrite, staffRoles...)
+	staffLoansRead    = api.Authorize(api_key.ScopeLoansRead, staffRoles...)
+	staffUsersRead    = api.Authorize(api_key.ScopeUsersRead, staffRoles...)
+	staff             = api.Authorize("", staffRoles...)
+	admins            = api.Authorize("", user.RoleAdmin)
+)
+
+// Handlers holds the handlers of the routes of a version of the API
+type Handlers struct {
+	Auth       api.AuthApi
+	ApiKey     api.ApiKeyApi
+	Audit      api.AuditApi
+	User       api.UserApi
+	Book       api.BookApi
+	BookBorrow api.BookBorrowApi
+	Hold       api.HoldApi
+	Fine       api.FineApi
+	Copy       api.CopyApi
+}
+
+// ApiVersion represents a version of the API, mounted under /api/<Name>. Versions share the routes
+// but can have different handler implementations, e.g. to return other payloads.
+type ApiVersion struct {
+	Name     string
+	Handlers Handlers
+}
+
+// SetupRoutes initializes all routes for the application, mounting each version of the API under /api/<version>.
+// The routes of v1 are also kept at the root as deprecated aliases, which reply with Deprecation and Sunset headers.
+// Logging in, refreshing tokens, creating a user and the API documentation are public, every other route requires an access token
+// or an API key. Each route is authorized by role for users and by scope for API keys; routes without a scope
+// are closed to API keys. Patrons can use the routes open to any user only for themselves, which the handlers check.
+// Requests that change data, except logging in and refreshing tokens, can be retried safely with an Idempotency-Key.
+func SetupRoutes(app *fiber.App, authService service.AuthService, apiKeyService service.ApiKeyService, idempotencyService service.IdempotencyService, apiConfig config.ApiConfig, versions ...ApiVersion) {
+	for _, version := range versions {
+		basePath := apiPath + "/" + version.Name
+		setupVersionRoutes(app.Group(basePath), basePath, authService, apiKeyService, idempotencyService, version.Handlers)
+	}
+
+	for _, version := range versions {
+		if version.Name == legacyVersion {
+			basePath := apiPath + "/" + version.Name
+			legacy := withMiddleware(app, Deprecated(apiConfig, basePath))
+			setupVersionRoutes(legacy, basePath, authService, apiKeyService, idempotencyService, version.Handlers)
+		}
+	}
+}
+
+// setupVersionRoutes registers the routes of a version of the API. basePath is the path the version is mounted at,
+// which its API documentation refers to.
+func setupVersionRoutes(app fiber.Router, basePath string, authService service.AuthService, apiKeyService service.ApiKeyService, idempotencyService service.IdempotencyService, handlers Handlers) {
+	setupDocsRoutes(app, basePath)
+	setupAuthRoutes(app, handlers.Auth)
+	app.Post(userPath, AuthenticateIfPresent(authService, apiKeyService), Idempotent(idempotencyService), handlers.User.CreateUser)
+
+	protected := withMiddleware(app, Authenticate(authService, apiKeyService), Idempotent(idempotencyService))
+	setupApiKeyRoutes(protected, handlers.ApiKey)
+	setupAuditRoutes(protected, handlers.Audit)
+	setupUserRoutes(protected, handlers.User)
+	setupBookRoutes(protected, handlers.Book)
+	setupBookBorrowRoutes(protected, handlers.BookBorrow)
+	setupHoldRoutes(protected, handlers.Hold)
+	setupFineRoutes(protected, handlers.Fine)
+	setupCopyRoutes(protected, handlers.Copy)
+}
+
+// routeGroup registers routes on a router with its middleware in front of the handlers of each route.
+// Unlike the middleware of a fiber group, which runs for every request under the prefix of the group,
+// it only runs for requests that match one of the routes, so that unknown paths are answered with 404
+// instead of being refused by the authentication middleware.
+type routeGroup struct {
+	fiber.Router
+	middleware []fiber.Handler
+}
+
+// withMiddleware returns a router that registers its routes on router, with the given middleware in front of their handlers
+func withMiddleware(router fiber.Router, middleware ...fiber.Handler) fiber.Router {
+	return &routeGroup{Router: router, middleware: middleware}
+}
+
+// handlers returns the middleware of the group followed by the given handlers of a route
+func (g *routeGroup) handlers(handlers []fiber.Handler) []fiber.Handler {
+	return append(append([]fiber.Handler{}, g.middleware...), handlers...)
+}
+
+func (g *routeGroup) Add(method, path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Add(method, path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) All(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.All(path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) Get(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Get(path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) Post(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Post(path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) Put(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Put(path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) Patch(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Patch(path, g.handlers(handlers)...)
+}
+
+func (g *routeGroup) Delete(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Router.Delete(path, g.handlers(handlers)...)
+}
+
+func setupAuthRoutes(app fiber.Router, handler api.AuthApi) {
+	app.Post(authPath+"/login", handler.Login)
+	app.Post(authPath+"/refresh", handler.Refresh)
+}
+
+func setupApiKeyRoutes(app fiber.Router, handler api.ApiKeyApi) {
+	app.Post(apiKeyPath, admins, handler.CreateApiKey)
+	app.Get(apiKeyPath+"s", admins, handler.GetApiKeys)
+	app.Delete(apiKeyPath+"/:id", admins, handler.RevokeApiKey)
+}
+
+func setupAuditRoutes(app fiber.Router, handler api.AuditApi) {
+	app.Get(auditPath, admins, handler.GetAuditEvents)
+}
+
+func setupUserRoutes(app fiber.Router, handler api.UserApi) {
+	app.Get(userPath+"/:id", usersRead, handler.GetUser)
+	app.Get(userPath+"s", staffUsersRead, handler.GetAllUsers)
+	app.Put(userPath+"/:id", anyUser, handler.UpdateUser)
+	app.Patch(userPath+"/:id", anyUser, handler.PatchUser)
+	app.Delete(userPath+"/:id", admins, handler.DeleteUser)
+	app.Post(userPath+"/:id/restore", admins, handler.RestoreUser)
+	app.Put(userPath+"/:id/membership", staff, handler.UpdateMembership)
+	app.Put(userPath+"/:id/role", admins, handler.UpdateRole)
+	app.Post(userPath+"s/import", admins, handler.ImportUsers)
+}
+
+func setupBookRoutes(app fiber.Router, handler api.BookApi) {
+	app.Post(bookPath, staffCatalogWrite, handler.CreateBook)
+	app.Get(bookPath+"/:id", catalogRead, handler.GetBook)
+	app.Get(bookPath+"s", catalogRead, handler.GetAllBooks)
+	app.Get(bookPath+"s/search", catalogRead, handler.SearchBooks)
+	app.Put(bookPath+"/:id", staffCatalogWrite, handler.UpdateBook)
+	app.Patch(bookPath+"/:id", staffCatalogWrite, handler.PatchBook)
+	app.Delete(bookPath+"/:id", staffCatalogWrite, handler.DeleteBook)
+	app.Post(bookPath+"/:id/restore", staffCatalogWrite, handler.RestoreBook)
+	app.Post(bookPath+"s/import", staffCatalogWrite, handler.ImportBooks)
+}
+
+func setupBookBorrowRoutes(app fiber.Router, handler api.BookBorrowApi) {
+	app.Get(bookBorrowPath, catalogRead, handler.GetAvailableBooks)
+	app.Get(bookBorrowPath+"ed", loansRead, handler.AllBorrowedBooks)
+	app.Get(bookBorrowPath+"ed/overdue", staffLoansRead, handler.OverdueBooks)
+	app.Get(userPath+"/:id/loans", loansRead, handler.UserLoans)
+	app.Get(bookPath+"/:id/loans", staffLoansRead, handler.BookLoans)
+	app.Post(bookBorrowPath, loansWrite, handler.BorrowBook)
+	app.Put(bookBorrowPath, loansWrite, handler.ReturnBook)
+	app.Post(bookBorrowPath+"/:id/renew", loansWrite, handler.RenewBook)
+	app.Post(loansPath, loansWrite, handler.CreateLoan)
+	app.Get(loansPath+"/:id", loansRead, handler.GetLoan)
+	app.Post(loansPath+"/:id/return", loansWrite, handler.ReturnLoan)
 }
 
-func setupUserRoutes(app *fiber.App, handler api.UserApi) {
-	app.Post(userPath, handler.CreateUser)
-	app.Get(userPath+"/:id", handler.GetUser)
-	app.Get(userPath+"s", handler.GetAllUsers)
-	app.Put(userPath+"/:id", handler.UpdateUser)
-	app.Delete(userPath+"/:id", handler.DeleteUser)
+func setupHoldRoutes(app fiber.Router, handler api.HoldApi) {
+	app.Post(holdPath, loansWrite, handler.PlaceHold)
+	app.Get(bookPath+"/:id/holds", staffLoansRead, handler.GetBookHolds)
+	app.Delete(holdPath+"/:id", loansWrite, handler.CancelHold)
 }
 
-func setupBookRoutes(app *fiber.App, handler api.BookApi) {
-	app.Post(bookPath, handler.CreateBook)
-	app.Get(bookPath+"/:id", handler.GetBook)
-	app.Get(bookPath+"s", handler.GetAllBooks)
-	app.Put(bookPath+"/:id", handler.UpdateBook)
-	app.Delete(bookPath+"/:id", handler.DeleteBook)
+func setupFineRoutes(app fiber.Router, handler api.FineApi) {
+	app.Get(userPath+"/:id/balance", usersRead, handler.GetAccount)
+	app.Post(userPath+"/:id/payments", staff, handler.RecordPayment)
+	app.Post(userPath+"/:id/waivers", staff, handler.WaiveFine)
 }
 
-func setupBookBorrowRoutes(app *fiber.App, handler api.BookBorrowApi) {
-	app.Get(bookBorrowPath, handler.GetAvailableBooks)
-	app.Get(bookBorrowPath+"ed", handler.AllBorrowedBooks)
-	app.Post(bookBorrowPath, handler.BorrowBook)
-	app.Put(bookBorrowPath, handler.ReturnBook)
+func setupCopyRoutes(app fiber.Router, handler api.CopyApi) {
+	app.Post(bookPath+"/:id/copies", staffCatalogWrite, handler.AddCopy)
+	app.Get(bookPath+"/:id/copies", catalogRead, handler.GetBookCopies)
+	app.Put(copyPath+"/:id", staffCatalogWrite, handler.UpdateCopy)
+	app.Post(copyPath+"/audit", staffCatalogWrite, handler.AuditShelf)
 }
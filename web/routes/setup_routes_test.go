@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"kokal5296/config"
+	api "kokal5296/web/handlers"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// versionAuthApi replies to logins with the name of its version, to tell the handlers of versions apart
+type versionAuthApi struct {
+	version string
+}
+
+func (a versionAuthApi) Login(c *fiber.Ctx) error {
+	return c.SendString(a.version)
+}
+
+func (a versionAuthApi) Refresh(c *fiber.Ctx) error {
+	return c.SendString(a.version)
+}
+
+// TestApiVersions tests that each version is served by its own handlers under its prefix,
+// and that the routes at the root are deprecated aliases of v1
+func TestApiVersions(t *testing.T) {
+
+	v1 := newHandlers()
+	v1.Auth = versionAuthApi{version: "v1"}
+	v2 := newHandlers()
+	v2.Auth = versionAuthApi{version: "v2"}
+
+	cfg := config.DefaultApiConfig()
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	SetupRoutes(app, stubAuthService{}, stubApiKeyService{}, newStubIdempotencyService(), cfg, ApiVersion{Name: "v1", Handlers: v1}, ApiVersion{Name: "v2", Handlers: v2})
+
+	tests := []struct {
+		name       string
+		path       string
+		expected   string
+		deprecated bool
+	}{
+		{name: "v1", path: "/api/v1/auth/login", expected: "v1"},
+		{name: "v2", path: "/api/v2/auth/login", expected: "v2"},
+		{name: "Legacy alias of v1", path: "/auth/login", expected: "v1", deprecated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.expected, string(body))
+
+			if tt.deprecated {
+				assert.Equal(t, "@1792108800", resp.Header.Get("Deprecation"))
+				assert.Equal(t, "Fri, 16 Apr 2027 00:00:00 GMT", resp.Header.Get("Sunset"))
+				assert.Equal(t, `</api/v1/auth/login>; rel="successor-version"`, resp.Header.Get(fiber.HeaderLink))
+			} else {
+				assert.Empty(t, resp.Header.Get("Deprecation"))
+				assert.Empty(t, resp.Header.Get("Sunset"))
+			}
+		})
+	}
+
+	// Protected routes of every version and of the aliases still require authentication
+	for _, path := range []string{"/api/v1/books", "/api/v2/books", "/books"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+	}
+
+	// Unknown paths are not found, without being authenticated or deprecated first
+	for _, path := range []string{"/api/v1/unknown", "/api/v3/books", "/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+		assert.Empty(t, resp.Header.Get("Deprecation"), path)
+	}
+}
@@ -1,24 +1,38 @@
 package server
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"kokal5296/config"
 	"kokal5296/database"
 	"kokal5296/service"
 	api "kokal5296/web/handlers"
 	"kokal5296/web/routes"
 	"log"
 	"os"
+	"time"
 )
 
+// idempotencyPurgeInterval is how often expired idempotency keys are removed
+const idempotencyPurgeInterval = time.Hour
+
 type Server struct {
 	App        *fiber.App
 	PostgreSQL *database.PostgreSQLConnection
+	stopJobs   context.CancelFunc
 }
 
 // CreateServer initializes and confugures the server, database connection, services, handlers, and routes
 func CreateServer(connStr, dbName string) *Server {
 
-	app := fiber.New()
+	apiConfig := config.LoadApiConfig()
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: api.ErrorHandler,
+		BodyLimit:    apiConfig.BodyLimit,
+	})
+	app.Use(requestid.New())
 
 	// Initialize PostgreSQL connection
 	databaseService := database.NewDatabaseService()
@@ -30,27 +44,46 @@ func CreateServer(connStr, dbName string) *Server {
 
 	log.Println("Connected to PostgreSQL")
 
+	loanConfig := config.LoadLoanConfig()
+	authConfig := config.LoadAuthConfig()
+
 	// Service initialization
 	userService := service.NewUserService(db)
 	bookService := service.NewBookService(db)
-	service.NewBookBorrowService(db, bookService, userService)
+	bookBorrowService := service.NewBookBorrowService(db, bookService, userService, loanConfig)
+	holdService := service.NewHoldService(db, userService, loanConfig)
+	fineService := service.NewFineService(db, userService)
+	copyService := service.NewCopyService(db)
+	authService := service.NewAuthService(db, userService, authConfig)
+	apiKeyService := service.NewApiKeyService(db)
+	auditService := service.NewAuditService(db)
+	idempotencyService := service.NewIdempotencyService(db, apiConfig.IdempotencyKeyTTL)
 
 	// Handler initialization
-	api.NewUserApiService(service.NewUserService(db))
-	api.NewBookApiService(service.NewBookService(db))
-	api.NewBookBorrowApiService(service.NewBookBorrowService(db, bookService, userService))
+	v1 := routes.Handlers{
+		Auth:       api.NewAuthApiService(authService),
+		ApiKey:     api.NewApiKeyApiService(apiKeyService),
+		Audit:      api.NewAuditApiService(auditService),
+		User:       api.NewUserApiService(userService),
+		Book:       api.NewBookApiService(bookService),
+		BookBorrow: api.NewBookBorrowApiService(bookBorrowService),
+		Hold:       api.NewHoldApiService(holdService),
+		Fine:       api.NewFineApiService(fineService),
+		Copy:       api.NewCopyApiService(copyService),
+	}
 
 	// Routes initialization
-	routes.SetupRoutes(app,
-		api.NewUserApiService(service.NewUserService(db)),
-		api.NewBookApiService(service.NewBookService(db)),
-		api.NewBookBorrowApiService(service.NewBookBorrowService(db, bookService, userService)),
-	)
+	routes.SetupRoutes(app, authService, apiKeyService, idempotencyService, apiConfig, routes.ApiVersion{Name: "v1", Handlers: v1})
+
+	// Background jobs, stopped when the server is closed
+	jobs, stopJobs := context.WithCancel(context.Background())
+	go purgeIdempotencyKeys(jobs, idempotencyService)
 
 	// Server initialization
 	server := &Server{
 		App:        app,
 		PostgreSQL: db,
+		stopJobs:   stopJobs,
 	}
 
 	return server
@@ -67,6 +100,26 @@ func (s *Server) Start() error {
 
 // Close gracefully shuts down the database connection when the server is stopped
 func (s *Server) Close() {
+	s.stopJobs()
 	s.PostgreSQL.Close()
 	log.Println("Server and database connection closed")
 }
+
+// purgeIdempotencyKeys removes expired idempotency keys every idempotencyPurgeInterval until ctx is cancelled,
+// so that requests do not have to
+func purgeIdempotencyKeys(ctx context.Context, idempotencyService service.IdempotencyService) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := idempotencyService.PurgeExpired(ctx)
+			if err != nil {
+				log.Printf("Error purging idempotency keys: %v", err)
+			}
+		}
+	}
+}
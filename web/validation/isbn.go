@@ -0,0 +1,78 @@
+package validate
+
+import (
+	"github.com/go-playground/validator/v10"
+	"strings"
+)
+
+// validateISBN checks that the field is an ISBN-10 or ISBN-13 with a valid check digit
+func validateISBN(fl validator.FieldLevel) bool {
+	isbn := stripISBN(fl.Field().String())
+
+	switch len(isbn) {
+	case 10:
+		return validISBN10(isbn)
+	case 13:
+		return validISBN13(isbn)
+	default:
+		return false
+	}
+}
+
+// NormalizeISBN converts a valid ISBN-10 or ISBN-13, with or without hyphens and spaces,
+// to the 13 digit form used to tell books apart
+func NormalizeISBN(isbn string) string {
+	isbn = stripISBN(isbn)
+	if len(isbn) != 10 {
+		return isbn
+	}
+
+	isbn13 := "978" + isbn[:9]
+	return isbn13 + string(isbn13CheckDigit(isbn13))
+}
+
+// stripISBN removes the hyphens and spaces that are commonly used to group the parts of an ISBN
+func stripISBN(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+}
+
+// validISBN10 checks the weighted sum of the ten digits, where the last one may be X for ten
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i, r := range isbn {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case r == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+// validISBN13 checks that the last digit matches the check digit computed from the first twelve
+func validISBN13(isbn string) bool {
+	for _, r := range isbn {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return isbn13CheckDigit(isbn[:12]) == rune(isbn[12])
+}
+
+// isbn13CheckDigit computes the check digit of the first twelve digits of an ISBN-13
+func isbn13CheckDigit(isbn string) rune {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(isbn[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return rune('0' + (10-sum%10)%10)
+}
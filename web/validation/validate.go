@@ -1,28 +1,167 @@
 package validate
 
 import (
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
+	"kokal5296/models/api_key"
+	"kokal5296/models/auth"
 	"kokal5296/models/book"
 	"kokal5296/models/book_borrow"
+	"kokal5296/models/book_copy"
+	"kokal5296/models/fine"
+	"kokal5296/models/hold"
+	"kokal5296/models/problem"
 	"kokal5296/models/user"
+	"reflect"
+	"strings"
 )
 
 // Variable with functuion to create new validation
-var validate = validator.New()
+var validate = newValidator()
+
+// newValidator creates a validator with the custom validations used by the models
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterValidation("isbn_checksum", validateISBN)
+	v.RegisterTagNameFunc(jsonFieldName)
+	return v
+}
+
+// jsonFieldName names struct fields by their JSON names in validation errors
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// FieldErrors converts the validation errors of a struct into the field errors of a problem response.
+// It returns nil if err is not a validation error.
+func FieldErrors(err error) []problem.FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+
+	fieldErrors := make([]problem.FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+
+		fieldErrors = append(fieldErrors, problem.FieldError{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldErrorMessage(field, fe),
+		})
+	}
+	return fieldErrors
+}
+
+// fieldErrorMessage describes a failed validation of a field
+func fieldErrorMessage(field string, fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "max":
+		if fe.Kind() == reflect.String || fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("%s must be at most %s long", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at most %s", field, fe.Param())
+	case "min":
+		if fe.Kind() == reflect.String || fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("%s must be at least %s long", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at least %s", field, fe.Param())
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "unique":
+		return fmt.Sprintf("%s must not contain duplicates", field)
+	case "isbn_checksum":
+		return fmt.Sprintf("%s is not a valid ISBN-10 or ISBN-13", field)
+	default:
+		return fmt.Sprintf("%s failed the %s validation", field, fe.Tag())
+	}
+}
 
 // validateStruct validates any given struct based on tags defined within the struct
 func validateStruct(input interface{}) error {
 	return validate.Struct(input)
 }
 
+// validatePartial validates only the fields of a struct with the given JSON names, as supplied in a merge patch
+func validatePartial(input interface{}, jsonFields []string) error {
+	typ := reflect.TypeOf(input)
+	fields := make([]string, 0, len(jsonFields))
+	for i := 0; i < typ.NumField(); i++ {
+		name := jsonFieldName(typ.Field(i))
+		for _, jsonField := range jsonFields {
+			if name == jsonField {
+				fields = append(fields, typ.Field(i).Name)
+			}
+		}
+	}
+	return validate.StructPartial(input, fields...)
+}
+
 func ValidateUser(user user.User) error {
 	return validateStruct(user)
 }
 
+func ValidateUserPatch(user user.User, fields []string) error {
+	return validatePartial(user, fields)
+}
+
+func ValidateMembership(membership user.Membership) error {
+	return validateStruct(membership)
+}
+
+func ValidateRoleChange(roleChange user.RoleChange) error {
+	return validateStruct(roleChange)
+}
+
 func ValidateBook(book book.Book) error {
 	return validateStruct(book)
 }
 
+func ValidateBookPatch(book book.Book, fields []string) error {
+	return validatePartial(book, fields)
+}
+
 func ValidateBookBorrow(book book_borrow.BookBorrow) error {
 	return validateStruct(book)
 }
+
+func ValidateCopy(copy book_copy.Copy) error {
+	return validateStruct(copy)
+}
+
+func ValidateShelfAudit(audit book_copy.ShelfAudit) error {
+	return validateStruct(audit)
+}
+
+func ValidateHold(hold hold.Hold) error {
+	return validateStruct(hold)
+}
+
+func ValidateLedgerEntry(entry fine.LedgerEntry) error {
+	return validateStruct(entry)
+}
+
+func ValidateCredentials(credentials auth.Credentials) error {
+	return validateStruct(credentials)
+}
+
+func ValidateRefreshRequest(request auth.RefreshRequest) error {
+	return validateStruct(request)
+}
+
+func ValidateApiKey(key api_key.ApiKey) error {
+	return validateStruct(key)
+}